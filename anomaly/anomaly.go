@@ -0,0 +1,170 @@
+// Package anomaly scores how unusual payloads are compared to a learned schema,
+// for spotting drift in a live stream of payloads.
+//
+// Each way a payload does not fit the schema (see schemamerge.CheckOne) is a reason,
+// scored by how established the schema node is, so a null in a field seen a million times
+// scores much higher than a null in a field seen twice.
+// Values outside of the seen range are also scored by how far outside of the range they are,
+// and nulls in nullable fields by how rarely the field was seen as null.
+package anomaly
+
+import (
+	"context"
+	"github.com/lithictech/moxpopuli/jsontype"
+	"github.com/lithictech/moxpopuli/moxio"
+	. "github.com/lithictech/moxpopuli/schema"
+	"github.com/lithictech/moxpopuli/schemamerge"
+	"github.com/pkg/errors"
+	"math"
+	"sort"
+)
+
+type Reason struct {
+	Path    string                    `json:"path"`
+	Kind    schemamerge.ViolationKind `json:"kind"`
+	Score   float64                   `json:"score"`
+	Message string                    `json:"message"`
+}
+
+type PayloadScore struct {
+	// Index of the payload in the iterator, starting at 0.
+	Index int     `json:"index"`
+	Score float64 `json:"score"`
+	// Reasons contributing to the score, highest scores first.
+	Reasons []Reason `json:"reasons"`
+}
+
+type ScoreInput struct {
+	// The learned schema. It is not modified.
+	Schema          Schema
+	PayloadIterator moxio.Iterator
+	// Only include payloads with a score above this in the output.
+	// Payloads that fit the schema have a score of 0, so they are never included.
+	Threshold float64
+}
+
+type ScoreOutput struct {
+	// Payloads scoring above the threshold, in iterator order.
+	Payloads []PayloadScore `json:"payloads"`
+	// Number of payloads scored.
+	Scored int `json:"scored"`
+	// Highest score of any payload.
+	MaxScore float64 `json:"max_score"`
+}
+
+func Score(ctx context.Context, in ScoreInput) (ScoreOutput, error) {
+	out := ScoreOutput{}
+	for in.PayloadIterator.Next() {
+		payload, err := in.PayloadIterator.Read(ctx)
+		if err != nil {
+			return out, errors.Wrap(err, "payload loader iterator")
+		}
+		ps := ScoreOne(in.Schema, payload)
+		ps.Index = out.Scored
+		out.Scored++
+		out.MaxScore = math.Max(out.MaxScore, ps.Score)
+		if ps.Score > 0 && ps.Score > in.Threshold {
+			out.Payloads = append(out.Payloads, ps)
+		}
+	}
+	return out, nil
+}
+
+// Weights of each kind of violation, before scaling by how established the schema node is.
+// New properties are the most common kind of harmless drift, so are weighted lowest,
+// and a value of a never-seen type is the most likely to break consumers.
+var kindWeights = map[schemamerge.ViolationKind]float64{
+	schemamerge.V_UNKNOWN_PROPERTY: 0.5,
+	schemamerge.V_TYPE_MISMATCH:    2,
+	schemamerge.V_FORMAT_MISMATCH:  1,
+	schemamerge.V_OUT_OF_RANGE:     1,
+	schemamerge.V_UNEXPECTED_NULL:  1.5,
+	schemamerge.V_ENUM_MISS:        1.5,
+	schemamerge.V_NULL:             1.5,
+}
+
+// ScoreOne scores the payload against the schema. The score is the sum of the score of each reason,
+// which is the weight of its kind times log10(1 + samples of the schema node).
+// Out of range reasons are further multiplied by 1 + log10(1 + distance outside the seen range / size of the range),
+// so a value 100 times the seen maximum (of a range starting at 0) is weighted 3 times.
+// Nulls in nullable fields are multiplied by 1 - nulls / samples, so they only score
+// when the field is almost never null.
+func ScoreOne(sch Schema, payload interface{}) PayloadScore {
+	ps := PayloadScore{}
+	for _, v := range schemamerge.CheckOneWithNulls(sch, payload) {
+		score := kindWeights[v.Kind] * establishment(v.Schema)
+		if v.Kind == schemamerge.V_OUT_OF_RANGE {
+			score *= 1 + math.Log10(1+outside(v.Schema, v.Value.(float64)))
+		} else if v.Kind == schemamerge.V_NULL {
+			score *= nullRarity(v.Schema)
+		}
+		score = round(score)
+		if score == 0 {
+			continue
+		}
+		ps.Score += score
+		ps.Reasons = append(ps.Reasons, Reason{Path: v.Path, Kind: v.Kind, Score: score, Message: v.Message})
+	}
+	ps.Score = round(ps.Score)
+	sort.SliceStable(ps.Reasons, func(i, j int) bool { return ps.Reasons[i].Score > ps.Reasons[j].Score })
+	return ps
+}
+
+// establishment is log10(1 + samples), so each 10x more samples adds 1.
+// Nested schemas that were only derived once have no samples, so count as 1.
+func establishment(sch Schema) float64 {
+	return math.Log10(1 + math.Max(float64(samples(sch)), 1))
+}
+
+// samples returns how many times the schema was seen, including as null.
+// A oneOf has no samples of its own (see Schema.Nulls), so they are the sum of its schemas and nulls.
+func samples(sch Schema) int {
+	oneOf, ok := sch[P_ONE_OF]
+	if !ok {
+		return sch.Samples()
+	}
+	n := sch.Nulls()
+	for _, s := range CoerceSlice(oneOf) {
+		n += s.Samples()
+	}
+	return n
+}
+
+// nullRarity is 1 - nulls / samples, or 0 if nulls were not counted
+// (schemas learned before nulls were counted have none), since then how rare they are is not known.
+func nullRarity(sch Schema) float64 {
+	nulls := sch.Nulls()
+	if nulls == 0 {
+		return 0
+	}
+	return 1 - float64(nulls)/math.Max(float64(samples(sch)), float64(nulls))
+}
+
+// outside returns how far n is outside of the range of the schema,
+// as a multiple of the size of the range (or of the bound, if the range is smaller,
+// so a range of a single value is still meaningful).
+func outside(sch Schema, n float64) float64 {
+	minField, maxField := PX_SEEN_MINIMUM, PX_SEEN_MAXIMUM
+	if sch.Type() == jsontype.T_ARRAY {
+		minField, maxField = PX_SEEN_MIN_LENGTH, PX_SEEN_MAX_LENGTH
+	}
+	min, minOk := ToFloat(sch[minField])
+	max, maxOk := ToFloat(sch[maxField])
+	if !minOk || !maxOk {
+		return 0
+	}
+	var dist, bound float64
+	if n < min {
+		dist, bound = min-n, min
+	} else if n > max {
+		dist, bound = n-max, max
+	} else {
+		// Only outside of the declared range.
+		return 0
+	}
+	return dist / math.Max(math.Max(max-min, math.Abs(bound)), 1)
+}
+
+func round(f float64) float64 {
+	return math.Round(f*1000) / 1000
+}
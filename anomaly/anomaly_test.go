@@ -0,0 +1,101 @@
+package anomaly_test
+
+import (
+	"context"
+	"github.com/lithictech/moxpopuli/anomaly"
+	"github.com/lithictech/moxpopuli/internal/moxtest"
+	"github.com/lithictech/moxpopuli/moxio"
+	"github.com/lithictech/moxpopuli/schema"
+	"github.com/lithictech/moxpopuli/schemamerge"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestAnomaly(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "anomaly Suite")
+}
+
+var _ = Describe("anomaly", func() {
+	ctx := context.Background()
+	learn := func(n int) schema.Schema {
+		payloads := make([]interface{}, n)
+		for i := range payloads {
+			payloads[i] = map[string]interface{}{
+				"amount": 10 + i%10,
+				"status": []string{"active", "inactive"}[i%2],
+				"id":     i,
+			}
+		}
+		sch := moxtest.LearnSchema(ctx, payloads...)
+		sch.MustObject().Properties()["status"][schema.P_ENUM] = []string{"active", "inactive"}
+		return sch
+	}
+
+	It("scores payloads that fit the schema as 0", func() {
+		ps := anomaly.ScoreOne(learn(10), map[string]interface{}{"amount": 15, "status": "active", "id": 3})
+		Expect(ps.Score).To(BeZero())
+		Expect(ps.Reasons).To(BeEmpty())
+	})
+
+	It("scores violations higher the more established the schema is", func() {
+		payload := map[string]interface{}{"amount": 15, "status": "pending", "id": nil}
+		few := anomaly.ScoreOne(learn(9), payload)
+		many := anomaly.ScoreOne(learn(999), payload)
+		Expect(few.Reasons).To(ConsistOf(
+			And(HaveField("Path", "$.status"), HaveField("Kind", schemamerge.V_ENUM_MISS), HaveField("Score", 1.5)),
+			And(HaveField("Path", "$.id"), HaveField("Kind", schemamerge.V_UNEXPECTED_NULL), HaveField("Score", 1.5)),
+		))
+		Expect(few.Score).To(Equal(3.0))
+		Expect(many.Score).To(Equal(9.0))
+	})
+
+	It("scores nulls in nullable fields by how rarely they were null", func() {
+		nullable := func(nulls int) schema.Schema {
+			sch := learn(9)
+			id := sch.MustObject().Properties()["id"]
+			id[schema.PX_NULLABLE] = true
+			id[schema.PX_SAMPLES] = 1_000_000
+			id[schema.PX_NULLS] = nulls
+			return sch
+		}
+		payload := map[string]interface{}{"amount": 15, "id": nil}
+		rare := anomaly.ScoreOne(nullable(1), payload)
+		Expect(rare.Reasons).To(ConsistOf(
+			And(HaveField("Path", "$.id"), HaveField("Kind", schemamerge.V_NULL), HaveField("Score", 9.0)),
+		))
+		common := anomaly.ScoreOne(nullable(500_000), payload)
+		Expect(common.Score).To(Equal(4.5))
+		Expect(anomaly.ScoreOne(nullable(1_000_000), payload).Score).To(BeZero())
+		// Nulls were not counted, so how rare they are is not known.
+		Expect(anomaly.ScoreOne(nullable(0), payload).Reasons).To(BeEmpty())
+	})
+
+	It("scores values further outside of the seen range higher", func() {
+		sch := learn(9)
+		near := anomaly.ScoreOne(sch, map[string]interface{}{"amount": 20})
+		far := anomaly.ScoreOne(sch, map[string]interface{}{"amount": 1800})
+		Expect(near.Score).To(BeNumerically("~", 1.046, 0.001))
+		Expect(far.Score).To(BeNumerically("~", 3, 0.001))
+		Expect(far.Reasons).To(ConsistOf(HaveField("Message", "value 1800 outside of seen range 10 to 18")))
+	})
+
+	It("scores payloads from an iterator and orders reasons by score", func() {
+		out, err := anomaly.Score(ctx, anomaly.ScoreInput{
+			Schema: learn(9),
+			PayloadIterator: moxio.NewMemoryIterator([]interface{}{
+				map[string]interface{}{"amount": 15},
+				map[string]interface{}{"amount": 15, "new": true},
+				map[string]interface{}{"new": true, "id": "x"},
+			}),
+			Threshold: 1,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(out.Scored).To(Equal(3))
+		Expect(out.Payloads).To(HaveLen(1))
+		Expect(out.Payloads[0].Index).To(Equal(2))
+		Expect(out.Payloads[0].Reasons[0]).To(HaveField("Kind", schemamerge.V_TYPE_MISMATCH))
+		Expect(out.MaxScore).To(Equal(2.5))
+	})
+})
@@ -1,5 +1,7 @@
 package asyncapispec
 
+import "github.com/lithictech/moxpopuli/schema"
+
 type Channels map[string]interface{}
 
 func (c Channels) GetOrAddItem(key string) ChannelItem {
@@ -11,3 +13,81 @@ type ChannelItem map[string]interface{}
 func (c ChannelItem) GetOrAddSubscribe() Operation {
 	return getOrAddMap(c, "subscribe")
 }
+
+func (c ChannelItem) GetOrAddPublish() Operation {
+	return getOrAddMap(c, "publish")
+}
+
+func (c ChannelItem) GetOrAddBindings() ChannelBindings {
+	return getOrAddMap(c, "bindings")
+}
+
+func (c ChannelItem) GetOrAddParameters() Parameters {
+	return getOrAddMap(c, "parameters")
+}
+
+// Servers returns the names of the servers the channel is available on.
+// An empty result means the channel is available on all servers.
+func (c ChannelItem) Servers() []string {
+	names, ok := c["servers"].([]interface{})
+	if !ok {
+		return nil
+	}
+	r := make([]string, 0, len(names))
+	for _, n := range names {
+		r = append(r, n.(string))
+	}
+	return r
+}
+
+// AddServer adds the named server to the channel's servers if it is not already present.
+func (c ChannelItem) AddServer(name string) {
+	for _, n := range c.Servers() {
+		if n == name {
+			return
+		}
+	}
+	names, _ := c["servers"].([]interface{})
+	c["servers"] = append(names, name)
+}
+
+type Parameters map[string]interface{}
+
+func (p Parameters) GetOrAdd(key string) Parameter {
+	return getOrAddMap(p, key)
+}
+
+type Parameter map[string]interface{}
+
+func (p Parameter) GetOrAddSchema() schema.Schema {
+	return getOrAddSchema(p, "schema")
+}
+
+type ChannelBindings map[string]interface{}
+
+func (o ChannelBindings) GetOrAdd(key string) ChannelBinding {
+	return getOrAddMap(o, key)
+}
+
+func (o ChannelBindings) GetOrAddWs() WsChannelBinding {
+	return WsChannelBinding(o.GetOrAdd("ws"))
+}
+
+type ChannelBinding map[string]interface{}
+
+type WsChannelBinding map[string]interface{}
+
+func (o WsChannelBinding) Method() string {
+	if s, ok := o["method"]; ok {
+		return s.(string)
+	}
+	return ""
+}
+
+func (o WsChannelBinding) GetOrAddOrTypeQuery() schema.Schema {
+	return getOrAddSchema(o, "query")
+}
+
+func (o WsChannelBinding) GetOrAddOrTypeHeaders() schema.Schema {
+	return getOrAddSchema(o, "headers")
+}
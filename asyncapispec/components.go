@@ -0,0 +1,111 @@
+package asyncapispec
+
+import (
+	"github.com/lithictech/moxpopuli/schema"
+	"strings"
+)
+
+func (s Specification) GetOrAddComponents() Components {
+	return getOrAddMap(s, "components")
+}
+
+type Components map[string]interface{}
+
+func (c Components) GetOrAddMessageTraits() MessageTraits {
+	return getOrAddMap(c, "messageTraits")
+}
+
+type MessageTraits map[string]interface{}
+
+func (t MessageTraits) GetOrAdd(key string) MessageTrait {
+	return getOrAddMap(t, key)
+}
+
+type MessageTrait map[string]interface{}
+
+func (t MessageTrait) GetOrAddHeaders() schema.Schema {
+	return getOrAddSchema(t, "headers")
+}
+
+// GetOrAddSchema returns the schema in the named field, like an extension field.
+func (t MessageTrait) GetOrAddSchema(key string) schema.Schema {
+	return getOrAddSchema(t, key)
+}
+
+const messageTraitRefPrefix = "#/components/messageTraits/"
+
+// MessageTraitRef returns the $ref used to point to the named trait from a message.
+func MessageTraitRef(name string) string {
+	return messageTraitRefPrefix + name
+}
+
+// ResolveMessageTrait returns the trait the $ref points to.
+// Only local references to components.messageTraits are supported.
+func (s Specification) ResolveMessageTrait(ref string) (MessageTrait, bool) {
+	if !strings.HasPrefix(ref, messageTraitRefPrefix) {
+		return nil, false
+	}
+	comps, ok := s["components"].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	traits, ok := comps["messageTraits"].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	t, ok := traits[ref[len(messageTraitRefPrefix):]].(map[string]interface{})
+	return t, ok
+}
+
+func (c Components) GetOrAddSecuritySchemes() SecuritySchemes {
+	return getOrAddMap(c, "securitySchemes")
+}
+
+type SecuritySchemes map[string]interface{}
+
+func (s SecuritySchemes) GetOrAdd(key string) SecurityScheme {
+	return getOrAddMap(s, key)
+}
+
+type SecurityScheme map[string]interface{}
+
+func (s SecurityScheme) stringField(key string) string {
+	if v, ok := s[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+// Type is the scheme type, like 'http' or 'httpApiKey'.
+func (s SecurityScheme) Type() string {
+	return s.stringField("type")
+}
+
+// Scheme is the HTTP Authorization scheme for 'http' schemes, like 'bearer' or 'basic'.
+func (s SecurityScheme) Scheme() string {
+	return s.stringField("scheme")
+}
+
+// Name is the header or query parameter name for 'httpApiKey' schemes.
+func (s SecurityScheme) Name() string {
+	return s.stringField("name")
+}
+
+// In is 'header' or 'query' for 'httpApiKey' schemes.
+func (s SecurityScheme) In() string {
+	return s.stringField("in")
+}
+
+// ResolveSecurityScheme returns the named scheme from components.securitySchemes.
+func (s Specification) ResolveSecurityScheme(name string) (SecurityScheme, bool) {
+	comps, ok := s["components"].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	schemes, ok := comps["securitySchemes"].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	scheme, ok := schemes[name].(map[string]interface{})
+	return scheme, ok
+}
@@ -0,0 +1,113 @@
+package asyncapispec
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Discriminator identifies the event type of a message,
+// for channels that carry several event types (like webhook endpoints).
+// Exactly one of Header and Path is set on a non-zero Discriminator.
+type Discriminator struct {
+	// Header is the name of the header carrying the event type, like 'X-GitHub-Event'.
+	Header string
+	// Path is the path to the payload field carrying the event type, like ["data", "type"].
+	Path []string
+}
+
+const (
+	headerLocationPrefix  = "$message.header#/"
+	payloadLocationPrefix = "$message.payload#/"
+)
+
+func (d Discriminator) IsZero() bool {
+	return d.Header == "" && len(d.Path) == 0
+}
+
+// Location returns the discriminator as an AsyncAPI runtime expression,
+// like '$message.header#/X-GitHub-Event' or '$message.payload#/data/type'.
+func (d Discriminator) Location() string {
+	if d.Header != "" {
+		return headerLocationPrefix + d.Header
+	}
+	return payloadLocationPrefix + strings.Join(d.Path, "/")
+}
+
+// ParseDiscriminatorLocation parses a runtime expression returned from Discriminator.Location.
+func ParseDiscriminatorLocation(loc string) (Discriminator, bool) {
+	if strings.HasPrefix(loc, headerLocationPrefix) && len(loc) > len(headerLocationPrefix) {
+		return Discriminator{Header: loc[len(headerLocationPrefix):]}, true
+	}
+	if strings.HasPrefix(loc, payloadLocationPrefix) && len(loc) > len(payloadLocationPrefix) {
+		return Discriminator{Path: strings.Split(loc[len(payloadLocationPrefix):], "/")}, true
+	}
+	return Discriminator{}, false
+}
+
+// Value returns the event type from the headers or payload.
+// Only scalar payload values are used.
+func (d Discriminator) Value(headers map[string]string, payload interface{}) (string, bool) {
+	if d.Header != "" {
+		for k, v := range headers {
+			if strings.EqualFold(k, d.Header) {
+				return v, v != ""
+			}
+		}
+		return "", false
+	}
+	v := payload
+	for _, key := range d.Path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if v, ok = m[key]; !ok {
+			return "", false
+		}
+	}
+	switch vt := v.(type) {
+	case string:
+		return vt, vt != ""
+	case float64, int, bool:
+		return fmt.Sprintf("%v", vt), true
+	}
+	return "", false
+}
+
+// Set sets the event type into the headers or payload, creating intermediate payload objects as needed.
+// Payloads that are not objects are left alone.
+func (d Discriminator) Set(headers map[string]interface{}, payload interface{}, value string) {
+	if d.Header != "" {
+		headers[d.Header] = value
+		return
+	}
+	m, ok := payload.(map[string]interface{})
+	if !ok || len(d.Path) == 0 {
+		return
+	}
+	for _, key := range d.Path[:len(d.Path)-1] {
+		next, ok := m[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			m[key] = next
+		}
+		m = next
+	}
+	m[d.Path[len(d.Path)-1]] = value
+}
+
+const operationDiscriminatorField = "x-messageDiscriminator"
+
+// Discriminator returns the discriminator used to split the operation's 'message.oneOf', if any.
+// Each message's 'name' is the event type.
+func (o Operation) Discriminator() (Discriminator, bool) {
+	loc, ok := o[operationDiscriminatorField].(string)
+	if !ok {
+		return Discriminator{}, false
+	}
+	return ParseDiscriminatorLocation(loc)
+}
+
+func (o Operation) SetDiscriminator(d Discriminator) {
+	o[operationDiscriminatorField] = d.Location()
+}
@@ -19,6 +19,66 @@ func (o Message) GetOrAddHeaders() schema.Schema {
 	return getOrAddSchema(o, "headers")
 }
 
+func (o Message) Name() string {
+	if s, ok := o["name"]; ok {
+		return s.(string)
+	}
+	return ""
+}
+
+// TraitRefs returns the $ref of each of the message's traits.
+func (o Message) TraitRefs() []string {
+	traits, ok := o["traits"].([]interface{})
+	if !ok {
+		return nil
+	}
+	r := make([]string, 0, len(traits))
+	for _, t := range traits {
+		if ref, ok := t.(map[string]interface{})["$ref"].(string); ok {
+			r = append(r, ref)
+		}
+	}
+	return r
+}
+
+// AddTraitRef adds a $ref to the message's traits if it is not already present.
+func (o Message) AddTraitRef(ref string) {
+	for _, r := range o.TraitRefs() {
+		if r == ref {
+			return
+		}
+	}
+	traits, _ := o["traits"].([]interface{})
+	o["traits"] = append(traits, map[string]interface{}{"$ref": ref})
+}
+
+// Examples returns the message's recorded examples.
+func (o Message) Examples() []MessageExample {
+	examples, ok := o["examples"].([]interface{})
+	if !ok {
+		return nil
+	}
+	r := make([]MessageExample, len(examples))
+	for i, e := range examples {
+		r[i] = e.(map[string]interface{})
+	}
+	return r
+}
+
+// MessageExample is a pair of headers and payload that represents a single message.
+type MessageExample map[string]interface{}
+
+func (e MessageExample) Headers() map[string]interface{} {
+	if h, ok := e["headers"].(map[string]interface{}); ok {
+		return h
+	}
+	return nil
+}
+
+func (e MessageExample) Payload() interface{} {
+	return e["payload"]
+}
+
 func (o Message) ContentType() string {
 	if s, ok := o["contentType"]; ok {
 		return s.(string)
@@ -12,6 +12,52 @@ func (o Operation) GetOrAddMessage() Message {
 	return getOrAddMap(o, "message")
 }
 
+// GetOrAddMessageNamed returns the message with the given name from the operation's 'message.oneOf',
+// adding it if needed. If the operation has a single message, it is moved into 'oneOf'.
+// Use an empty name for the message without a name.
+func (o Operation) GetOrAddMessageNamed(name string) Message {
+	msg := o.GetOrAddMessage()
+	if _, ok := msg["oneOf"]; !ok {
+		single := make(map[string]interface{}, len(msg))
+		for k, v := range msg {
+			single[k] = v
+			delete(msg, k)
+		}
+		oneOf := make([]interface{}, 0, 2)
+		if len(single) > 0 {
+			oneOf = append(oneOf, single)
+		}
+		msg["oneOf"] = oneOf
+	}
+	oneOf := msg["oneOf"].([]interface{})
+	for _, m := range oneOf {
+		if mm := m.(map[string]interface{}); Message(mm).Name() == name {
+			return mm
+		}
+	}
+	added := map[string]interface{}{}
+	if name != "" {
+		added["name"] = name
+	}
+	msg["oneOf"] = append(oneOf, added)
+	return added
+}
+
+// Messages returns each message in the operation's 'message.oneOf',
+// or the single message if there is no 'oneOf'.
+func (o Operation) Messages() []Message {
+	msg := o.GetOrAddMessage()
+	oneOf, ok := msg["oneOf"].([]interface{})
+	if !ok {
+		return []Message{msg}
+	}
+	r := make([]Message, len(oneOf))
+	for i, m := range oneOf {
+		r[i] = m.(map[string]interface{})
+	}
+	return r
+}
+
 type OperationBindings map[string]interface{}
 
 func (o OperationBindings) GetOrAdd(key string) OperationBinding {
@@ -36,3 +82,26 @@ func (o HttpOperationBinding) Method() string {
 func (o HttpOperationBinding) GetOrAddOrTypeQuery() schema.Schema {
 	return getOrAddSchema(o, "query")
 }
+
+func (o OperationBindings) GetOrAddMqtt() MqttOperationBinding {
+	return MqttOperationBinding(o.GetOrAdd("mqtt"))
+}
+
+type MqttOperationBinding map[string]interface{}
+
+func (o MqttOperationBinding) Qos() int {
+	switch q := o["qos"].(type) {
+	case int:
+		return q
+	case float64:
+		return int(q)
+	}
+	return 0
+}
+
+func (o MqttOperationBinding) Retain() bool {
+	if b, ok := o["retain"].(bool); ok {
+		return b
+	}
+	return false
+}
@@ -15,3 +15,55 @@ func (s Server) Protocol() string {
 func (s Server) Url() string {
 	return s["url"].(string)
 }
+
+func (s Server) GetOrAddBindings() ServerBindings {
+	return getOrAddMap(s, "bindings")
+}
+
+type ServerBindings map[string]interface{}
+
+func (o ServerBindings) GetOrAdd(key string) ServerBinding {
+	return getOrAddMap(o, key)
+}
+
+func (o ServerBindings) GetOrAddMqtt() MqttServerBinding {
+	return MqttServerBinding(o.GetOrAdd("mqtt"))
+}
+
+type ServerBinding map[string]interface{}
+
+type MqttServerBinding map[string]interface{}
+
+func (o MqttServerBinding) ClientId() string {
+	if s, ok := o["clientId"]; ok {
+		return s.(string)
+	}
+	return ""
+}
+
+// SecurityRequirements returns the names of the security schemes the server requires.
+func (s Server) SecurityRequirements() []string {
+	reqs, ok := s["security"].([]interface{})
+	if !ok {
+		return nil
+	}
+	r := make([]string, 0, len(reqs))
+	for _, req := range reqs {
+		for name := range req.(map[string]interface{}) {
+			r = append(r, name)
+		}
+	}
+	return r
+}
+
+// AddSecurityRequirement adds the named security scheme to the server's requirements
+// if it is not already present.
+func (s Server) AddSecurityRequirement(name string) {
+	for _, n := range s.SecurityRequirements() {
+		if n == name {
+			return
+		}
+	}
+	reqs, _ := s["security"].([]interface{})
+	s["security"] = append(reqs, map[string]interface{}{name: []interface{}{}})
+}
@@ -0,0 +1,341 @@
+package asyncapispec
+
+import (
+	"github.com/pkg/errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Mox Populi learns specs in the AsyncAPI 2.x structure (channels keyed by address,
+// with 'subscribe' and 'publish' operations holding their messages).
+// Upgrade and Downgrade convert to and from the AsyncAPI 3.0 structure,
+// where channels, operations, and messages are separate.
+// See https://www.asyncapi.com/docs/migration/migrating-to-v3
+
+const (
+	Version2 = "2.6.0"
+	Version3 = "3.0.0"
+)
+
+// MajorVersion returns the major version of the document's 'asyncapi' field, or 0 if it is not set.
+func (s Specification) MajorVersion() int {
+	v, _ := s["asyncapi"].(string)
+	major, _ := ParseMajorVersion(v)
+	return major
+}
+
+// ParseMajorVersion parses versions like '3', '3.0', or '3.0.0' into their major version.
+// An empty string is 0.
+func ParseMajorVersion(v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	majorStr, _, _ := strings.Cut(v, ".")
+	major, err := strconv.Atoi(majorStr)
+	if err != nil || (major != 2 && major != 3) {
+		return 0, errors.Errorf("unsupported AsyncAPI version '%s'", v)
+	}
+	return major, nil
+}
+
+// Upgrade returns the 2.x document in the AsyncAPI 3.0 structure.
+// The result shares nested values (like schemas) with the original.
+//
+// Channels are keyed by an id derived from their address,
+// and messages are keyed by their name (or the operation action, if unnamed).
+// 'subscribe' operations become 'receive' operations, and 'publish' operations become 'send' operations.
+// Learned channel parameter schemas are kept in 'x-schema', since 3.0 parameters do not have schemas.
+func Upgrade(s Specification) Specification {
+	r := make(Specification, len(s)+1)
+	for k, v := range s {
+		if k != "channels" && k != "servers" {
+			r[k] = v
+		}
+	}
+	r["asyncapi"] = Version3
+	if servers, ok := s["servers"].(map[string]interface{}); ok {
+		servers3 := make(map[string]interface{}, len(servers))
+		for name, srv := range servers {
+			servers3[name] = upgradeServer(srv.(map[string]interface{}))
+		}
+		r["servers"] = servers3
+	}
+	channels, _ := s["channels"].(map[string]interface{})
+	if len(channels) == 0 {
+		return r
+	}
+	channels3 := make(map[string]interface{}, len(channels))
+	operations := make(map[string]interface{}, len(channels))
+	channelIds := make(map[string]struct{}, len(channels))
+	for _, address := range sortedKeys(channels) {
+		ch := channels[address].(map[string]interface{})
+		channelId := uniqueId(address, channelIds)
+		ch3 := map[string]interface{}{"address": address}
+		for k, v := range ch {
+			switch k {
+			case "subscribe", "publish":
+			case "parameters":
+				ch3[k] = upgradeParameters(v.(map[string]interface{}))
+			default:
+				ch3[k] = v
+			}
+		}
+		messages := make(map[string]interface{}, 2)
+		messageIds := make(map[string]struct{}, 2)
+		for _, opKey := range []string{"subscribe", "publish"} {
+			op, ok := ch[opKey].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			action := v2OperationActions[opKey]
+			op3 := map[string]interface{}{
+				"action":  action,
+				"channel": map[string]interface{}{"$ref": "#/channels/" + channelId},
+			}
+			for k, v := range op {
+				if k != "message" {
+					op3[k] = v
+				}
+			}
+			refs := make([]interface{}, 0, 1)
+			if _, ok := op["message"]; ok {
+				for _, msg := range Operation(op).Messages() {
+					name := msg.Name()
+					if name == "" {
+						name = action + "Message"
+					}
+					messageId := uniqueId(name, messageIds)
+					messages[messageId] = map[string]interface{}(msg)
+					refs = append(refs, map[string]interface{}{"$ref": "#/channels/" + channelId + "/messages/" + messageId})
+				}
+			}
+			op3["messages"] = refs
+			operations[channelId+"_"+action] = op3
+		}
+		if len(messages) > 0 {
+			ch3["messages"] = messages
+		}
+		channels3[channelId] = ch3
+	}
+	r["channels"] = channels3
+	r["operations"] = operations
+	return r
+}
+
+// Downgrade returns the 3.0 document in the AsyncAPI 2.x structure, for merging.
+// It is the inverse of Upgrade; messages and channels not used by any operation are dropped.
+func Downgrade(s Specification) (Specification, error) {
+	r := make(Specification, len(s))
+	for k, v := range s {
+		if k != "channels" && k != "servers" && k != "operations" {
+			r[k] = v
+		}
+	}
+	r["asyncapi"] = Version2
+	if servers, ok := s["servers"].(map[string]interface{}); ok {
+		servers2 := make(map[string]interface{}, len(servers))
+		for name, srv := range servers {
+			servers2[name] = downgradeServer(srv.(map[string]interface{}))
+		}
+		r["servers"] = servers2
+	}
+	channels, _ := s["channels"].(map[string]interface{})
+	channels2 := make(map[string]interface{}, len(channels))
+	addresses := make(map[string]string, len(channels))
+	for channelId, chv := range channels {
+		ch := chv.(map[string]interface{})
+		address, _ := ch["address"].(string)
+		if address == "" {
+			address = channelId
+		}
+		addresses[channelId] = address
+		ch2 := make(map[string]interface{}, len(ch))
+		for k, v := range ch {
+			switch k {
+			case "address", "messages":
+			case "parameters":
+				ch2[k] = downgradeParameters(v.(map[string]interface{}))
+			default:
+				ch2[k] = v
+			}
+		}
+		channels2[address] = ch2
+	}
+	operations, _ := s["operations"].(map[string]interface{})
+	for _, opId := range sortedKeys(operations) {
+		op := operations[opId].(map[string]interface{})
+		channelRef, _ := op["channel"].(map[string]interface{})["$ref"].(string)
+		channelId := strings.TrimPrefix(channelRef, "#/channels/")
+		address, ok := addresses[channelId]
+		if !ok {
+			return nil, errors.Errorf("operation '%s' refers to unknown channel '%s'", opId, channelRef)
+		}
+		opKey, ok := v3OperationKeys[op["action"]]
+		if !ok {
+			return nil, errors.Errorf("operation '%s' has invalid action '%v'", opId, op["action"])
+		}
+		op2 := make(map[string]interface{}, len(op))
+		for k, v := range op {
+			if k != "action" && k != "channel" && k != "messages" {
+				op2[k] = v
+			}
+		}
+		refs, _ := op["messages"].([]interface{})
+		messages := make([]interface{}, 0, len(refs))
+		for _, ref := range refs {
+			msgRef, _ := ref.(map[string]interface{})["$ref"].(string)
+			msg, ok := resolveLocalRef(s, msgRef)
+			if !ok {
+				return nil, errors.Errorf("operation '%s' refers to unknown message '%s'", opId, msgRef)
+			}
+			messages = append(messages, msg)
+		}
+		if len(messages) == 1 {
+			op2["message"] = messages[0]
+		} else if len(messages) > 1 {
+			op2["message"] = map[string]interface{}{"oneOf": messages}
+		}
+		channels2[address].(map[string]interface{})[opKey] = op2
+	}
+	r["channels"] = channels2
+	return r, nil
+}
+
+var v2OperationActions = map[string]string{"subscribe": "receive", "publish": "send"}
+var v3OperationKeys = map[interface{}]string{"receive": "subscribe", "send": "publish"}
+
+const securitySchemeRefPrefix = "#/components/securitySchemes/"
+
+func upgradeServer(srv map[string]interface{}) map[string]interface{} {
+	r := make(map[string]interface{}, len(srv))
+	for k, v := range srv {
+		switch k {
+		case "url":
+			u := v.(string)
+			if _, afterScheme, ok := strings.Cut(u, "://"); ok {
+				u = afterScheme
+			}
+			host, pathname, hasPath := strings.Cut(u, "/")
+			r["host"] = host
+			if hasPath {
+				r["pathname"] = "/" + pathname
+			}
+		case "security":
+			reqs := Server(srv).SecurityRequirements()
+			refs := make([]interface{}, len(reqs))
+			for i, name := range reqs {
+				refs[i] = map[string]interface{}{"$ref": securitySchemeRefPrefix + name}
+			}
+			r[k] = refs
+		default:
+			r[k] = v
+		}
+	}
+	return r
+}
+
+func downgradeServer(srv map[string]interface{}) map[string]interface{} {
+	r := make(map[string]interface{}, len(srv))
+	for k, v := range srv {
+		switch k {
+		case "host":
+			pathname, _ := srv["pathname"].(string)
+			r["url"] = v.(string) + pathname
+		case "pathname":
+		case "security":
+			refs, _ := v.([]interface{})
+			reqs := make([]interface{}, 0, len(refs))
+			for _, ref := range refs {
+				if refStr, ok := ref.(map[string]interface{})["$ref"].(string); ok {
+					reqs = append(reqs, map[string]interface{}{strings.TrimPrefix(refStr, securitySchemeRefPrefix): []interface{}{}})
+				}
+			}
+			r[k] = reqs
+		default:
+			r[k] = v
+		}
+	}
+	return r
+}
+
+func upgradeParameters(params map[string]interface{}) map[string]interface{} {
+	r := make(map[string]interface{}, len(params))
+	for name, pv := range params {
+		p := pv.(map[string]interface{})
+		p3 := make(map[string]interface{}, len(p))
+		for k, v := range p {
+			if k == "schema" {
+				p3["x-schema"] = v
+			} else {
+				p3[k] = v
+			}
+		}
+		r[name] = p3
+	}
+	return r
+}
+
+func downgradeParameters(params map[string]interface{}) map[string]interface{} {
+	r := make(map[string]interface{}, len(params))
+	for name, pv := range params {
+		p := pv.(map[string]interface{})
+		p2 := make(map[string]interface{}, len(p))
+		for k, v := range p {
+			if k == "x-schema" {
+				p2["schema"] = v
+			} else {
+				p2[k] = v
+			}
+		}
+		r[name] = p2
+	}
+	return r
+}
+
+// uniqueId returns an id like 'devices_deviceId_telemetry' for 'devices/{deviceId}/telemetry',
+// which is not in seen, and adds it to seen.
+func uniqueId(s string, seen map[string]struct{}) string {
+	base := strings.Trim(idReplacement.ReplaceAllString(s, "_"), "_")
+	if base == "" {
+		base = "root"
+	}
+	id := base
+	for i := 2; ; i++ {
+		if _, ok := seen[id]; !ok {
+			break
+		}
+		id = base + "_" + strconv.Itoa(i)
+	}
+	seen[id] = struct{}{}
+	return id
+}
+
+var idReplacement = regexp.MustCompile(`[^A-Za-z0-9_.\-]+`)
+
+// resolveLocalRef resolves a JSON Pointer reference like '#/channels/abc/messages/xyz' within the document.
+func resolveLocalRef(doc map[string]interface{}, ref string) (map[string]interface{}, bool) {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, false
+	}
+	current := doc
+	for _, segment := range strings.Split(ref[2:], "/") {
+		segment = strings.ReplaceAll(strings.ReplaceAll(segment, "~1", "/"), "~0", "~")
+		next, ok := current[segment].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current = next
+	}
+	return current, true
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -4,11 +4,28 @@ import (
 	"context"
 	"github.com/lithictech/moxpopuli/asyncapispecmerge/httpmerge"
 	"github.com/lithictech/moxpopuli/asyncapispecmerge/internal"
+	"github.com/lithictech/moxpopuli/asyncapispecmerge/mqttmerge"
+	"github.com/lithictech/moxpopuli/asyncapispecmerge/specmerge"
+	"github.com/lithictech/moxpopuli/asyncapispecmerge/wsmerge"
 )
 
 type MergeHttpEvent = httpmerge.HttpEvent
 
-var MergeHttp Merge = httpmerge.MergeHttp
+var MergeHttp = Merge(specmerge.Sharded(httpmerge.MergeHttp))
+
+type MergeMqttEvent = mqttmerge.MqttEvent
+
+var MergeMqtt = Merge(specmerge.Sharded(mqttmerge.MergeMqtt))
+
+type MergeWsEvent = wsmerge.WsEvent
+
+var MergeWs = Merge(specmerge.Sharded(wsmerge.MergeWs))
 
 type MergeInput = internal.MergeInput
 type Merge func(context.Context, MergeInput) error
+
+var ParseDiscriminator = internal.ParseDiscriminator
+
+type MergeSpecsInput = specmerge.MergeSpecsInput
+
+var MergeSpecs = specmerge.MergeSpecs
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/lithictech/moxpopuli/asyncapispec"
 	"github.com/lithictech/moxpopuli/asyncapispecmerge/internal"
+	"github.com/lithictech/moxpopuli/cloudevents"
 	moxinternal "github.com/lithictech/moxpopuli/internal"
 	"github.com/lithictech/moxpopuli/schema"
 	"github.com/lithictech/moxpopuli/schemamerge"
@@ -16,14 +17,19 @@ import (
 func MergeHttp(ctx context.Context, in internal.MergeInput) error {
 	channels := in.Spec.GetOrAddChannels()
 	servers := in.Spec.GetOrAddServers()
-	for in.EventIterator.Next() {
-		event, err := in.EventIterator.Read(ctx)
+	events := internal.WithPositions(in.EventIterator)
+	for events.Next() {
+		event, position, err := internal.ReadEvent(ctx, events)
 		if err != nil {
 			return errors.Wrap(err, "reading events")
 		}
 		var hevent HttpEvent
 		if hev, ok := event.(HttpEvent); ok {
 			hevent = hev
+			if hevent.CanonicalHeaders == nil {
+				// Not set when the event is decoded from JSON, like in the server.
+				hevent.CanonizeHeaders()
+			}
 		} else if mapev, ok := event.(map[string]interface{}); ok {
 			hevent, err = NewHttpEvent(mapev)
 			if err != nil {
@@ -36,6 +42,16 @@ func MergeHttp(ctx context.Context, in internal.MergeInput) error {
 		if err != nil {
 			return errors.Wrap(err, "could not parse event path")
 		}
+		var srv asyncapispec.Server
+		if host := hevent.ServerHost(eventUrl); host != "" {
+			srv = servers.GetOrAddServer(host)
+			srv["url"] = host
+			srv["protocol"] = "http"
+			if version, ok := hevent.CanonicalHeaders["version"]; ok {
+				srv["protocolVersion"] = moxinternal.LastString(strings.Split(version, "/"))
+			}
+		}
+		headers, query := internal.MergeSecurity(in.Spec, srv, hevent.Headers, eventUrl.Query())
 		chanItem := channels.GetOrAddItem(eventUrl.Path)
 		subscribe := chanItem.GetOrAddSubscribe()
 		bindings := subscribe.GetOrAddBindings()
@@ -43,7 +59,7 @@ func MergeHttp(ctx context.Context, in internal.MergeInput) error {
 		httpBinding["type"] = "request"
 		httpBinding["method"] = hevent.Method
 		q := httpBinding.GetOrAddOrTypeQuery()
-		queryMergeResult, err := schemamerge.MergeOne(ctx, schemamerge.MergeOneInput{Schema: q, Payload: moxinternal.UrlValuesToMap(eventUrl.Query())})
+		queryMergeResult, err := schemamerge.MergeOne(ctx, schemamerge.MergeOneInput{Schema: q, Payload: moxinternal.UrlValuesToMap(query)})
 		if err != nil {
 			return errors.Wrap(err, "merging query")
 		}
@@ -52,26 +68,46 @@ func MergeHttp(ctx context.Context, in internal.MergeInput) error {
 		} else {
 			delete(httpBinding, "query")
 		}
-		message := subscribe.GetOrAddMessage()
-		if err := mergeHttpMessage(ctx, message, hevent); err != nil {
-			return err
-		}
-		if host, ok := hevent.CanonicalHeaders["host"]; ok {
-			srv := servers.GetOrAddServer(host)
-			srv["url"] = host
-			srv["protocol"] = "http"
-			if version, ok := hevent.CanonicalHeaders["version"]; ok {
-				srv["protocolVersion"] = moxinternal.LastString(strings.Split(version, "/"))
+		var message asyncapispec.Message
+		var body interface{} = hevent.Body
+		if ce, ok := cloudevents.Detect(hevent.Headers, hevent.Body); ok {
+			message, err = internal.MergeCloudEvent(ctx, in.Spec, subscribe, ce)
+			if err != nil {
+				return err
 			}
+			body = ce.Data
+			headers = withoutCloudEventHeaders(headers)
+		} else {
+			message = internal.DiscriminatedMessage(subscribe, in.Discriminator, headers, body)
+		}
+		if err := mergeHttpMessage(ctx, message, headers, body, in.ExampleLimit, in.Seen(body, position)); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func mergeHttpMessage(ctx context.Context, message asyncapispec.Message, event HttpEvent) error {
+func withoutCloudEventHeaders(headers map[string]string) map[string]string {
+	r := make(map[string]string, len(headers))
+	for k, v := range headers {
+		if !cloudevents.IsHeader(k) {
+			r[k] = v
+		}
+	}
+	return r
+}
+
+func mergeHttpMessage(
+	ctx context.Context,
+	message asyncapispec.Message,
+	headers map[string]string,
+	body interface{},
+	exampleLimit *int,
+	seen *schema.Provenance,
+) error {
 	appHeaders := make(map[string]interface{}, 8)
 	protoHeaders := make(map[string]interface{}, 8)
-	for headerName, headervalue := range event.Headers {
+	for headerName, headervalue := range headers {
 		canonicalHeader := internal.CanonicalHeader(headerName)
 		if _, ok := ignoreHeaders[canonicalHeader]; ok {
 			continue
@@ -87,33 +123,45 @@ func mergeHttpMessage(ctx context.Context, message asyncapispec.Message, event H
 			appHeaders[headerName] = headervalue
 		}
 	}
-	setProtocolHeaders(message.GetOrAddBindings().GetOrAddHttp(), protoHeaders)
+	if err := mergeProtocolHeaders(ctx, message.GetOrAddBindings().GetOrAddHttp(), protoHeaders); err != nil {
+		return err
+	}
 	if _, ok := message["contentType"]; !ok {
 		message["contentType"] = "application/json"
 	}
 
-	headerMergeResult, err := schemamerge.MergeOne(ctx, schemamerge.MergeOneInput{Schema: message.GetOrAddHeaders(), Payload: appHeaders})
+	headerMergeResult, err := schemamerge.MergeOne(ctx, schemamerge.MergeOneInput{Schema: message.GetOrAddHeaders(), Payload: appHeaders, Seen: seen})
 	if err != nil {
 		return errors.Wrap(err, "merging message headers")
 	}
 	message["headers"] = headerMergeResult.Schema
 
-	payloadMergeResult, err := schemamerge.MergeOne(ctx, schemamerge.MergeOneInput{Schema: message.GetOrAddPayload(), Payload: event.Body})
+	payloadMergeResult, err := schemamerge.MergeOne(ctx, schemamerge.MergeOneInput{Schema: message.GetOrAddPayload(), Payload: body, Seen: seen})
 	if err != nil {
 		return errors.Wrap(err, "merging payload headers")
 	}
 	message["payload"] = payloadMergeResult.Schema
+	internal.RecordExample(ctx, message, exampleLimit, headerMergeResult.TypeChanged || payloadMergeResult.TypeChanged, appHeaders, body)
 	return nil
 }
 
-func setProtocolHeaders(http asyncapispec.HttpMessageBinding, headers map[string]interface{}) {
-	headerProps := http.GetOrAddHeaders().Properties()
-	for k, v := range headers {
-		headerProps[k] = schema.Schema{
-			schema.P_TYPE:        "string",
-			schema.PX_LAST_VALUE: v,
-		}
+// mergeProtocolHeaders merges headers defined by HTTP, rather than the application,
+// into the message's http binding. Credentials in headers like Cookie are redacted
+// during schema merging, see redact.IsCredentialKey.
+func mergeProtocolHeaders(ctx context.Context, http asyncapispec.HttpMessageBinding, headers map[string]interface{}) error {
+	headerSchema := http.GetOrAddHeaders()
+	props := headerSchema.Properties()
+	for _, prop := range props {
+		// Older specs stored the raw header value.
+		delete(prop, schema.PX_LAST_VALUE)
+	}
+	headerSchema[schema.P_PROPERTIES] = props
+	headerMergeResult, err := schemamerge.MergeOne(ctx, schemamerge.MergeOneInput{Schema: schema.Schema(headerSchema), Payload: headers})
+	if err != nil {
+		return errors.Wrap(err, "merging protocol headers")
 	}
+	http["headers"] = headerMergeResult.Schema
+	return nil
 }
 
 type HttpEvent struct {
@@ -122,6 +170,20 @@ type HttpEvent struct {
 	Headers          map[string]string      `json:"headers" description:"All headers for the HTTP request."`
 	CanonicalHeaders map[string]string      `json:"-"`
 	Body             map[string]interface{} `json:"body" description:"HTTP body. Only objects are supported for now."`
+	ListenAddr       string                 `json:"listen_addr" description:"Address the request was received on, like 'localhost:8080'. Used as the server when the request has no Host header."`
+}
+
+// ServerHost returns the host the request was sent to.
+// This is the Host header, or the host of an absolute request URI,
+// or the listener address if the request does not say.
+func (h HttpEvent) ServerHost(requestUri *url.URL) string {
+	if host, ok := h.CanonicalHeaders["host"]; ok && host != "" {
+		return host
+	}
+	if requestUri.Host != "" {
+		return requestUri.Host
+	}
+	return h.ListenAddr
 }
 
 func (h *HttpEvent) CanonizeHeaders() {
@@ -175,6 +237,13 @@ func NewHttpEvent(e map[string]interface{}) (HttpEvent, error) {
 	} else {
 		return h, errors.New("event requires 'body' key")
 	}
+	if v, ok := e["listen_addr"]; ok && v != nil {
+		if vt, ok := v.(string); ok {
+			h.ListenAddr = vt
+		} else {
+			return h, errors.New("event listen_addr must be a string")
+		}
+	}
 	return h, nil
 }
 
@@ -0,0 +1,195 @@
+package httpmerge_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/lithictech/moxpopuli/asyncapispec"
+	"github.com/lithictech/moxpopuli/asyncapispecmerge/httpmerge"
+	"github.com/lithictech/moxpopuli/asyncapispecmerge/internal"
+	"github.com/lithictech/moxpopuli/moxio"
+	"github.com/lithictech/moxpopuli/schema"
+	"github.com/lithictech/moxpopuli/schemamerge"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"testing"
+	"time"
+)
+
+func TestHttpmerge(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "httpmerge Suite")
+}
+
+var _ = Describe("httpmerge", func() {
+	ctx := context.Background()
+
+	events := func() moxio.Iterator {
+		event := func(body map[string]interface{}) map[string]interface{} {
+			return map[string]interface{}{
+				"path":    "/webhook",
+				"method":  "POST",
+				"headers": map[string]interface{}{"Host": "localhost:8080", "X-Tenant": "acme"},
+				"body":    body,
+			}
+		}
+		return moxio.NewMemoryIterator([]interface{}{
+			event(map[string]interface{}{"id": 1, "secret_token": "abcdefghijkl"}),
+			// Same types, so not an example
+			event(map[string]interface{}{"id": 2, "secret_token": "mnopqrstuvwx"}),
+			// Type changed
+			event(map[string]interface{}{"id": "three", "secret_token": "mnopqrstuvwx"}),
+		})
+	}
+
+	It("keeps other events out of the CloudEvents messages", func() {
+		spec := asyncapispec.Specification{}
+		ceEvent := map[string]interface{}{
+			"path":    "/events",
+			"method":  "POST",
+			"headers": map[string]interface{}{"Host": "localhost:8080", "Content-Type": "application/cloudevents+json"},
+			"body": map[string]interface{}{
+				"specversion": "1.0", "type": "order.created", "source": "/orders", "id": "A234-1234",
+				"data": map[string]interface{}{"order_id": 5},
+			},
+		}
+		plainEvent := map[string]interface{}{
+			"path":    "/events",
+			"method":  "POST",
+			"headers": map[string]interface{}{"Host": "localhost:8080", "Content-Type": "application/json"},
+			"body":    map[string]interface{}{"ping": true},
+		}
+		Expect(httpmerge.MergeHttp(ctx, internal.MergeInput{
+			Spec:          spec,
+			EventIterator: moxio.NewMemoryIterator([]interface{}{ceEvent, plainEvent, plainEvent}),
+		})).To(Succeed())
+		msg := spec.GetOrAddChannels().GetOrAddItem("/events").GetOrAddSubscribe().GetOrAddMessage()
+		Expect(msg).To(SatisfyAll(HaveKey("oneOf"), Not(HaveKey("payload"))))
+		msgs := spec.GetOrAddChannels().GetOrAddItem("/events").GetOrAddSubscribe().Messages()
+		Expect(msgs).To(HaveLen(2))
+		Expect(msgs[0].Name()).To(Equal("order.created"))
+		Expect(msgs[1].Name()).To(Equal(""))
+		Expect(msgs[1].TraitRefs()).To(BeEmpty())
+		Expect(msgs[1].GetOrAddPayload().MustObject().Properties()).To(SatisfyAll(HaveKey("ping"), Not(HaveKey("order_id"))))
+	})
+
+	It("uses the listener address as the server when there is no Host header", func() {
+		spec := asyncapispec.Specification{}
+		Expect(httpmerge.MergeHttp(ctx, internal.MergeInput{
+			Spec: spec,
+			EventIterator: moxio.NewMemoryIterator([]interface{}{
+				map[string]interface{}{
+					"path":        "/webhook",
+					"method":      "POST",
+					"headers":     map[string]interface{}{"Authorization": "Bearer abc"},
+					"body":        map[string]interface{}{"x": 1},
+					"listen_addr": "localhost:8080",
+				},
+				map[string]interface{}{
+					"path":    "http://api.example.com/webhook",
+					"method":  "POST",
+					"headers": map[string]interface{}{},
+					"body":    map[string]interface{}{"x": 2},
+				},
+			}),
+		})).To(Succeed())
+		Expect(spec.GetOrAddServers()).To(SatisfyAll(
+			HaveKeyWithValue("localhost:8080", HaveKeyWithValue("security", HaveLen(1))),
+			HaveKey("api.example.com"),
+		))
+	})
+
+	It("removes raw header values stored by older specs", func() {
+		spec := asyncapispec.Specification{}
+		msg := spec.GetOrAddChannels().GetOrAddItem("/webhook").GetOrAddSubscribe().GetOrAddMessage()
+		msg.GetOrAddBindings().GetOrAddHttp()["headers"] = map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"Cookie": map[string]interface{}{"type": "string", "x-lastValue": "session=abc123xyz"},
+			},
+		}
+		Expect(httpmerge.MergeHttp(ctx, internal.MergeInput{
+			Spec: spec,
+			EventIterator: moxio.NewMemoryIterator([]interface{}{map[string]interface{}{
+				"path":    "/webhook",
+				"method":  "POST",
+				"headers": map[string]interface{}{"Cookie": "session=def456uvw"},
+				"body":    map[string]interface{}{},
+			}}),
+		})).To(Succeed())
+		specJson, err := json.Marshal(spec)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(specJson)).ToNot(SatisfyAny(ContainSubstring("x-lastValue"), ContainSubstring("abc123xyz")))
+	})
+
+	It("records redacted examples that change the schema, up to the limit", func() {
+		spec := asyncapispec.Specification{}
+		limit := 5
+		Expect(httpmerge.MergeHttp(ctx, internal.MergeInput{
+			Spec:          spec,
+			EventIterator: events(),
+			ExampleLimit:  &limit,
+		})).To(Succeed())
+		msg := spec.GetOrAddChannels().GetOrAddItem("/webhook").GetOrAddSubscribe().GetOrAddMessage()
+		examples := msg.Examples()
+		Expect(examples).To(HaveLen(2))
+		Expect(examples[0].Headers()).To(Equal(map[string]interface{}{"X-Tenant": "acme"}))
+		Expect(examples[0].Payload()).To(HaveKeyWithValue("id", 1))
+		Expect(examples[0].Payload()).To(HaveKeyWithValue("secret_token", "aaaaaaaaaaaa"))
+		Expect(examples[1].Payload()).To(HaveKeyWithValue("id", "three"))
+
+		limit = 1
+		Expect(httpmerge.MergeHttp(ctx, internal.MergeInput{
+			Spec:          spec,
+			EventIterator: events(),
+			ExampleLimit:  &limit,
+		})).To(Succeed())
+		Expect(msg.Examples()).To(HaveLen(1))
+
+		limit = 0
+		Expect(httpmerge.MergeHttp(ctx, internal.MergeInput{
+			Spec:          spec,
+			EventIterator: events(),
+			ExampleLimit:  &limit,
+		})).To(Succeed())
+		Expect(msg).ToNot(HaveKey("examples"))
+	})
+
+	It("records the provenance of message payloads and headers", func() {
+		spec := asyncapispec.Specification{}
+		start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+		now := start
+		tick := func() time.Time {
+			now = now.Add(time.Minute)
+			return now
+		}
+		first := func(sch schema.Schema) schema.Provenance {
+			p, ok := sch.FirstSeen()
+			Expect(ok).To(BeTrue())
+			return p
+		}
+		last := func(sch schema.Schema) schema.Provenance {
+			p, ok := sch.LastSeen()
+			Expect(ok).To(BeTrue())
+			return p
+		}
+		Expect(httpmerge.MergeHttp(ctx, internal.MergeInput{
+			Spec:          spec,
+			EventIterator: events(),
+			Provenance:    &schemamerge.ProvenanceInput{Source: "events.jsonl", Now: tick},
+		})).To(Succeed())
+		msg := spec.GetOrAddChannels().GetOrAddItem("/webhook").GetOrAddSubscribe().GetOrAddMessage()
+		for _, sch := range []schema.Schema{msg.GetOrAddPayload(), msg.GetOrAddHeaders()} {
+			Expect(first(sch)).To(Equal(schema.Provenance{At: start.Add(time.Minute), Source: "events.jsonl:1"}))
+			Expect(last(sch)).To(Equal(schema.Provenance{At: start.Add(3 * time.Minute), Source: "events.jsonl:3"}))
+		}
+
+		Expect(httpmerge.MergeHttp(ctx, internal.MergeInput{
+			Spec:          spec,
+			EventIterator: events(),
+			Provenance:    &schemamerge.ProvenanceInput{SourceField: "id", Now: tick},
+		})).To(Succeed())
+		Expect(last(msg.GetOrAddPayload().MustObject().Properties()["id"])).To(HaveField("Source", "three"))
+		Expect(last(msg.GetOrAddHeaders().MustObject().Properties()["X-Tenant"])).To(HaveField("Source", "three"))
+		Expect(first(msg.GetOrAddHeaders().MustObject().Properties()["X-Tenant"])).To(HaveField("Source", "events.jsonl:1"))
+	})
+})
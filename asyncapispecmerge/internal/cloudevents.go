@@ -0,0 +1,36 @@
+package internal
+
+import (
+	"context"
+	"github.com/lithictech/moxpopuli/asyncapispec"
+	"github.com/lithictech/moxpopuli/cloudevents"
+	"github.com/lithictech/moxpopuli/schemamerge"
+	"github.com/pkg/errors"
+)
+
+// MergeCloudEvent merges the event's envelope attributes into the message trait
+// shared by all CloudEvents of the same mode (see cloudevents.EnvelopeField), and returns the operation's message
+// for the event type (messages are split by type under 'message.oneOf').
+// The caller is responsible for merging the event data into the message payload.
+func MergeCloudEvent(ctx context.Context, spec asyncapispec.Specification, op asyncapispec.Operation, ce cloudevents.Event) (asyncapispec.Message, error) {
+	traitName := cloudevents.TraitName(ce.Mode)
+	trait := spec.GetOrAddComponents().GetOrAddMessageTraits().GetOrAdd(traitName)
+	trait[cloudevents.TraitModeField] = ce.Mode
+	if ce.Mode == cloudevents.MODE_BINARY {
+		trait["description"] = "CloudEvents envelope attributes, sent as 'ce-' headers."
+	} else {
+		trait["description"] = "CloudEvents envelope attributes, sent in the body alongside 'data'."
+	}
+	envelopeField := cloudevents.EnvelopeField(ce.Mode)
+	envelopeMergeResult, err := schemamerge.MergeOne(ctx, schemamerge.MergeOneInput{Schema: trait.GetOrAddSchema(envelopeField), Payload: ce.Attributes})
+	if err != nil {
+		return nil, errors.Wrap(err, "merging cloudevents envelope")
+	}
+	trait[envelopeField] = envelopeMergeResult.Schema
+	msg := op.GetOrAddMessageNamed(ce.Type)
+	msg.AddTraitRef(asyncapispec.MessageTraitRef(traitName))
+	if ce.DataBase64 {
+		msg[cloudevents.MessageDataBase64Field] = true
+	}
+	return msg, nil
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"github.com/lithictech/moxpopuli/asyncapispec"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// ParseDiscriminator parses a header name (like 'X-GitHub-Event')
+// or payload field path (like '$.type' or '$.data.object_type') into a Discriminator.
+// An empty string is the zero Discriminator, which means to auto-detect well-known headers.
+func ParseDiscriminator(s string) (asyncapispec.Discriminator, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return asyncapispec.Discriminator{}, nil
+	}
+	if !strings.HasPrefix(s, "$") {
+		return asyncapispec.Discriminator{Header: s}, nil
+	}
+	if !strings.HasPrefix(s, "$.") || len(s) == 2 {
+		return asyncapispec.Discriminator{}, errors.Errorf("discriminator path '%s' must look like '$.field.subfield'", s)
+	}
+	path := strings.Split(s[2:], ".")
+	for _, p := range path {
+		if p == "" {
+			return asyncapispec.Discriminator{}, errors.Errorf("discriminator path '%s' has an empty field", s)
+		}
+	}
+	return asyncapispec.Discriminator{Path: path}, nil
+}
+
+// DiscriminatedMessage returns the operation's message for the event.
+// If there is a discriminator (passed in, already recorded on the operation, or detected from well-known headers),
+// messages are split by event type under 'message.oneOf'.
+// Headers can be nil for protocols without them.
+func DiscriminatedMessage(op asyncapispec.Operation, d asyncapispec.Discriminator, headers map[string]string, payload interface{}) asyncapispec.Message {
+	if d.IsZero() {
+		d, _ = op.Discriminator()
+	}
+	if d.IsZero() {
+		d = detectDiscriminator(headers)
+	}
+	if d.IsZero() {
+		if _, ok := op.GetOrAddMessage()["oneOf"]; ok {
+			// Messages are already split (like CloudEvents by type), so this one goes next to them.
+			return op.GetOrAddMessageNamed("")
+		}
+		return op.GetOrAddMessage()
+	}
+	op.SetDiscriminator(d)
+	value, _ := d.Value(headers, payload)
+	return op.GetOrAddMessageNamed(value)
+}
+
+func detectDiscriminator(headers map[string]string) asyncapispec.Discriminator {
+	for k := range headers {
+		if _, ok := eventTypeHeaders[CanonicalHeader(k)]; ok {
+			return asyncapispec.Discriminator{Header: k}
+		}
+	}
+	return asyncapispec.Discriminator{}
+}
+
+// Webhook providers that send every event type to the same URL and identify it with a header.
+var eventTypeHeaders = LinesToHeaderNames(`X-GitHub-Event
+X-Gitlab-Event
+X-Gitea-Event
+X-Event-Key
+X-Shopify-Topic
+Stripe-Event-Type`)
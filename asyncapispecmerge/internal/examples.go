@@ -0,0 +1,38 @@
+package internal
+
+import (
+	"context"
+	"github.com/lithictech/moxpopuli/asyncapispec"
+	"github.com/lithictech/moxpopuli/fp"
+	"github.com/lithictech/moxpopuli/moxrand"
+	"github.com/lithictech/moxpopuli/schema"
+)
+
+// RecordExample records the headers and payload as one of the message's 'examples',
+// if they changed the message schema (see schemamerge.MergeManyOutput.TypeChanged).
+// Examples are redacted using the same rules as schemas.
+//
+// The limit works like schemamerge.MergeManyInput.ExampleLimit:
+// if nil, do not modify examples. If <= 0, delete examples.
+// If > 0, keep a random sample of at most limit examples (see moxrand.WithSeed).
+// Headers can be nil for protocols without them.
+func RecordExample(ctx context.Context, message asyncapispec.Message, limit *int, changed bool, headers map[string]interface{}, payload interface{}) {
+	if limit == nil {
+		return
+	}
+	if *limit <= 0 {
+		delete(message, "examples")
+		return
+	}
+	examples, _ := message["examples"].([]interface{})
+	if changed {
+		example := map[string]interface{}{"payload": schema.Redact("", payload)}
+		if len(headers) > 0 {
+			example["headers"] = schema.Redact("", headers)
+		}
+		examples = append(examples, example)
+	}
+	if len(examples) > 0 {
+		message["examples"] = fp.SampleOut(moxrand.FromContext(ctx), examples, *limit)
+	}
+}
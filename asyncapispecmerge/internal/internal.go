@@ -1,8 +1,12 @@
 package internal
 
 import (
+	"context"
+	"encoding/json"
 	"github.com/lithictech/moxpopuli/asyncapispec"
 	"github.com/lithictech/moxpopuli/moxio"
+	"github.com/lithictech/moxpopuli/schema"
+	"github.com/lithictech/moxpopuli/schemamerge"
 	"strings"
 )
 
@@ -10,6 +14,64 @@ type MergeInput struct {
 	Spec          asyncapispec.Specification
 	EventIterator moxio.Iterator
 	ExampleLimit  *int
+	// Discriminator splits messages by event type. See ParseDiscriminator.
+	Discriminator asyncapispec.Discriminator
+	// How many goroutines merge events. If <= 1, events are merged sequentially.
+	// See specmerge.Sharded.
+	Workers int
+	// If set, record when and where message payload and header schemas were first and last seen.
+	// TimeField and SourceField are paths in the message payload (the body, or the data of a CloudEvent),
+	// and sources use the event's position in EventIterator.
+	Provenance *schemamerge.ProvenanceInput
+}
+
+// Seen returns the provenance of a message payload from the event at the position, or nil if Provenance is not set.
+func (in MergeInput) Seen(payload interface{}, position int) *schema.Provenance {
+	if in.Provenance == nil {
+		return nil
+	}
+	p := in.Provenance.Of(payload, position)
+	return &p
+}
+
+// positioned is an event and its position (starting at 1) in MergeInput.EventIterator.
+type positioned struct {
+	event    interface{}
+	position int
+}
+
+type positionIterator struct {
+	moxio.Iterator
+	position int
+}
+
+func (it *positionIterator) Read(ctx context.Context) (interface{}, error) {
+	event, err := it.Iterator.Read(ctx)
+	if err != nil {
+		return event, err
+	}
+	if p, ok := event.(positioned); ok {
+		return p, nil
+	}
+	it.position++
+	return positioned{event: event, position: it.position}, nil
+}
+
+// WithPositions tags each event with its position in the iterator,
+// so the position is known after events are sharded across workers (see specmerge.Sharded).
+// Events that already have a position keep it.
+func WithPositions(it moxio.Iterator) moxio.Iterator {
+	return &positionIterator{Iterator: it}
+}
+
+// ReadEvent reads the next event from an iterator returned by WithPositions, and its position.
+func ReadEvent(ctx context.Context, it moxio.Iterator) (interface{}, int, error) {
+	item, err := it.Read(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	p := item.(positioned)
+	return p.event, p.position, nil
 }
 
 func LinesToHeaderNames(raw string) map[string]struct{} {
@@ -24,3 +86,17 @@ func LinesToHeaderNames(raw string) map[string]struct{} {
 func CanonicalHeader(s string) string {
 	return strings.ToLower(strings.TrimSpace(s))
 }
+
+// DecodeJsonString parses string payloads as JSON if possible,
+// since message-oriented protocols carry bytes that are usually captured as strings.
+func DecodeJsonString(p interface{}) interface{} {
+	s, ok := p.(string)
+	if !ok {
+		return p
+	}
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(s), &decoded); err != nil {
+		return s
+	}
+	return decoded
+}
@@ -0,0 +1,20 @@
+package internal_test
+
+import (
+	"github.com/lithictech/moxpopuli/asyncapispecmerge/internal"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestInternal(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "internal Suite")
+}
+
+var _ = Describe("internal", func() {
+	It("errors for invalid payload paths", func() {
+		_, err := internal.ParseDiscriminator("$.a..b")
+		Expect(err).To(MatchError(ContainSubstring("empty field")))
+	})
+})
@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"github.com/lithictech/moxpopuli/asyncapispec"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// MergeSecurity describes any credentials in the headers and query with security schemes
+// (under components.securitySchemes), and adds them to the server's security requirements.
+// The server can be nil if it is not known.
+//
+// It returns the headers and query with the credentials removed,
+// so that credential values are never stored in the spec.
+func MergeSecurity(
+	spec asyncapispec.Specification,
+	server asyncapispec.Server,
+	headers map[string]string,
+	query url.Values,
+) (map[string]string, url.Values) {
+	add := func(name string, scheme map[string]interface{}) {
+		existing := spec.GetOrAddComponents().GetOrAddSecuritySchemes().GetOrAdd(name)
+		for k, v := range scheme {
+			existing[k] = v
+		}
+		if server != nil {
+			server.AddSecurityRequirement(name)
+		}
+	}
+	remainingHeaders := make(map[string]string, len(headers))
+	for k, v := range headers {
+		if name, scheme, ok := headerSecurityScheme(k, v); ok {
+			add(name, scheme)
+		} else {
+			remainingHeaders[k] = v
+		}
+	}
+	remainingQuery := make(url.Values, len(query))
+	for k, v := range query {
+		if _, ok := apiKeyQueryParams[strings.ToLower(k)]; ok {
+			add(k+"Query", map[string]interface{}{
+				"type": "httpApiKey",
+				"name": k,
+				"in":   "query",
+			})
+		} else {
+			remainingQuery[k] = v
+		}
+	}
+	return remainingHeaders, remainingQuery
+}
+
+func headerSecurityScheme(header, value string) (string, map[string]interface{}, bool) {
+	canonical := CanonicalHeader(header)
+	if canonical == "authorization" {
+		authScheme, credentials, _ := strings.Cut(strings.TrimSpace(value), " ")
+		switch strings.ToLower(authScheme) {
+		case "bearer":
+			s := map[string]interface{}{"type": "http", "scheme": "bearer"}
+			if jwtRegex.MatchString(strings.TrimSpace(credentials)) {
+				s["bearerFormat"] = "JWT"
+			}
+			return "bearerAuth", s, true
+		case "basic":
+			return "basicAuth", map[string]interface{}{"type": "http", "scheme": "basic"}, true
+		case "digest":
+			return "digestAuth", map[string]interface{}{"type": "http", "scheme": "digest"}, true
+		}
+		return "authorizationHeader", map[string]interface{}{"type": "httpApiKey", "name": header, "in": "header"}, true
+	}
+	if algorithm, ok := signatureAlgorithm(canonical); ok {
+		return header, map[string]interface{}{
+			"type":        "httpApiKey",
+			"name":        header,
+			"in":          "header",
+			"description": "Signature of the request, computed with a shared secret.",
+			"x-signature": algorithm,
+		}, true
+	}
+	for _, s := range apiKeyHeaderSubstrings {
+		if strings.Contains(canonical, s) {
+			return header, map[string]interface{}{"type": "httpApiKey", "name": header, "in": "header"}, true
+		}
+	}
+	return "", nil, false
+}
+
+// signatureAlgorithm returns the algorithm, like 'hmac-sha256', for webhook signature headers.
+func signatureAlgorithm(canonicalHeader string) (string, bool) {
+	if algo, ok := knownSignatureHeaders[canonicalHeader]; ok {
+		return algo, true
+	}
+	if !strings.Contains(canonicalHeader, "signature") && !strings.Contains(canonicalHeader, "hmac") {
+		return "", false
+	}
+	for _, hash := range []string{"sha512", "sha256", "sha1"} {
+		if strings.Contains(canonicalHeader, hash) {
+			return "hmac-" + hash, true
+		}
+	}
+	return "hmac", true
+}
+
+var knownSignatureHeaders = map[string]string{
+	"stripe-signature":              "hmac-sha256",
+	"x-hub-signature":               "hmac-sha1",
+	"x-hub-signature-256":           "hmac-sha256",
+	"x-shopify-hmac-sha256":         "hmac-sha256",
+	"x-twilio-signature":            "hmac-sha1",
+	"x-slack-signature":             "hmac-sha256",
+	"svix-signature":                "hmac-sha256",
+	"webhook-signature":             "hmac-sha256",
+	"x-square-hmacsha256-signature": "hmac-sha256",
+}
+
+var apiKeyHeaderSubstrings = []string{"api-key", "apikey", "api_key", "auth-token", "access-token"}
+
+var apiKeyQueryParams = map[string]struct{}{
+	"api_key":      {},
+	"apikey":       {},
+	"api-key":      {},
+	"access_token": {},
+	"auth_token":   {},
+	"token":        {},
+}
+
+var jwtRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*$`)
@@ -0,0 +1,256 @@
+package mqttmerge
+
+import (
+	"context"
+	"fmt"
+	"github.com/lithictech/moxpopuli/asyncapispec"
+	"github.com/lithictech/moxpopuli/asyncapispecmerge/internal"
+	"github.com/lithictech/moxpopuli/cloudevents"
+	moxinternal "github.com/lithictech/moxpopuli/internal"
+	"github.com/lithictech/moxpopuli/jsonformat"
+	"github.com/lithictech/moxpopuli/jsontype"
+	"github.com/lithictech/moxpopuli/schemamerge"
+	"github.com/pkg/errors"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+func MergeMqtt(ctx context.Context, in internal.MergeInput) error {
+	channels := in.Spec.GetOrAddChannels()
+	servers := in.Spec.GetOrAddServers()
+	events := internal.WithPositions(in.EventIterator)
+	for events.Next() {
+		event, position, err := internal.ReadEvent(ctx, events)
+		if err != nil {
+			return errors.Wrap(err, "reading events")
+		}
+		var mevent MqttEvent
+		if mev, ok := event.(MqttEvent); ok {
+			mevent = mev
+		} else if mapev, ok := event.(map[string]interface{}); ok {
+			mevent, err = NewMqttEvent(mapev)
+			if err != nil {
+				return err
+			}
+		} else {
+			return errors.New("event must be an MqttEvent or map[string]interface{}")
+		}
+		levels := strings.Split(mevent.Topic, "/")
+		chanName, paramValues := templateTopic(channels, levels)
+		chanItem := channels.GetOrAddItem(chanName)
+		if len(paramValues) > 0 {
+			params := chanItem.GetOrAddParameters()
+			for name, value := range paramValues {
+				param := params.GetOrAdd(name)
+				paramMergeResult, err := schemamerge.MergeOne(ctx, schemamerge.MergeOneInput{Schema: param.GetOrAddSchema(), Payload: value})
+				if err != nil {
+					return errors.Wrap(err, "merging channel parameter")
+				}
+				param["schema"] = paramMergeResult.Schema
+			}
+		}
+		subscribe := chanItem.GetOrAddSubscribe()
+		mqttBinding := subscribe.GetOrAddBindings().GetOrAddMqtt()
+		mqttBinding["qos"] = moxinternal.MaxInt(mqttBinding.Qos(), mevent.Qos)
+		mqttBinding["retain"] = mqttBinding.Retain() || mevent.Retain
+		mqttBinding["bindingVersion"] = bindingVersion
+		var message asyncapispec.Message
+		payload := internal.DecodeJsonString(mevent.Payload)
+		if ce, ok := cloudevents.FromStructured(payload); ok {
+			message, err = internal.MergeCloudEvent(ctx, in.Spec, subscribe, ce)
+			if err != nil {
+				return err
+			}
+			message["contentType"] = cloudevents.StructuredContentType
+			payload = ce.Data
+		} else {
+			message = internal.DiscriminatedMessage(subscribe, in.Discriminator, nil, payload)
+		}
+		if _, ok := message["contentType"]; !ok {
+			message["contentType"] = "application/json"
+		}
+		message.GetOrAddBindings().GetOrAdd("mqtt")["bindingVersion"] = bindingVersion
+		payloadMergeResult, err := schemamerge.MergeOne(ctx, schemamerge.MergeOneInput{
+			Schema:  message.GetOrAddPayload(),
+			Payload: payload,
+			Seen:    in.Seen(payload, position),
+		})
+		if err != nil {
+			return errors.Wrap(err, "merging payload")
+		}
+		message["payload"] = payloadMergeResult.Schema
+		internal.RecordExample(ctx, message, in.ExampleLimit, payloadMergeResult.TypeChanged, nil, payload)
+		if mevent.Server != "" {
+			serverUrl, err := url.Parse(mevent.Server)
+			if err != nil {
+				return errors.Wrap(err, "could not parse event server")
+			}
+			srv := servers.GetOrAddServer(serverUrl.Host)
+			srv["url"] = serverUrl.Host
+			srv["protocol"] = serverUrl.Scheme
+			srv["protocolVersion"] = protocolVersion
+			srvBinding := srv.GetOrAddBindings().GetOrAddMqtt()
+			srvBinding["bindingVersion"] = bindingVersion
+			if mevent.ClientId != "" {
+				srvBinding["clientId"] = mevent.ClientId
+			}
+		}
+	}
+	return nil
+}
+
+const bindingVersion = "0.1.0"
+const protocolVersion = "3.1.1"
+
+// templateTopic returns the channel name the topic levels belong to,
+// and the value of each channel parameter in the topic.
+// If existing channels match the topic, use the most specific one
+// (fewest parameters); otherwise, build a new channel name, replacing levels that look like identifiers
+// (devices/abc123/telemetry) with parameters (devices/{deviceId}/telemetry).
+func templateTopic(channels asyncapispec.Channels, levels []string) (string, map[string]string) {
+	bestName := ""
+	var bestValues map[string]string
+	for chanName := range channels {
+		values, ok := matchTemplate(strings.Split(chanName, "/"), levels)
+		if !ok {
+			continue
+		}
+		if bestValues == nil || len(values) < len(bestValues) || (len(values) == len(bestValues) && chanName < bestName) {
+			bestName, bestValues = chanName, values
+		}
+	}
+	if bestValues != nil {
+		return bestName, bestValues
+	}
+	templated := make([]string, len(levels))
+	values := make(map[string]string, 2)
+	for i, level := range levels {
+		if !looksLikeParameter(level) {
+			templated[i] = level
+			continue
+		}
+		name := parameterName(templated, i)
+		for _, taken := values[name]; taken; _, taken = values[name] {
+			name = fmt.Sprintf("%s%d", name, i)
+		}
+		values[name] = level
+		templated[i] = "{" + name + "}"
+	}
+	return strings.Join(templated, "/"), values
+}
+
+func matchTemplate(template, levels []string) (map[string]string, bool) {
+	if len(template) != len(levels) {
+		return nil, false
+	}
+	values := make(map[string]string, 2)
+	for i, t := range template {
+		if m := parameterRegex.FindStringSubmatch(t); m != nil {
+			values[m[1]] = levels[i]
+		} else if t != levels[i] {
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+var parameterRegex = regexp.MustCompile("^{(.+)}$")
+
+func looksLikeParameter(level string) bool {
+	f := jsonformat.Sniff(jsontype.T_STRING, level)
+	if f == jsonformat.F_UUID4 || f == jsonformat.F_NUMERICAL {
+		return true
+	}
+	// Short mixes like 'v1' are usually versions, not identifiers.
+	return len(level) >= 4 && hasDigitRegex.MatchString(level) && hasLetterRegex.MatchString(level)
+}
+
+var hasDigitRegex = regexp.MustCompile("[0-9]")
+var hasLetterRegex = regexp.MustCompile("[a-zA-Z]")
+
+// parameterName uses the previous level to name the parameter,
+// so devices/{x} becomes devices/{deviceId}.
+func parameterName(templated []string, idx int) string {
+	if idx == 0 || parameterRegex.MatchString(templated[idx-1]) {
+		return fmt.Sprintf("param%d", idx)
+	}
+	prev := nonAlnumRegex.ReplaceAllString(templated[idx-1], "")
+	if prev == "" {
+		return fmt.Sprintf("param%d", idx)
+	}
+	if strings.HasSuffix(prev, "ies") {
+		prev = prev[:len(prev)-3] + "y"
+	} else if strings.HasSuffix(prev, "s") && !strings.HasSuffix(prev, "ss") {
+		prev = prev[:len(prev)-1]
+	}
+	return prev + "Id"
+}
+
+var nonAlnumRegex = regexp.MustCompile("[^a-zA-Z0-9]+")
+
+type MqttEvent struct {
+	Topic    string      `json:"topic" description:"Topic the message was published to, like 'devices/abc123/telemetry'."`
+	Payload  interface{} `json:"payload" description:"Message payload. Strings are parsed as JSON where possible."`
+	Qos      int         `json:"qos" description:"Quality of service level the message was published with (0, 1, or 2)."`
+	Retain   bool        `json:"retain" description:"True if the message was published with the retain flag."`
+	ClientId string      `json:"client_id" description:"Client identifier of the publisher, if known."`
+	Server   string      `json:"server" description:"URL of the broker, like 'mqtt://localhost:1883'. Used to build the 'servers' section."`
+}
+
+func NewMqttEvent(e map[string]interface{}) (MqttEvent, error) {
+	m := MqttEvent{}
+	if v, ok := e["topic"]; ok {
+		if vt, ok := v.(string); ok {
+			m.Topic = vt
+		} else {
+			return m, errors.New("event topic must be a string")
+		}
+	} else {
+		return m, errors.New("event requires 'topic' key")
+	}
+	if v, ok := e["payload"]; ok {
+		m.Payload = v
+	} else {
+		return m, errors.New("event requires 'payload' key")
+	}
+	if v, ok := e["qos"]; ok && v != nil {
+		switch vt := moxinternal.CoerceToLikelyGoType(v).(type) {
+		case int:
+			m.Qos = vt
+		case int16:
+			m.Qos = int(vt)
+		case int32:
+			m.Qos = int(vt)
+		case int64:
+			m.Qos = int(vt)
+		default:
+			return m, errors.New("event qos must be an integer")
+		}
+		if m.Qos < 0 || m.Qos > 2 {
+			return m, errors.New("event qos must be 0, 1, or 2")
+		}
+	}
+	if v, ok := e["retain"]; ok && v != nil {
+		if vt, ok := v.(bool); ok {
+			m.Retain = vt
+		} else {
+			return m, errors.New("event retain must be a boolean")
+		}
+	}
+	if v, ok := e["client_id"]; ok && v != nil {
+		if vt, ok := v.(string); ok {
+			m.ClientId = vt
+		} else {
+			return m, errors.New("event client_id must be a string")
+		}
+	}
+	if v, ok := e["server"]; ok && v != nil {
+		if vt, ok := v.(string); ok {
+			m.Server = vt
+		} else {
+			return m, errors.New("event server must be a string")
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,98 @@
+package mqttmerge_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/lithictech/moxpopuli/asyncapispec"
+	"github.com/lithictech/moxpopuli/asyncapispecmerge/internal"
+	"github.com/lithictech/moxpopuli/asyncapispecmerge/mqttmerge"
+	"github.com/lithictech/moxpopuli/moxio"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestMqttmerge(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "mqttmerge Suite")
+}
+
+var _ = Describe("mqttmerge", func() {
+	ctx := context.Background()
+
+	mergeEvents := func(events ...interface{}) asyncapispec.Specification {
+		spec := asyncapispec.Specification{}
+		Expect(mqttmerge.MergeMqtt(ctx, internal.MergeInput{
+			Spec:          spec,
+			EventIterator: moxio.NewMemoryIterator(events),
+		})).To(Succeed())
+		return spec
+	}
+
+	It("templates topic levels into channel parameters and records bindings", func() {
+		spec := mergeEvents(
+			map[string]interface{}{
+				"topic":     "devices/abc123/telemetry",
+				"payload":   `{"temp": 20.5}`,
+				"qos":       1.0,
+				"client_id": "sensor-gateway",
+				"server":    "mqtt://localhost:1883",
+			},
+			map[string]interface{}{
+				"topic":   "devices/xyz789/telemetry",
+				"payload": map[string]interface{}{"temp": 18.0},
+				"retain":  true,
+			},
+		)
+		b, err := json.Marshal(spec)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(b)).To(MatchJSON(`{
+			"channels": {
+				"devices/{deviceId}/telemetry": {
+					"parameters": {
+						"deviceId": {
+							"schema": {
+								"type": "string",
+								"x-lengthStats": {"count": 2, "integer": true, "sum": 12, "m2": 0, "mean": 6, "variance": 0, "positive": {"91": 2}},
+								"x-samples": 2,
+								"x-seenMaxLength": 6,
+								"x-seenMinLength": 6,
+								"x-seenStrings": ["abc123", "xyz789"],
+								"x-valueCounts": {"abc123": 1, "xyz789": 1}
+							}
+						}
+					},
+					"subscribe": {
+						"bindings": {"mqtt": {"bindingVersion": "0.1.0", "qos": 1, "retain": true}},
+						"message": {
+							"bindings": {"mqtt": {"bindingVersion": "0.1.0"}},
+							"contentType": "application/json",
+							"payload": {
+								"properties": {
+									"temp": {
+										"format": "float",
+										"type": "number",
+										"x-samples": 2,
+										"x-seenMaximum": 20.5,
+										"x-seenMinimum": 18,
+										"x-stats": {"count": 2, "sum": 38.5, "m2": 3.125, "mean": 19.25, "variance": 1.562, "positive": {"146": 1, "153": 1}}
+									}
+								},
+								"type": "object",
+								"x-samples": 2
+							}
+						}
+					}
+				}
+			},
+			"servers": {
+				"localhost:1883": {
+					"bindings": {"mqtt": {"bindingVersion": "0.1.0", "clientId": "sensor-gateway"}},
+					"protocol": "mqtt",
+					"protocolVersion": "3.1.1",
+					"url": "localhost:1883"
+				}
+			}
+		}`))
+	})
+})
@@ -0,0 +1,93 @@
+package specmerge
+
+import (
+	"context"
+	"github.com/lithictech/moxpopuli/asyncapispec"
+	"github.com/lithictech/moxpopuli/asyncapispecmerge/internal"
+	moxinternal "github.com/lithictech/moxpopuli/internal"
+	"github.com/lithictech/moxpopuli/moxio"
+	"github.com/pkg/errors"
+)
+
+// Merge is a protocol merge, like httpmerge.MergeHttp.
+type Merge func(context.Context, internal.MergeInput) error
+
+// Sharded returns a Merge that, if MergeInput.Workers > 1, merges events concurrently.
+// Each worker merges its share of the events into its own partial spec, and the partial specs
+// are merged into MergeInput.Spec with MergeSpecs.
+//
+// Partial specs start from MergeInput.Spec without its schemas and examples,
+// so workers split messages with the same discriminators and names.
+// Examples are recorded by each worker, and then sampled down to MergeInput.ExampleLimit.
+// Events are tagged with their position before they are sharded, so provenance sources are the same
+// as merging sequentially.
+func Sharded(merge Merge) Merge {
+	return func(ctx context.Context, in internal.MergeInput) error {
+		if in.Workers <= 1 {
+			return merge(ctx, in)
+		}
+		partials := make([]asyncapispec.Specification, in.Workers)
+		for i := range partials {
+			skeleton, err := withoutSchemas(in.Spec)
+			if err != nil {
+				return err
+			}
+			partials[i] = skeleton
+		}
+		err := moxio.Shard(ctx, internal.WithPositions(in.EventIterator), in.Workers, func(ctx context.Context, i int, it moxio.Iterator) error {
+			shardIn := in
+			shardIn.Spec = partials[i]
+			shardIn.EventIterator = it
+			shardIn.Workers = 0
+			return merge(ctx, shardIn)
+		})
+		if err != nil {
+			return err
+		}
+		merged, err := MergeSpecs(ctx, MergeSpecsInput{
+			Specs:        append([]asyncapispec.Specification{in.Spec}, partials...),
+			ExampleLimit: in.ExampleLimit,
+		})
+		if err != nil {
+			return errors.Wrap(err, "merging partial specs")
+		}
+		// Callers hold on to the spec, so replace its contents.
+		for k := range in.Spec {
+			delete(in.Spec, k)
+		}
+		for k, v := range merged {
+			in.Spec[k] = v
+		}
+		return nil
+	}
+}
+
+// withoutSchemas returns a copy of the spec without any learned schemas or examples.
+func withoutSchemas(spec asyncapispec.Specification) (asyncapispec.Specification, error) {
+	plain, err := moxinternal.ToPlainMap(spec)
+	if err != nil {
+		return nil, errors.Wrap(err, "copying spec")
+	}
+	removeSchemas(plain)
+	return plain, nil
+}
+
+func removeSchemas(m map[string]interface{}) {
+	delete(m, "examples")
+	for k, v := range m {
+		if _, ok := schemaKeys[k]; ok {
+			delete(m, k)
+			continue
+		}
+		switch vt := v.(type) {
+		case map[string]interface{}:
+			removeSchemas(vt)
+		case []interface{}:
+			for _, item := range vt {
+				if itemm, ok := item.(map[string]interface{}); ok {
+					removeSchemas(itemm)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// Package specmerge merges AsyncAPI specs generated by Mox Populi from different sets of events,
+// like from different environments or databases.
+package specmerge
+
+import (
+	"context"
+	"github.com/lithictech/moxpopuli/asyncapispec"
+	"github.com/lithictech/moxpopuli/fp"
+	moxinternal "github.com/lithictech/moxpopuli/internal"
+	"github.com/lithictech/moxpopuli/moxrand"
+	"github.com/lithictech/moxpopuli/schema"
+	"github.com/lithictech/moxpopuli/schemamerge"
+	"github.com/pkg/errors"
+	"reflect"
+)
+
+type MergeSpecsInput struct {
+	// Specs to merge, in the AsyncAPI 2.x structure Mox Populi works with.
+	// For fields that cannot be merged (like 'info.title'), values from later specs win.
+	Specs []asyncapispec.Specification
+	// How many message examples to keep.
+	// If nil, keep the examples from all specs. If <= 0, delete examples.
+	// If > 0, keep a random sample of at most that many examples.
+	ExampleLimit *int
+}
+
+// MergeSpecs returns a new spec with the channels, servers, messages, and components of all specs.
+// The input specs are not modified.
+//
+// Payload, header, query, and parameter schemas are merged with schemamerge.Merge,
+// so samples, seen ranges, and enums combine as if one specgen run had seen all events.
+// Messages in an operation's 'message.oneOf' are matched by name.
+func MergeSpecs(ctx context.Context, in MergeSpecsInput) (asyncapispec.Specification, error) {
+	result := make(map[string]interface{}, 4)
+	for i, spec := range in.Specs {
+		plain, err := moxinternal.ToPlainMap(spec)
+		if err != nil {
+			return nil, errors.Wrapf(err, "converting spec %d to plain map", i)
+		}
+		// Merged schemas are typed, so convert the result back to plain JSON values
+		// before merging in the next spec.
+		if result, err = moxinternal.ToPlainMap(merger{ctx: ctx}.mergeMaps(result, plain)); err != nil {
+			return nil, errors.Wrapf(err, "converting merged spec %d to plain map", i)
+		}
+	}
+	channels, _ := result["channels"].(map[string]interface{})
+	for _, chv := range channels {
+		for _, opKey := range []string{"subscribe", "publish"} {
+			if op, ok := chv.(map[string]interface{})[opKey].(map[string]interface{}); ok {
+				for _, msg := range asyncapispec.Operation(op).Messages() {
+					limitExamples(ctx, msg, in.ExampleLimit)
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+func limitExamples(ctx context.Context, msg asyncapispec.Message, limit *int) {
+	if limit == nil {
+		return
+	}
+	examples, ok := msg["examples"].([]interface{})
+	if !ok {
+		return
+	}
+	if *limit <= 0 {
+		delete(msg, "examples")
+		return
+	}
+	msg["examples"] = fp.SampleOut(moxrand.FromContext(ctx), examples, *limit)
+}
+
+type merger struct {
+	ctx context.Context
+}
+
+// schemaKeys are the fields that hold learned schemas in specs Mox Populi generates,
+// like message 'payload' and 'headers', binding 'query' and 'headers', and parameter 'schema'.
+var schemaKeys = map[string]struct{}{
+	"payload": {},
+	"headers": {},
+	"query":   {},
+	"schema":  {},
+}
+
+func (m merger) mergeMaps(m1, m2 map[string]interface{}) map[string]interface{} {
+	r := make(map[string]interface{}, len(m1)+len(m2))
+	for k, v := range m1 {
+		r[k] = v
+	}
+	for k, v2 := range m2 {
+		v1, ok := r[k]
+		if !ok {
+			r[k] = v2
+			continue
+		}
+		r[k] = m.mergeField(k, v1, v2)
+	}
+	return r
+}
+
+func (m merger) mergeField(key string, v1, v2 interface{}) interface{} {
+	switch key {
+	case "message":
+		return m.mergeMessages(v1, v2)
+	case "examples", "traits":
+		return unionSlices(v1, v2)
+	case "security":
+		return m.mergeSecurity(v1, v2)
+	}
+	m1, ok1 := v1.(map[string]interface{})
+	m2, ok2 := v2.(map[string]interface{})
+	if !ok1 || !ok2 {
+		return v2
+	}
+	if _, ok := schemaKeys[key]; ok {
+		return m.mergeSchemas(m1, m2)
+	}
+	return m.mergeMaps(m1, m2)
+}
+
+func (m merger) mergeSchemas(s1, s2 map[string]interface{}) interface{} {
+	return schemamerge.MergeSchemas(m.ctx, schemamerge.MergeSchemasInput{
+		Schemas: []schema.Schema{schema.FromMap(s1), schema.FromMap(s2)},
+	})
+}
+
+// mergeMessages merges an operation's 'message' fields, matching messages by name.
+// The result is a single message if both operations have the same single message,
+// and a 'oneOf' otherwise.
+func (m merger) mergeMessages(v1, v2 interface{}) interface{} {
+	op1 := asyncapispec.Operation{"message": v1}
+	op2 := asyncapispec.Operation{"message": v2}
+	_, oneOf1 := v1.(map[string]interface{})["oneOf"]
+	_, oneOf2 := v2.(map[string]interface{})["oneOf"]
+	messages := op1.Messages()
+	merged := make([]interface{}, len(messages))
+	for i, msg := range messages {
+		merged[i] = map[string]interface{}(msg)
+	}
+	for _, msg2 := range op2.Messages() {
+		found := false
+		for i, msg1 := range merged {
+			if asyncapispec.Message(msg1.(map[string]interface{})).Name() == msg2.Name() {
+				merged[i] = m.mergeMaps(msg1.(map[string]interface{}), msg2)
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, map[string]interface{}(msg2))
+		}
+	}
+	if len(merged) == 1 && !oneOf1 && !oneOf2 {
+		return merged[0]
+	}
+	return map[string]interface{}{"oneOf": merged}
+}
+
+func (m merger) mergeSecurity(v1, v2 interface{}) interface{} {
+	srv := asyncapispec.Server{"security": v1}
+	for _, name := range (asyncapispec.Server{"security": v2}).SecurityRequirements() {
+		srv.AddSecurityRequirement(name)
+	}
+	return srv["security"]
+}
+
+// unionSlices returns the items of s1, followed by the items of s2 that are not in s1.
+func unionSlices(v1, v2 interface{}) interface{} {
+	s1, ok1 := v1.([]interface{})
+	s2, ok2 := v2.([]interface{})
+	if !ok1 || !ok2 {
+		return v2
+	}
+	r := make([]interface{}, len(s1), len(s1)+len(s2))
+	copy(r, s1)
+	for _, item2 := range s2 {
+		found := false
+		for _, item1 := range s1 {
+			if reflect.DeepEqual(item1, item2) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			r = append(r, item2)
+		}
+	}
+	return r
+}
@@ -0,0 +1,164 @@
+package specmerge_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/lithictech/moxpopuli/asyncapispec"
+	"github.com/lithictech/moxpopuli/asyncapispecmerge/httpmerge"
+	"github.com/lithictech/moxpopuli/asyncapispecmerge/internal"
+	"github.com/lithictech/moxpopuli/asyncapispecmerge/specmerge"
+	"github.com/lithictech/moxpopuli/internal/moxtest"
+	"github.com/lithictech/moxpopuli/moxio"
+	"github.com/lithictech/moxpopuli/schema"
+	"github.com/lithictech/moxpopuli/schemamerge"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestSpecmerge(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "specmerge Suite")
+}
+
+var _ = Describe("specmerge", func() {
+	ctx := context.Background()
+	mergeHttp := specmerge.Sharded(httpmerge.MergeHttp)
+
+	event := func(path, eventType string, body map[string]interface{}) interface{} {
+		return map[string]interface{}{
+			"path":    path,
+			"method":  "POST",
+			"headers": map[string]interface{}{"Host": "api.example.com", "X-GitHub-Event": eventType},
+			"body":    body,
+		}
+	}
+	prodEvents := []interface{}{
+		event("/webhooks", "push", map[string]interface{}{"id": 1, "ref": "main"}),
+		event("/webhooks", "push", map[string]interface{}{"id": 5, "ref": "main"}),
+		event("/webhooks", "issues", map[string]interface{}{"id": 2, "action": "opened"}),
+	}
+	stagingEvents := []interface{}{
+		event("/webhooks", "push", map[string]interface{}{"id": 30, "ref": "develop"}),
+		event("/hooks/other", "ping", map[string]interface{}{"zen": "hi"}),
+	}
+	learn := func(events ...interface{}) asyncapispec.Specification {
+		spec := moxtest.LearnHttpSpec(ctx, nil, events...)
+		spec["info"] = map[string]interface{}{"title": "Hooks"}
+		return spec
+	}
+	messageNamed := func(spec asyncapispec.Specification, address, name string) map[string]interface{} {
+		op := spec["channels"].(map[string]interface{})[address].(map[string]interface{})["subscribe"].(map[string]interface{})
+		for _, msg := range asyncapispec.Operation(op).Messages() {
+			if msg.Name() == name {
+				return msg
+			}
+		}
+		Fail("no message named " + name)
+		return nil
+	}
+	plainPayload := func(spec asyncapispec.Specification, address, name string) interface{} {
+		b, err := json.Marshal(messageNamed(spec, address, name)["payload"])
+		Expect(err).ToNot(HaveOccurred())
+		var r interface{}
+		Expect(json.Unmarshal(b, &r)).To(Succeed())
+		return r
+	}
+
+	It("merges specs as if all events were learned in one run", func() {
+		prod := learn(prodEvents...)
+		staging := learn(stagingEvents...)
+		merged, err := specmerge.MergeSpecs(ctx, specmerge.MergeSpecsInput{
+			Specs: []asyncapispec.Specification{prod, staging},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		single := learn(append(append([]interface{}{}, prodEvents...), stagingEvents...)...)
+
+		Expect(merged).To(HaveKeyWithValue("info", map[string]interface{}{"title": "Hooks"}))
+		Expect(merged).To(HaveKeyWithValue("servers", HaveKey("api.example.com")))
+		Expect(merged).To(HaveKeyWithValue("channels", SatisfyAll(HaveKey("/webhooks"), HaveKey("/hooks/other"))))
+		for _, name := range []string{"push", "issues"} {
+			Expect(plainPayload(merged, "/webhooks", name)).To(Equal(plainPayload(single, "/webhooks", name)))
+		}
+		Expect(plainPayload(merged, "/hooks/other", "ping")).To(Equal(plainPayload(single, "/hooks/other", "ping")))
+		push := plainPayload(merged, "/webhooks", "push").(map[string]interface{})
+		Expect(push).To(HaveKeyWithValue("x-samples", 3.0))
+		Expect(push["properties"]).To(HaveKeyWithValue("id", SatisfyAll(
+			HaveKeyWithValue("x-seenMinimum", 1.0),
+			HaveKeyWithValue("x-seenMaximum", 30.0),
+		)))
+
+		// The inputs are not modified
+		Expect(prod["channels"]).ToNot(HaveKey("/hooks/other"))
+	})
+
+	It("can merge events on multiple workers", func() {
+		events := make([]interface{}, 0, 100)
+		for i := 0; i < 20; i++ {
+			events = append(events, prodEvents...)
+			events = append(events, stagingEvents...)
+		}
+		sharded := asyncapispec.Specification{"info": map[string]interface{}{"title": "Hooks"}}
+		limit := 3
+		Expect(mergeHttp(ctx, internal.MergeInput{
+			Spec:          sharded,
+			EventIterator: moxio.NewMemoryIterator(events),
+			ExampleLimit:  &limit,
+			Workers:       4,
+		})).To(Succeed())
+		single := learn(events...)
+		Expect(sharded).To(HaveKeyWithValue("info", map[string]interface{}{"title": "Hooks"}))
+		for _, name := range []string{"push", "issues"} {
+			Expect(plainPayload(sharded, "/webhooks", name)).To(Equal(plainPayload(single, "/webhooks", name)))
+			Expect(len(messageNamed(sharded, "/webhooks", name)["examples"].([]interface{}))).To(BeNumerically("<=", limit))
+		}
+		Expect(plainPayload(sharded, "/hooks/other", "ping")).To(Equal(plainPayload(single, "/hooks/other", "ping")))
+
+		// Merging more events into the sharded spec keeps its messages and schemas
+		Expect(mergeHttp(ctx, internal.MergeInput{
+			Spec:          sharded,
+			EventIterator: moxio.NewMemoryIterator(events),
+			Workers:       4,
+		})).To(Succeed())
+		Expect(plainPayload(sharded, "/webhooks", "push")).To(HaveKeyWithValue("x-samples", 120.0))
+		op := sharded["channels"].(map[string]interface{})["/webhooks"].(map[string]interface{})["subscribe"].(map[string]interface{})
+		Expect(asyncapispec.Operation(op).Messages()).To(HaveLen(2))
+	})
+
+	It("records the provenance of events by their position when merging on multiple workers", func() {
+		events := make([]interface{}, 0, 40)
+		for i := 1; i <= 40; i++ {
+			events = append(events, event("/webhooks", "push", map[string]interface{}{"id": i, "at": 1700000000 + i}))
+		}
+		merge := func(workers int) asyncapispec.Specification {
+			spec := asyncapispec.Specification{}
+			Expect(mergeHttp(ctx, internal.MergeInput{
+				Spec:          spec,
+				EventIterator: moxio.NewMemoryIterator(events),
+				Workers:       workers,
+				Provenance:    &schemamerge.ProvenanceInput{TimeField: "at", Source: "events"},
+			})).To(Succeed())
+			return spec
+		}
+		sharded := merge(4)
+		payload := plainPayload(sharded, "/webhooks", "push")
+		Expect(payload).To(And(
+			HaveKeyWithValue(string(schema.PX_FIRST_SOURCE), "events:1"),
+			HaveKeyWithValue(string(schema.PX_LAST_SOURCE), "events:40"),
+		))
+		Expect(payload).To(Equal(plainPayload(merge(1), "/webhooks", "push")))
+	})
+
+	It("limits examples if given", func() {
+		limit := 5
+		specs := []asyncapispec.Specification{moxtest.LearnHttpSpec(ctx, &limit, prodEvents...), moxtest.LearnHttpSpec(ctx, &limit, stagingEvents...)}
+		merged, err := specmerge.MergeSpecs(ctx, specmerge.MergeSpecsInput{Specs: specs})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(messageNamed(merged, "/webhooks", "push")["examples"]).To(HaveLen(2))
+
+		limit = 1
+		merged, err = specmerge.MergeSpecs(ctx, specmerge.MergeSpecsInput{Specs: specs, ExampleLimit: &limit})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(messageNamed(merged, "/webhooks", "push")["examples"]).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,192 @@
+package wsmerge
+
+import (
+	"context"
+	"fmt"
+	"github.com/lithictech/moxpopuli/asyncapispec"
+	"github.com/lithictech/moxpopuli/asyncapispecmerge/internal"
+	"github.com/lithictech/moxpopuli/cloudevents"
+	moxinternal "github.com/lithictech/moxpopuli/internal"
+	"github.com/lithictech/moxpopuli/schemamerge"
+	"github.com/pkg/errors"
+	"net/url"
+)
+
+func MergeWs(ctx context.Context, in internal.MergeInput) error {
+	channels := in.Spec.GetOrAddChannels()
+	servers := in.Spec.GetOrAddServers()
+	events := internal.WithPositions(in.EventIterator)
+	for events.Next() {
+		event, position, err := internal.ReadEvent(ctx, events)
+		if err != nil {
+			return errors.Wrap(err, "reading events")
+		}
+		var wevent WsEvent
+		if wev, ok := event.(WsEvent); ok {
+			wevent = wev
+		} else if mapev, ok := event.(map[string]interface{}); ok {
+			wevent, err = NewWsEvent(mapev)
+			if err != nil {
+				return err
+			}
+		} else {
+			return errors.New("event must be a WsEvent or map[string]interface{}")
+		}
+		if err := wevent.Validate(); err != nil {
+			return err
+		}
+		eventUrl, err := url.Parse(wevent.Url)
+		if err != nil {
+			return errors.Wrap(err, "could not parse event url")
+		}
+		path := eventUrl.Path
+		if path == "" {
+			path = "/"
+		}
+		srv := servers.GetOrAddServer(eventUrl.Host)
+		srv["url"] = eventUrl.Host
+		srv["protocol"] = eventUrl.Scheme
+		headers, query := internal.MergeSecurity(in.Spec, srv, wevent.Headers, eventUrl.Query())
+		// Different hosts can serve unrelated streams at the same path,
+		// so the host is part of the channel name.
+		chanItem := channels.GetOrAddItem(eventUrl.Host + path)
+		chanItem.AddServer(eventUrl.Host)
+		wsBinding := chanItem.GetOrAddBindings().GetOrAddWs()
+		wsBinding["method"] = "GET"
+		wsBinding["bindingVersion"] = bindingVersion
+		queryMergeResult, err := schemamerge.MergeOne(ctx, schemamerge.MergeOneInput{Schema: wsBinding.GetOrAddOrTypeQuery(), Payload: moxinternal.UrlValuesToMap(query)})
+		if err != nil {
+			return errors.Wrap(err, "merging query")
+		}
+		if len(queryMergeResult.Schema.MustObject().Properties()) > 0 {
+			wsBinding["query"] = queryMergeResult.Schema
+		} else {
+			delete(wsBinding, "query")
+		}
+		if wevent.Headers != nil {
+			if err := mergeHandshakeHeaders(ctx, wsBinding, headers); err != nil {
+				return err
+			}
+		}
+		var operation asyncapispec.Operation
+		if wevent.Direction == DirectionClientToServer {
+			operation = chanItem.GetOrAddPublish()
+		} else {
+			operation = chanItem.GetOrAddSubscribe()
+		}
+		var message asyncapispec.Message
+		payload := internal.DecodeJsonString(wevent.Frame)
+		if ce, ok := cloudevents.FromStructured(payload); ok {
+			message, err = internal.MergeCloudEvent(ctx, in.Spec, operation, ce)
+			if err != nil {
+				return err
+			}
+			message["contentType"] = cloudevents.StructuredContentType
+			payload = ce.Data
+		} else {
+			message = internal.DiscriminatedMessage(operation, in.Discriminator, nil, payload)
+		}
+		if _, ok := message["contentType"]; !ok {
+			message["contentType"] = "application/json"
+		}
+		payloadMergeResult, err := schemamerge.MergeOne(ctx, schemamerge.MergeOneInput{
+			Schema:  message.GetOrAddPayload(),
+			Payload: payload,
+			Seen:    in.Seen(payload, position),
+		})
+		if err != nil {
+			return errors.Wrap(err, "merging frame payload")
+		}
+		message["payload"] = payloadMergeResult.Schema
+		internal.RecordExample(ctx, message, in.ExampleLimit, payloadMergeResult.TypeChanged, nil, payload)
+	}
+	return nil
+}
+
+const bindingVersion = "0.1.0"
+
+func mergeHandshakeHeaders(ctx context.Context, wsBinding asyncapispec.WsChannelBinding, headers map[string]string) error {
+	appHeaders := make(map[string]interface{}, len(headers))
+	for k, v := range headers {
+		if _, ok := handshakeHeaders[internal.CanonicalHeader(k)]; ok {
+			continue
+		}
+		appHeaders[k] = v
+	}
+	headerMergeResult, err := schemamerge.MergeOne(ctx, schemamerge.MergeOneInput{Schema: wsBinding.GetOrAddOrTypeHeaders(), Payload: appHeaders})
+	if err != nil {
+		return errors.Wrap(err, "merging handshake headers")
+	}
+	if len(headerMergeResult.Schema.MustObject().Properties()) > 0 {
+		wsBinding["headers"] = headerMergeResult.Schema
+	} else {
+		delete(wsBinding, "headers")
+	}
+	return nil
+}
+
+// These are part of every handshake so are not worth describing.
+var handshakeHeaders = internal.LinesToHeaderNames(`Connection
+Host
+Upgrade
+Sec-WebSocket-Accept
+Sec-WebSocket-Extensions
+Sec-WebSocket-Key
+Sec-WebSocket-Version`)
+
+const (
+	DirectionClientToServer = "client_to_server"
+	DirectionServerToClient = "server_to_client"
+)
+
+type WsEvent struct {
+	Url       string            `json:"url" description:"URL the connection was opened to, like 'wss://api.example.com/v1/stream?token=abc'."`
+	Direction string            `json:"direction" enum:"client_to_server,server_to_client" description:"Which peer sent the frame."`
+	Frame     interface{}       `json:"frame" description:"Frame contents. Strings are parsed as JSON where possible."`
+	Headers   map[string]string `json:"headers" description:"Handshake request headers, if known."`
+}
+
+func (w WsEvent) Validate() error {
+	if w.Direction != DirectionClientToServer && w.Direction != DirectionServerToClient {
+		return errors.Errorf("event direction must be '%s' or '%s'", DirectionClientToServer, DirectionServerToClient)
+	}
+	return nil
+}
+
+func NewWsEvent(e map[string]interface{}) (WsEvent, error) {
+	w := WsEvent{}
+	if v, ok := e["url"]; ok {
+		if vt, ok := v.(string); ok {
+			w.Url = vt
+		} else {
+			return w, errors.New("event url must be a string")
+		}
+	} else {
+		return w, errors.New("event requires 'url' key")
+	}
+	if v, ok := e["direction"]; ok {
+		if vt, ok := v.(string); ok {
+			w.Direction = vt
+		} else {
+			return w, errors.New("event direction must be a string")
+		}
+	} else {
+		return w, errors.New("event requires 'direction' key")
+	}
+	if v, ok := e["frame"]; ok {
+		w.Frame = v
+	} else {
+		return w, errors.New("event requires 'frame' key")
+	}
+	if v, ok := e["headers"]; ok && v != nil {
+		if vt, ok := v.(map[string]interface{}); !ok {
+			return w, errors.New("event headers must be a map[string]interface{}")
+		} else {
+			w.Headers = make(map[string]string, len(vt))
+			for k, v := range vt {
+				w.Headers[k] = fmt.Sprintf("%v", v)
+			}
+		}
+	}
+	return w, nil
+}
@@ -0,0 +1,145 @@
+package wsmerge_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/lithictech/moxpopuli/asyncapispec"
+	"github.com/lithictech/moxpopuli/asyncapispecmerge/internal"
+	"github.com/lithictech/moxpopuli/asyncapispecmerge/wsmerge"
+	"github.com/lithictech/moxpopuli/moxio"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestWsmerge(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "wsmerge Suite")
+}
+
+var _ = Describe("wsmerge", func() {
+	ctx := context.Background()
+
+	mergeEvents := func(events ...interface{}) asyncapispec.Specification {
+		spec := asyncapispec.Specification{}
+		Expect(wsmerge.MergeWs(ctx, internal.MergeInput{
+			Spec:          spec,
+			EventIterator: moxio.NewMemoryIterator(events),
+		})).To(Succeed())
+		return spec
+	}
+
+	It("separates client and server frames and records the handshake", func() {
+		spec := mergeEvents(
+			map[string]interface{}{
+				"url":       "ws://localhost:8080/v1/stream?channel=trades",
+				"direction": "client_to_server",
+				"frame":     `{"op": "subscribe"}`,
+				"headers":   map[string]interface{}{"X-Api-Version": "2", "Sec-WebSocket-Key": "abc"},
+			},
+			map[string]interface{}{
+				"url":       "ws://localhost:8080/v1/stream?channel=trades",
+				"direction": "server_to_client",
+				"frame":     map[string]interface{}{"price": 5},
+			},
+		)
+		b, err := json.Marshal(spec["channels"])
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(b)).To(MatchJSON(`{
+			"localhost:8080/v1/stream": {
+				"servers": ["localhost:8080"],
+				"bindings": {
+					"ws": {
+						"bindingVersion": "0.1.0",
+						"headers": {
+							"properties": {
+								"X-Api-Version": {
+									"format": "numerical",
+									"type": "string",
+									"x-seenMaximum": "2",
+									"x-seenMinimum": "2",
+									"x-seenStrings": ["2"],
+									"x-valueCounts": {"2": 1}
+								}
+							},
+							"type": "object",
+							"x-samples": 1
+						},
+						"method": "GET",
+						"query": {
+							"properties": {
+								"channel": {
+									"type": "string",
+									"x-lengthStats": {"count": 2, "integer": true, "sum": 12, "m2": 0, "mean": 6, "variance": 0, "positive": {"91": 2}},
+									"x-samples": 2,
+									"x-seenMaxLength": 6,
+									"x-seenMinLength": 6,
+									"x-seenStrings": ["trades"],
+									"x-valueCounts": {"trades": 2}
+								}
+							},
+							"type": "object",
+							"x-samples": 2
+						}
+					}
+				},
+				"publish": {
+					"message": {
+						"contentType": "application/json",
+						"payload": {
+							"properties": {
+								"op": {
+									"type": "string",
+									"x-lengthStats": {"count": 1, "integer": true, "sum": 9, "m2": 0, "mean": 9, "variance": 0, "positive": {"111": 1}},
+									"x-seenMaxLength": 9,
+									"x-seenMinLength": 9,
+									"x-seenStrings": ["subscribe"],
+									"x-valueCounts": {"subscribe": 1}
+								}
+							},
+							"type": "object",
+							"x-samples": 1
+						}
+					}
+				},
+				"subscribe": {
+					"message": {
+						"contentType": "application/json",
+						"payload": {
+							"properties": {
+								"price": {
+									"format": "int32",
+									"type": "integer",
+									"x-seenMaximum": 5,
+									"x-seenMinimum": 5,
+									"x-stats": {"count": 1, "integer": true, "sum": 5, "m2": 0, "mean": 5, "variance": 0, "positive": {"82": 1}}
+								}
+							},
+							"type": "object",
+							"x-samples": 1
+						}
+					}
+				}
+			}
+		}`))
+	})
+
+	It("keeps the same path on different hosts in separate channels", func() {
+		spec := mergeEvents(
+			map[string]interface{}{
+				"url":       "wss://a.example.com/stream",
+				"direction": "client_to_server",
+				"frame":     `{"op": "subscribe"}`,
+			},
+			map[string]interface{}{
+				"url":       "wss://b.example.com/stream",
+				"direction": "client_to_server",
+				"frame":     `{"symbol": "ABC"}`,
+			},
+		)
+		channels := spec.GetOrAddChannels()
+		Expect(channels).To(HaveLen(2))
+		Expect(channels.GetOrAddItem("a.example.com/stream").Servers()).To(Equal([]string{"a.example.com"}))
+		Expect(channels.GetOrAddItem("b.example.com/stream").Servers()).To(Equal([]string{"b.example.com"}))
+	})
+})
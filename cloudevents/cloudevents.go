@@ -0,0 +1,219 @@
+// Package cloudevents recognizes and rebuilds CloudEvents envelopes,
+// in both structured mode (envelope attributes in the body)
+// and binary mode (envelope attributes in 'ce-' headers).
+// See https://github.com/cloudevents/spec/blob/v1.0.2/cloudevents/spec.md
+package cloudevents
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+//goland:noinspection GoSnakeCaseUsage
+const (
+	MODE_STRUCTURED = "structured"
+	MODE_BINARY     = "binary"
+)
+
+const SpecVersion = "1.0"
+
+// StructuredContentType is the content type of structured-mode events encoded as JSON.
+const StructuredContentType = "application/cloudevents+json"
+
+// HeaderPrefix prefixes each attribute name in binary-mode HTTP headers.
+const HeaderPrefix = "ce-"
+
+type Event struct {
+	Mode string
+	// Type is the 'type' attribute, which identifies the kind of event.
+	Type string
+	// Attributes are all context attributes other than 'type' and 'data',
+	// like 'id', 'source', 'specversion', and any extensions.
+	// In binary mode, keys are the header names, like 'ce-id';
+	// in structured mode, keys are attribute names, like 'id'.
+	Attributes map[string]interface{}
+	// Data is the event payload.
+	Data interface{}
+	// DataBase64 is true if the data was sent base64-encoded in 'data_base64' (structured mode only).
+	// Data is the decoded payload, parsed as JSON if possible.
+	DataBase64 bool
+}
+
+// Detect returns the CloudEvent carried in the headers and body, if any.
+// Headers can be nil for protocols without headers.
+func Detect(headers map[string]string, body interface{}) (Event, bool) {
+	if e, ok := FromBinary(headers, body); ok {
+		return e, true
+	}
+	return FromStructured(body)
+}
+
+// FromBinary returns the event if the headers include the required 'ce-' attributes.
+func FromBinary(headers map[string]string, body interface{}) (Event, bool) {
+	e := Event{Mode: MODE_BINARY, Attributes: make(map[string]interface{}, 4), Data: body}
+	for k, v := range headers {
+		ck := strings.ToLower(k)
+		if !strings.HasPrefix(ck, HeaderPrefix) {
+			continue
+		}
+		if ck == HeaderPrefix+"type" {
+			e.Type = v
+		} else {
+			e.Attributes[k] = v
+		}
+	}
+	if e.Type == "" || !hasAttribute(e.Attributes, HeaderPrefix+"specversion") {
+		return Event{}, false
+	}
+	return e, true
+}
+
+// FromStructured returns the event if the body is a JSON object with the required attributes.
+func FromStructured(body interface{}) (Event, bool) {
+	m, ok := body.(map[string]interface{})
+	if !ok {
+		return Event{}, false
+	}
+	typ, ok := m["type"].(string)
+	if !ok || typ == "" {
+		return Event{}, false
+	}
+	if _, ok := m["specversion"].(string); !ok {
+		return Event{}, false
+	}
+	if _, ok := m["source"].(string); !ok {
+		return Event{}, false
+	}
+	if _, ok := m["id"]; !ok {
+		return Event{}, false
+	}
+	e := Event{Mode: MODE_STRUCTURED, Type: typ, Attributes: make(map[string]interface{}, len(m))}
+	for k, v := range m {
+		switch k {
+		case "type":
+		case "data":
+			e.Data = v
+		case "data_base64":
+			e.Data, e.DataBase64 = decodeBase64(v)
+		default:
+			e.Attributes[k] = v
+		}
+	}
+	return e, true
+}
+
+// decodeBase64 returns the decoded data, and false if it isn't base64 (so it is kept as it was).
+func decodeBase64(v interface{}) (interface{}, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return v, false
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return v, false
+	}
+	var doc interface{}
+	if err := json.Unmarshal(b, &doc); err == nil {
+		return doc, true
+	}
+	return string(b), true
+}
+
+func encodeBase64(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func hasAttribute(attrs map[string]interface{}, name string) bool {
+	for k := range attrs {
+		if strings.ToLower(k) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// IsHeader returns true if the header name is a binary-mode attribute header.
+func IsHeader(name string) bool {
+	return strings.HasPrefix(strings.ToLower(name), HeaderPrefix)
+}
+
+// Binary returns the headers and body for a binary-mode event.
+func (e Event) Binary() (map[string]string, interface{}) {
+	headers := make(map[string]string, len(e.Attributes)+1)
+	for k, v := range e.Attributes {
+		headers[k] = fmt.Sprintf("%v", v)
+	}
+	headers[HeaderPrefix+"type"] = e.Type
+	return headers, e.Data
+}
+
+// Structured returns the body for a structured-mode event.
+func (e Event) Structured() map[string]interface{} {
+	body := make(map[string]interface{}, len(e.Attributes)+2)
+	for k, v := range e.Attributes {
+		body[k] = v
+	}
+	body["type"] = e.Type
+	if e.Data == nil {
+		return body
+	}
+	if e.DataBase64 {
+		body["data_base64"] = encodeBase64(e.Data)
+	} else {
+		body["data"] = e.Data
+	}
+	return body
+}
+
+// TraitName is the name of the AsyncAPI message trait (under components.messageTraits)
+// that describes envelopes of the given mode.
+func TraitName(mode string) string {
+	return "cloudevents-" + mode
+}
+
+// TraitModeField is the extension field on the message trait storing the envelope mode.
+const TraitModeField = "x-cloudevents-mode"
+
+// TraitEnvelopeField is the extension field on the structured-mode message trait
+// storing the schema of the envelope attributes, which are in the body rather than in headers.
+const TraitEnvelopeField = "x-cloudevents-envelope"
+
+// EnvelopeField returns the field of the message trait with the schema of the envelope attributes:
+// 'headers' in binary mode, and TraitEnvelopeField in structured mode.
+func EnvelopeField(mode string) string {
+	if mode == MODE_BINARY {
+		return "headers"
+	}
+	return TraitEnvelopeField
+}
+
+// MessageDataBase64Field is the extension field on a message that is true
+// if its events were sent with 'data_base64' rather than 'data'.
+const MessageDataBase64Field = "x-cloudevents-dataBase64"
+
+// SetAttribute sets the named attribute (like 'id', not 'ce-id'),
+// replacing any existing value regardless of the case of its key.
+func (e *Event) SetAttribute(name string, value interface{}) {
+	key := name
+	if e.Mode == MODE_BINARY {
+		key = HeaderPrefix + name
+	}
+	if e.Attributes == nil {
+		e.Attributes = make(map[string]interface{}, 4)
+	}
+	for k := range e.Attributes {
+		if strings.ToLower(k) == key {
+			delete(e.Attributes, k)
+		}
+	}
+	e.Attributes[key] = value
+}
@@ -0,0 +1,106 @@
+package cloudevents_test
+
+import (
+	"encoding/base64"
+	"github.com/lithictech/moxpopuli/cloudevents"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCloudevents(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "cloudevents Suite")
+}
+
+var _ = Describe("cloudevents", func() {
+	structured := func() map[string]interface{} {
+		return map[string]interface{}{
+			"specversion": "1.0",
+			"type":        "order.created",
+			"source":      "/orders",
+			"id":          "A234-1234",
+			"subject":     "5",
+			"data":        map[string]interface{}{"order_id": 5},
+		}
+	}
+
+	It("detects structured events", func() {
+		e, ok := cloudevents.Detect(nil, structured())
+		Expect(ok).To(BeTrue())
+		Expect(e.Mode).To(Equal(cloudevents.MODE_STRUCTURED))
+		Expect(e.Type).To(Equal("order.created"))
+		Expect(e.Attributes).To(Equal(map[string]interface{}{
+			"specversion": "1.0", "source": "/orders", "id": "A234-1234", "subject": "5",
+		}))
+		Expect(e.Data).To(Equal(map[string]interface{}{"order_id": 5}))
+		Expect(e.DataBase64).To(BeFalse())
+		Expect(e.Structured()).To(Equal(structured()))
+	})
+
+	It("does not detect bodies without the required attributes", func() {
+		for _, attr := range []string{"specversion", "type", "source", "id"} {
+			body := structured()
+			delete(body, attr)
+			_, ok := cloudevents.Detect(nil, body)
+			Expect(ok).To(BeFalse(), attr)
+		}
+		_, ok := cloudevents.Detect(nil, "order.created")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("decodes data_base64, and encodes it again", func() {
+		body := structured()
+		delete(body, "data")
+		body["data_base64"] = base64.StdEncoding.EncodeToString([]byte(`{"order_id": 5}`))
+		e, ok := cloudevents.FromStructured(body)
+		Expect(ok).To(BeTrue())
+		Expect(e.DataBase64).To(BeTrue())
+		Expect(e.Data).To(Equal(map[string]interface{}{"order_id": float64(5)}))
+		Expect(e.Structured()).To(HaveKeyWithValue("data_base64", base64.StdEncoding.EncodeToString([]byte(`{"order_id":5}`))))
+		Expect(e.Structured()).ToNot(HaveKey("data"))
+
+		body["data_base64"] = base64.StdEncoding.EncodeToString([]byte("plain text"))
+		e, _ = cloudevents.FromStructured(body)
+		Expect(e.Data).To(Equal("plain text"))
+		Expect(e.Structured()).To(HaveKeyWithValue("data_base64", body["data_base64"]))
+
+		body["data_base64"] = "not base64!"
+		e, _ = cloudevents.FromStructured(body)
+		Expect(e.DataBase64).To(BeFalse())
+		Expect(e.Data).To(Equal("not base64!"))
+	})
+
+	It("detects binary events from ce- headers", func() {
+		headers := map[string]string{
+			"Ce-Specversion": "1.0",
+			"Ce-Type":        "order.created",
+			"Ce-Source":      "/orders",
+			"Ce-Id":          "A234-1234",
+			"Content-Type":   "application/json",
+		}
+		e, ok := cloudevents.Detect(headers, map[string]interface{}{"order_id": 5})
+		Expect(ok).To(BeTrue())
+		Expect(e.Mode).To(Equal(cloudevents.MODE_BINARY))
+		Expect(e.Type).To(Equal("order.created"))
+		Expect(e.Attributes).To(Equal(map[string]interface{}{
+			"Ce-Specversion": "1.0", "Ce-Source": "/orders", "Ce-Id": "A234-1234",
+		}))
+
+		e.SetAttribute("id", "B1")
+		out, body := e.Binary()
+		Expect(out).To(Equal(map[string]string{
+			"ce-id": "B1", "Ce-Specversion": "1.0", "Ce-Source": "/orders", "ce-type": "order.created",
+		}))
+		Expect(body).To(Equal(map[string]interface{}{"order_id": 5}))
+
+		delete(headers, "Ce-Specversion")
+		_, ok = cloudevents.FromBinary(headers, nil)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("describes the envelope as headers in binary mode, and in an extension in structured mode", func() {
+		Expect(cloudevents.EnvelopeField(cloudevents.MODE_BINARY)).To(Equal("headers"))
+		Expect(cloudevents.EnvelopeField(cloudevents.MODE_STRUCTURED)).To(Equal(cloudevents.TraitEnvelopeField))
+	})
+})
@@ -5,12 +5,19 @@ import (
 	"fmt"
 	"github.com/lithictech/go-aperitif/logctx"
 	"github.com/lithictech/moxpopuli"
+	"github.com/lithictech/moxpopuli/asyncapispec"
+	"github.com/lithictech/moxpopuli/driftalert"
 	"github.com/lithictech/moxpopuli/moxio"
+	"github.com/lithictech/moxpopuli/moxrand"
+	"github.com/lithictech/moxpopuli/openapispec"
 	"github.com/lithictech/moxpopuli/schema"
+	"github.com/lithictech/moxpopuli/schemadiff"
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
 	"log"
+	"net/url"
 	"os"
+	"path/filepath"
 )
 
 func Execute() {
@@ -20,9 +27,17 @@ func Execute() {
 		},
 		Commands: []*cli.Command{
 			schemagenCmd,
+			schemamergeCmd,
+			schemadiffCmd,
+			validateCmd,
+			anomaliesCmd,
+			schemapruneCmd,
 			datagenCmd,
 			fixtureGenCmd,
 			specgenCmd,
+			specconvertCmd,
+			specmergeCmd,
+			specdiffCmd,
 			voxCmd,
 			serverCmd,
 			{
@@ -73,6 +88,18 @@ var loaderArgs = []cli.Flag{
 	},
 }
 
+// fileOrLoader returns a file loader for s if it is a plain file path, like 'old.json',
+// so commands can take paths as arguments. Otherwise s is returned as the loader name.
+func fileOrLoader(s string) string {
+	if u, err := url.Parse(s); err != nil || u.Scheme != "" || s == "-" || s == "_" || s == "." {
+		return s
+	}
+	if filepath.IsAbs(s) {
+		return "file://" + s
+	}
+	return "file://./" + s
+}
+
 func loadSchema(ctx context.Context, c *cli.Context) (schema.Schema, error) {
 	return schema.Load(ctx, c.String("loader"), c.String("loader-arg"))
 }
@@ -81,6 +108,79 @@ func loadMap(ctx context.Context, c *cli.Context) (map[string]interface{}, error
 	return moxio.LoadOneMap(ctx, c.String("loader"), c.String("loader-arg"))
 }
 
+// loadSpec loads an AsyncAPI spec in the 2.x structure Mox Populi works with,
+// and returns the major version it was stored as.
+func loadSpec(ctx context.Context, c *cli.Context) (asyncapispec.Specification, int, error) {
+	m, err := loadMap(ctx, c)
+	if err != nil {
+		return nil, 0, err
+	}
+	return specFromMap(m)
+}
+
+// specFromMap returns the loaded document in the 2.x structure, and the major version it was stored as.
+func specFromMap(m map[string]interface{}) (asyncapispec.Specification, int, error) {
+	spec := asyncapispec.Specification(m)
+	if _, ok := spec["openapi"]; ok {
+		return nil, 0, errors.New("cannot load an OpenAPI document, load the AsyncAPI spec it was generated from")
+	}
+	major := spec.MajorVersion()
+	if major == 3 {
+		var err error
+		if spec, err = asyncapispec.Downgrade(spec); err != nil {
+			return nil, 0, errors.Wrap(err, "converting from AsyncAPI 3")
+		}
+	}
+	return spec, major, nil
+}
+
+var asyncapiVersionFlag = &cli.StringFlag{
+	Name: "asyncapi-version",
+	Usage: "AsyncAPI version to save the spec as, like '2.6.0' or '3.0.0'. " +
+		"If not given, use the version of the loaded spec (or 2.x for new specs).",
+}
+
+var specFormatFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "format",
+		Value: "asyncapi",
+		Usage: "Format to save the spec as, 'asyncapi' or 'openapi'. " +
+			"OpenAPI documents describe each HTTP channel as an OpenAPI 3.1 webhook, " +
+			"and cannot be loaded to learn more events; keep an AsyncAPI spec and convert it.",
+	},
+	&cli.BoolFlag{
+		Name:  "openapi-extensions",
+		Usage: "If given, keep the 'x-' fields Mox Populi uses internally when saving as OpenAPI.",
+	},
+}
+
+// saveSpec saves the spec as the --asyncapi-version, or as loadedMajor version if not given.
+// If --format=openapi, save the spec as an OpenAPI document instead.
+func saveSpec(ctx context.Context, c *cli.Context, spec asyncapispec.Specification, loadedMajor int) error {
+	switch c.String("format") {
+	case "", "asyncapi":
+	case "openapi":
+		doc, err := openapispec.FromAsyncApi(openapispec.FromAsyncApiInput{Spec: spec, KeepExtensions: c.Bool("openapi-extensions")})
+		if err != nil {
+			return err
+		}
+		return save(ctx, c, doc)
+	default:
+		return errors.New("unsupported format")
+	}
+	major, err := asyncapispec.ParseMajorVersion(c.String("asyncapi-version"))
+	if err != nil {
+		return err
+	}
+	if major == 0 {
+		major = loadedMajor
+	}
+	if major == 3 {
+		spec = asyncapispec.Upgrade(spec)
+	}
+	return save(ctx, c, spec)
+}
+
 var saverArgs = []cli.Flag{
 	&cli.StringFlag{
 		Name:    "saver",
@@ -115,6 +215,13 @@ var countFlag = &cli.IntFlag{
 	Usage: "Number of payloads to generate.",
 }
 
+var workersFlag = &cli.IntFlag{
+	Name:  "workers",
+	Value: 1,
+	Usage: "Number of goroutines to learn from payloads on. If more than 1, payloads are processed " +
+		"in parallel, with the same result as learning sequentially (except for specgen examples).",
+}
+
 func s1(s string) []string {
 	return []string{s}
 }
@@ -126,6 +233,60 @@ var examplesFlag = &cli.IntFlag{
 		"If not given or <= 0, do not record examples.",
 }
 
+var seedFlag = &cli.Int64Flag{
+	Name: "seed",
+	Usage: "Seed for the random sampling of examples, so the same inputs produce the same examples. " +
+		"If not given, examples are sampled differently each run.",
+}
+
+// withSeed seeds the random source of ctx if the seed flag is set.
+func withSeed(ctx context.Context, c *cli.Context) context.Context {
+	if c.IsSet(seedFlag.Name) {
+		return moxrand.WithSeed(ctx, c.Int64(seedFlag.Name))
+	}
+	return ctx
+}
+
+var alertFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "alert-webhook",
+		Usage: "URL to POST a JSON notification to when the result changes. See README -> Drift Alerts for more info.",
+	},
+	&cli.StringFlag{
+		Name:  "alert-saver",
+		Usage: "Saver to write the JSON notification to when the result changes, like 'file://./alert.json'.",
+	},
+	&cli.StringFlag{
+		Name:  "alert-on",
+		Value: string(schemadiff.S_BREAKING),
+		Usage: "Notify about changes of at least this severity: 'breaking', 'non-breaking', or 'informational'.",
+	},
+	&cli.StringFlag{
+		Name:  "alert-name",
+		Usage: "Name of the job to include in notifications, to tell apart notifications from different jobs.",
+	},
+	&cli.DurationFlag{
+		Name:  "alert-interval",
+		Usage: "Send at most one notification per interval, like '1h'. Requires --alert-state.",
+	},
+	&cli.StringFlag{
+		Name:  "alert-state",
+		Usage: "Loader and saver to remember when the last notification was sent, like 'file://./alertstate.json'.",
+	},
+}
+
+func alertConfig(c *cli.Context) (driftalert.Config, error) {
+	cfg := driftalert.Config{
+		WebhookURL:  c.String("alert-webhook"),
+		Saver:       c.String("alert-saver"),
+		MinSeverity: schemadiff.Severity(c.String("alert-on")),
+		Name:        c.String("alert-name"),
+		Interval:    c.Duration("alert-interval"),
+		State:       c.String("alert-state"),
+	}
+	return cfg, cfg.Validate()
+}
+
 func examplesValue(c *cli.Context) *int {
 	var examples int
 	if c.IsSet("examples") {
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/lithictech/moxpopuli/anomaly"
+	"github.com/lithictech/moxpopuli/moxio"
+	"github.com/lithictech/moxpopuli/moxjson"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+var anomaliesCmd = &cli.Command{
+	Name:  "anomalies",
+	Usage: "Score how unusual each payload is compared to a learned schema, and print the unusual payloads and why.",
+	Flags: append(
+		append([]cli.Flag{}, loaderArgs...),
+		&cli.StringFlag{
+			Name:     "payload-loader",
+			Aliases:  s1("p"),
+			Required: true,
+			Usage: "Name of the payload loader routine, like 'postgres://x:y@localhost:5432/mydb'. " +
+				"Use '-' to read from stdin, or a space to parse payload-loader-arg as the payload. " +
+				"See README -> Iterator Loaders for more info.",
+		},
+		&cli.StringFlag{
+			Name:    "payload-loader-arg",
+			Aliases: s1("pa"),
+			Usage: "Value to pass to the payload loader routine, like a SQL query. " +
+				"See README -> Iterator Loaders for more info.",
+		},
+		&cli.Float64Flag{
+			Name:  "threshold",
+			Usage: "Only print payloads scoring above this. See README -> Scoring Anomalies for how scores work.",
+		},
+		diffFormatFlag,
+	),
+	Action: func(c *cli.Context) error {
+		ctx, _ := newCtx()
+		sch, err := loadSchema(ctx, c)
+		if err != nil {
+			return err
+		}
+		if len(sch) == 0 {
+			return errors.New("no schema loaded, pass a loader")
+		}
+		payloadIterator, err := moxio.LoadIterator(ctx, c.String("payload-loader"), c.String("payload-loader-arg"))
+		if err != nil {
+			return errors.Wrap(err, "payload loader iterator")
+		}
+		out, err := anomaly.Score(ctx, anomaly.ScoreInput{
+			Schema:          sch,
+			PayloadIterator: payloadIterator,
+			Threshold:       c.Float64("threshold"),
+		})
+		if err != nil {
+			return err
+		}
+		switch c.String("format") {
+		case "", "text":
+			for _, p := range out.Payloads {
+				fmt.Fprintf(c.App.Writer, "payload %d scored %v\n", p.Index, p.Score)
+				for _, r := range p.Reasons {
+					fmt.Fprintf(c.App.Writer, "  %8v %s: %s (%s)\n", r.Score, r.Path, r.Message, r.Kind)
+				}
+			}
+			fmt.Fprintf(c.App.Writer, "%d of %d payloads scored above %v, max score %v\n",
+				len(out.Payloads), out.Scored, c.Float64("threshold"), out.MaxScore)
+		case "json":
+			if out.Payloads == nil {
+				out.Payloads = []anomaly.PayloadScore{}
+			}
+			return moxjson.NewPrettyEncoder(c.App.Writer).Encode(out)
+		default:
+			return errors.New("unsupported format")
+		}
+		return nil
+	},
+}
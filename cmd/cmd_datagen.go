@@ -15,16 +15,26 @@ var datagenCmd = &cli.Command{
 			Name:  "count",
 			Value: 1,
 			Usage: "Number of payloads to generate.",
+		},
+		&cli.StringFlag{
+			Name:  "nulls",
+			Value: string(datagen.N_OBSERVED),
+			Usage: "How often to generate nullable values as null: " +
+				"'observed' (as often as they were seen), 'max' (always), or 'none' (never).",
 		}),
 	Action: func(c *cli.Context) error {
 		ctx, _ := newCtx()
+		nulls, err := datagen.ParseNullMode(c.String("nulls"))
+		if err != nil {
+			return err
+		}
 		schema, err := loadSchema(ctx, c)
 		if err != nil {
 			return err
 		}
 		enc := moxjson.NewPrettyEncoder(c.App.Writer)
 		for i := 0; i < c.Int("count"); i++ {
-			pl := datagen.Generate(ctx, datagen.GenerateInput{Schema: schema})
+			pl := datagen.Generate(ctx, datagen.GenerateInput{Schema: schema, Nulls: nulls})
 			if err := enc.Encode(pl); err != nil {
 				return err
 			}
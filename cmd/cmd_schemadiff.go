@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/lithictech/moxpopuli/moxjson"
+	"github.com/lithictech/moxpopuli/schema"
+	"github.com/lithictech/moxpopuli/schemadiff"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	"io"
+)
+
+var schemadiffCmd = &cli.Command{
+	Name:      "schemadiff",
+	Usage:     "Report the changes between two schemas, and exit with an error if any are breaking for consumers.",
+	ArgsUsage: "<old schema> <new schema>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "loader-arg",
+			Aliases: s1("la"),
+			Usage: "Value to pass to each loader, like a JSON path. " +
+				"See README -> Single Objects Load and Save for more info.",
+		},
+		diffFormatFlag,
+	},
+	Action: func(c *cli.Context) error {
+		ctx, _ := newCtx()
+		if c.NArg() != 2 {
+			return errors.New("expected the old and new schema as arguments")
+		}
+		schemas := make([]schema.Schema, 2)
+		for i, arg := range c.Args().Slice() {
+			loader := fileOrLoader(arg)
+			sch, err := schema.Load(ctx, loader, c.String("loader-arg"))
+			if err != nil {
+				return errors.Wrapf(err, "loading %s", loader)
+			}
+			if len(sch) == 0 {
+				return errors.Errorf("no schema loaded from %s", loader)
+			}
+			schemas[i] = sch
+		}
+		changes := schemadiff.Diff(schemas[0], schemas[1])
+		return printChanges(c.App.Writer, c.String("format"), changes, func(ch schemadiff.Change) (schemadiff.Severity, string, string) {
+			return ch.Severity, ch.Path, ch.Message
+		})
+	},
+}
+
+// printChanges writes changes to w as text, or as JSON with whether any are breaking.
+// describe returns the severity of a change, where it is, and its message.
+func printChanges[T interface{}](w io.Writer, format string, changes []T, describe func(T) (schemadiff.Severity, string, string)) error {
+	counts := map[schemadiff.Severity]int{}
+	for _, ch := range changes {
+		sev, _, _ := describe(ch)
+		counts[sev]++
+	}
+	switch format {
+	case "", "text":
+		for _, ch := range changes {
+			sev, where, msg := describe(ch)
+			fmt.Fprintf(w, "%-14s %s: %s\n", sev, where, msg)
+		}
+		fmt.Fprintf(w, "%d breaking, %d non-breaking, %d informational changes\n",
+			counts[schemadiff.S_BREAKING], counts[schemadiff.S_NON_BREAKING], counts[schemadiff.S_INFO])
+	case "json":
+		if changes == nil {
+			changes = []T{}
+		}
+		out := map[string]interface{}{"breaking": counts[schemadiff.S_BREAKING] > 0, "changes": changes}
+		if err := moxjson.NewPrettyEncoder(w).Encode(out); err != nil {
+			return err
+		}
+	default:
+		return errors.New("unsupported format")
+	}
+	if n := counts[schemadiff.S_BREAKING]; n > 0 {
+		return errors.Errorf("found %d breaking changes", n)
+	}
+	return nil
+}
+
+var diffFormatFlag = &cli.StringFlag{
+	Name:  "format",
+	Value: "text",
+	Usage: "Output format, 'text' or 'json'.",
+}
@@ -1,17 +1,21 @@
 package cmd
 
 import (
+	"github.com/lithictech/moxpopuli/driftalert"
+	"github.com/lithictech/moxpopuli/internal"
 	"github.com/lithictech/moxpopuli/moxio"
+	"github.com/lithictech/moxpopuli/schemadiff"
 	"github.com/lithictech/moxpopuli/schemamerge"
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
+	"net/url"
 )
 
 var schemagenCmd = &cli.Command{
 	Name:  "schemagen",
 	Usage: "Given an existing schema, and a series of payloads, fit the schema to the payloads and re-save it.",
 	Flags: append(
-		append(loaderArgs, saverArgs...),
+		append(append(append(loaderArgs, saverArgs...), alertFlags...), provenanceFlags...),
 		&cli.StringFlag{
 			Name:     "payload-loader",
 			Aliases:  s1("p"),
@@ -27,28 +31,97 @@ var schemagenCmd = &cli.Command{
 				"See README -> Iterator Loaders for more info.",
 		},
 		examplesFlag,
+		seedFlag,
+		workersFlag,
 	),
 	Action: func(c *cli.Context) error {
 		ctx, _ := newCtx()
+		ctx = withSeed(ctx, c)
+		alerts, err := alertConfig(c)
+		if err != nil {
+			return err
+		}
 		sch, err := loadSchema(ctx, c)
 		if err != nil {
 			return err
 		}
+		old := sch.DeepClone()
 
 		payloadIterator, err := moxio.LoadIterator(ctx, c.String("payload-loader"), c.String("payload-loader-arg"))
 		if err != nil {
 			return errors.Wrap(err, "payload loader iterator")
 		}
+		var triggers *driftalert.Triggers
+		if alerts.Enabled() {
+			triggers = driftalert.NewTriggers(old, payloadIterator)
+			payloadIterator = triggers
+		}
 
 		schout, err := schemamerge.MergeMany(ctx, schemamerge.MergeManyInput{
 			Schema:          sch,
 			PayloadIterator: payloadIterator,
 			ExampleLimit:    examplesValue(c),
+			Workers:         c.Int("workers"),
+			Provenance:      provenanceInput(c, "payload-loader"),
 		})
 		if err != nil {
 			return errors.Wrap(err, "merging schemas")
 		}
 
-		return save(ctx, c, schout.Schema)
+		if err := save(ctx, c, schout.Schema); err != nil {
+			return err
+		}
+		if alerts.Enabled() {
+			changes := driftalert.FromSchemaChanges(schemadiff.Diff(old, schout.Schema), triggers)
+			if _, err := driftalert.Alert(ctx, alerts, "schemagen", changes); err != nil {
+				return errors.Wrap(err, "alerting")
+			}
+		}
+		return nil
+	},
+}
+
+var provenanceFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name: "provenance",
+		Usage: "If given, record when and where each schema node was first and last seen " +
+			"(in x-firstSeen, x-lastSeen, x-firstSource, and x-lastSource). See README -> Provenance for more info.",
+	},
+	&cli.StringFlag{
+		Name:  "provenance-time-field",
+		Usage: "Path of each payload's event time, like 'created_at'. If not given, use the time it is merged. Implies --provenance.",
+	},
+	&cli.StringFlag{
+		Name:  "provenance-source-field",
+		Usage: "Path of each payload's identifier, like 'id'. If not given, use the loader and payload position. Implies --provenance.",
 	},
 }
+
+// provenanceInput returns the provenance to record from provenanceFlags, or nil if there is none.
+// loaderFlag is the flag naming the loader payloads come from, like 'payload-loader'.
+func provenanceInput(c *cli.Context, loaderFlag string) *schemamerge.ProvenanceInput {
+	if !c.Bool("provenance") && !c.IsSet("provenance-time-field") && !c.IsSet("provenance-source-field") {
+		return nil
+	}
+	return &schemamerge.ProvenanceInput{
+		TimeField:   c.String("provenance-time-field"),
+		SourceField: c.String("provenance-source-field"),
+		Source:      provenanceSource(c.String(loaderFlag)),
+	}
+}
+
+// provenanceSource returns the name of the payload loader to use in sources,
+// like the path of a file. Other loaders can have credentials in their URI, so only the host is used.
+func provenanceSource(uri string) string {
+	if uri == "-" {
+		return "stdin"
+	}
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme == "" {
+		return ""
+	}
+	if u.Scheme == "file" {
+		return internal.FileUriPath(u)
+	}
+	return u.Scheme + "://" + u.Host
+}
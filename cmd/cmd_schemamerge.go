@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"github.com/lithictech/moxpopuli/schema"
+	"github.com/lithictech/moxpopuli/schemamerge"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+var schemamergeCmd = &cli.Command{
+	Name:  "schemamerge",
+	Usage: "Merge schemas learned from different payloads (like by schemagen on different shards) into one schema.",
+	Flags: append(
+		[]cli.Flag{
+			&cli.StringSliceFlag{
+				Name:     "loader",
+				Aliases:  s1("l"),
+				Required: true,
+				Usage: "Name of the loader routine for each schema, like 'file://./temp/myschema.json'. " +
+					"Pass multiple times to merge multiple schemas. " +
+					"See README -> Single Objects Load and Save for more info.",
+			},
+			&cli.StringFlag{
+				Name:    "loader-arg",
+				Aliases: s1("la"),
+				Usage: "Value to pass to each loader, like a JSON path. " +
+					"See README -> Single Objects Load and Save for more info.",
+			},
+			&cli.IntFlag{
+				Name:    examplesFlag.Name,
+				Aliases: examplesFlag.Aliases,
+				Usage:   "If given, keep up to this many examples. If not given, keep the examples of all schemas.",
+			},
+			seedFlag,
+		},
+		saverArgs...,
+	),
+	Action: func(c *cli.Context) error {
+		ctx, _ := newCtx()
+		ctx = withSeed(ctx, c)
+		loaders := c.StringSlice("loader")
+		schemas := make([]schema.Schema, len(loaders))
+		for i, loader := range loaders {
+			sch, err := schema.Load(ctx, loader, c.String("loader-arg"))
+			if err != nil {
+				return errors.Wrapf(err, "loading %s", loader)
+			}
+			schemas[i] = sch
+		}
+		var exampleLimit *int
+		if c.IsSet(examplesFlag.Name) {
+			exampleLimit = examplesValue(c)
+		}
+		sch := schemamerge.MergeSchemas(ctx, schemamerge.MergeSchemasInput{
+			Schemas:      schemas,
+			ExampleLimit: exampleLimit,
+		})
+		return save(ctx, c, sch)
+	},
+}
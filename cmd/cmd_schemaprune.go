@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/lithictech/moxpopuli/schemamerge"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	"os"
+	"time"
+)
+
+var schemapruneCmd = &cli.Command{
+	Name: "schemaprune",
+	Usage: "Forget the parts of a schema (learned with schemagen --provenance) that have not been seen recently, " +
+		"and re-save it.",
+	Flags: append(
+		append(append([]cli.Flag{}, loaderArgs...), saverArgs...),
+		&cli.DurationFlag{
+			Name:     "window",
+			Required: true,
+			Usage:    "Forget what was not seen within this long, like '720h'. See README -> Provenance for more info.",
+		},
+	),
+	Action: func(c *cli.Context) error {
+		ctx, _ := newCtx()
+		sch, err := loadSchema(ctx, c)
+		if err != nil {
+			return err
+		}
+		if len(sch) == 0 {
+			return errors.New("no schema loaded, pass a loader")
+		}
+		out := schemamerge.Prune(schemamerge.PruneInput{Schema: sch, Since: time.Now().Add(-c.Duration("window"))})
+		// The schema may be saved to stdout, so report what was pruned on stderr.
+		for _, p := range out.Pruned {
+			what := p.Path
+			if p.Value != "" {
+				what += " " + p.Value
+			}
+			fmt.Fprintf(os.Stderr, "pruned %s %s, last seen %s\n", p.Kind, what, p.LastSeen.Format(time.RFC3339))
+		}
+		return save(ctx, c, out.Schema)
+	},
+}
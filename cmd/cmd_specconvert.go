@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"github.com/urfave/cli/v2"
+)
+
+var specconvertCmd = &cli.Command{
+	Name:  "specconvert",
+	Usage: "Convert an AsyncAPI specification generated by specgen to another AsyncAPI version, or to OpenAPI.",
+	Flags: append(
+		append(append(loaderArgs, saverArgs...), specFormatFlags...),
+		&cli.StringFlag{
+			Name:  asyncapiVersionFlag.Name,
+			Value: "3.0.0",
+			Usage: "AsyncAPI version to convert to, like '2.6.0' or '3.0.0'.",
+		},
+	),
+	Action: func(c *cli.Context) error {
+		ctx, _ := newCtx()
+		spec, loadedMajor, err := loadSpec(ctx, c)
+		if err != nil {
+			return err
+		}
+		return saveSpec(ctx, c, spec, loadedMajor)
+	},
+}
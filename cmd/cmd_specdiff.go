@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"github.com/lithictech/moxpopuli/asyncapispec"
+	"github.com/lithictech/moxpopuli/moxio"
+	"github.com/lithictech/moxpopuli/schemadiff"
+	"github.com/lithictech/moxpopuli/specdiff"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+var specdiffCmd = &cli.Command{
+	Name:      "specdiff",
+	Usage:     "Report the changes between two AsyncAPI specs, and exit with an error if any are breaking for consumers.",
+	ArgsUsage: "<old spec> <new spec>",
+	Flags:     []cli.Flag{diffFormatFlag},
+	Action: func(c *cli.Context) error {
+		ctx, _ := newCtx()
+		if c.NArg() != 2 {
+			return errors.New("expected the old and new spec as arguments")
+		}
+		specs := make([]asyncapispec.Specification, 2)
+		for i, arg := range c.Args().Slice() {
+			loader := fileOrLoader(arg)
+			m, err := moxio.LoadOneMap(ctx, loader, "")
+			if err != nil {
+				return errors.Wrapf(err, "loading %s", loader)
+			}
+			if len(m) == 0 {
+				return errors.Errorf("no spec loaded from %s", loader)
+			}
+			if specs[i], _, err = specFromMap(m); err != nil {
+				return errors.Wrapf(err, "loading %s", loader)
+			}
+		}
+		changes, err := specdiff.Diff(specs[0], specs[1])
+		if err != nil {
+			return err
+		}
+		return printChanges(c.App.Writer, c.String("format"), changes, func(ch specdiff.Change) (schemadiff.Severity, string, string) {
+			if ch.Path == "" {
+				return ch.Severity, ch.Location, ch.Message
+			}
+			return ch.Severity, ch.Location + " " + ch.Path, ch.Message
+		})
+	},
+}
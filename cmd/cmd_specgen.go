@@ -2,7 +2,10 @@ package cmd
 
 import (
 	"github.com/lithictech/moxpopuli/asyncapispecmerge"
+	"github.com/lithictech/moxpopuli/driftalert"
+	"github.com/lithictech/moxpopuli/internal"
 	"github.com/lithictech/moxpopuli/moxio"
+	"github.com/lithictech/moxpopuli/specdiff"
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
 )
@@ -11,7 +14,7 @@ var specgenCmd = &cli.Command{
 	Name:  "specgen",
 	Usage: "Generate an entire AsyncAPI specification based on events.",
 	Flags: append(
-		append(loaderArgs, saverArgs...),
+		append(append(append(append(loaderArgs, saverArgs...), specFormatFlags...), alertFlags...), provenanceFlags...),
 		&cli.StringFlag{
 			Name:     "event-loader",
 			Aliases:  s1("e"),
@@ -27,10 +30,31 @@ var specgenCmd = &cli.Command{
 				"See README -> Iterator Loaders for more info.",
 		},
 		bindingFlag,
+		asyncapiVersionFlag,
+		// -e is taken by --event-loader
+		&cli.IntFlag{Name: examplesFlag.Name, Usage: examplesFlag.Usage},
+		seedFlag,
+		workersFlag,
+		&cli.StringFlag{
+			Name: "discriminator",
+			Usage: "Split messages on a channel by event type, using this header (like 'X-GitHub-Event') " +
+				"or payload field (like '$.type'). Well-known webhook event headers are detected if not given. " +
+				"See README -> Message Discriminators for more info.",
+		},
 	),
 	Action: func(c *cli.Context) error {
 		ctx, _ := newCtx()
-		spec, err := loadMap(ctx, c)
+		ctx = withSeed(ctx, c)
+		alerts, err := alertConfig(c)
+		if err != nil {
+			return err
+		}
+		spec, loadedMajor, err := loadSpec(ctx, c)
+		if err != nil {
+			return err
+		}
+		// Merging modifies the spec, so keep a copy to diff against.
+		old, err := internal.ToPlainMap(spec)
 		if err != nil {
 			return err
 		}
@@ -38,20 +62,44 @@ var specgenCmd = &cli.Command{
 		if err != nil {
 			return errors.Wrap(err, "loader iterator")
 		}
+		discriminator, err := asyncapispecmerge.ParseDiscriminator(c.String("discriminator"))
+		if err != nil {
+			return err
+		}
 		var merge asyncapispecmerge.Merge
-		if c.String("binding") == "http" {
+		switch c.String("binding") {
+		case "http":
 			merge = asyncapispecmerge.MergeHttp
-		} else {
+		case "mqtt":
+			merge = asyncapispecmerge.MergeMqtt
+		case "ws":
+			merge = asyncapispecmerge.MergeWs
+		default:
 			return errors.New("unsupported binding")
 		}
 		if err := merge(ctx, asyncapispecmerge.MergeInput{
 			Spec:          spec,
 			EventIterator: iter,
 			ExampleLimit:  examplesValue(c),
+			Discriminator: discriminator,
+			Workers:       c.Int("workers"),
+			Provenance:    provenanceInput(c, "event-loader"),
 		}); err != nil {
 			return errors.Wrap(err, "merging")
 		}
 
-		return save(ctx, c, spec)
+		if err := saveSpec(ctx, c, spec, loadedMajor); err != nil {
+			return err
+		}
+		if alerts.Enabled() {
+			changes, err := specdiff.Diff(old, spec)
+			if err != nil {
+				return errors.Wrap(err, "diffing")
+			}
+			if _, err := driftalert.Alert(ctx, alerts, "specgen", driftalert.FromSpecChanges(changes)); err != nil {
+				return errors.Wrap(err, "alerting")
+			}
+		}
+		return nil
 	},
 }
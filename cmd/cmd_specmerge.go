@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"github.com/lithictech/moxpopuli/asyncapispec"
+	"github.com/lithictech/moxpopuli/asyncapispecmerge"
+	"github.com/lithictech/moxpopuli/moxio"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+var specmergeCmd = &cli.Command{
+	Name: "specmerge",
+	Usage: "Merge AsyncAPI specifications generated by specgen from different events, " +
+		"like 'specmerge -l file://./prod.json -s file://./merged.json file://./staging.json'.",
+	ArgsUsage: "[loader of each other spec to merge into the loaded spec]...",
+	Flags: append(
+		append(append(loaderArgs, saverArgs...), specFormatFlags...),
+		asyncapiVersionFlag,
+		&cli.IntFlag{
+			Name:    examplesFlag.Name,
+			Aliases: examplesFlag.Aliases,
+			Usage:   "If given, keep up to this many examples for each message. If not given, keep all examples.",
+		},
+		seedFlag,
+	),
+	Action: func(c *cli.Context) error {
+		ctx, _ := newCtx()
+		ctx = withSeed(ctx, c)
+		spec, loadedMajor, err := loadSpec(ctx, c)
+		if err != nil {
+			return err
+		}
+		specs := []asyncapispec.Specification{spec}
+		for _, loader := range c.Args().Slice() {
+			m, err := moxio.LoadOneMap(ctx, loader, "")
+			if err != nil {
+				return errors.Wrapf(err, "loading %s", loader)
+			}
+			other, _, err := specFromMap(m)
+			if err != nil {
+				return errors.Wrapf(err, "loading %s", loader)
+			}
+			specs = append(specs, other)
+		}
+		var exampleLimit *int
+		if c.IsSet(examplesFlag.Name) {
+			exampleLimit = examplesValue(c)
+		}
+		merged, err := asyncapispecmerge.MergeSpecs(ctx, asyncapispecmerge.MergeSpecsInput{
+			Specs:        specs,
+			ExampleLimit: exampleLimit,
+		})
+		if err != nil {
+			return errors.Wrap(err, "merging")
+		}
+		return saveSpec(ctx, c, merged, loadedMajor)
+	},
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/lithictech/moxpopuli/moxio"
+	"github.com/lithictech/moxpopuli/moxjson"
+	"github.com/lithictech/moxpopuli/schemamerge"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	"sort"
+)
+
+var validateCmd = &cli.Command{
+	Name:  "validate",
+	Usage: "Check payloads against a learned schema without changing it, and exit with an error if any do not fit.",
+	Flags: append(
+		append([]cli.Flag{}, loaderArgs...),
+		&cli.StringFlag{
+			Name:     "payload-loader",
+			Aliases:  s1("p"),
+			Required: true,
+			Usage: "Name of the payload loader routine, like 'postgres://x:y@localhost:5432/mydb'. " +
+				"Use '-' to read from stdin, or a space to parse payload-loader-arg as the payload. " +
+				"See README -> Iterator Loaders for more info.",
+		},
+		&cli.StringFlag{
+			Name:    "payload-loader-arg",
+			Aliases: s1("pa"),
+			Usage: "Value to pass to the payload loader routine, like a SQL query. " +
+				"See README -> Iterator Loaders for more info.",
+		},
+		diffFormatFlag,
+	),
+	Action: func(c *cli.Context) error {
+		ctx, _ := newCtx()
+		sch, err := loadSchema(ctx, c)
+		if err != nil {
+			return err
+		}
+		if len(sch) == 0 {
+			return errors.New("no schema loaded, pass a loader")
+		}
+		payloadIterator, err := moxio.LoadIterator(ctx, c.String("payload-loader"), c.String("payload-loader-arg"))
+		if err != nil {
+			return errors.Wrap(err, "payload loader iterator")
+		}
+		out, err := schemamerge.Check(ctx, schemamerge.CheckInput{Schema: sch, PayloadIterator: payloadIterator})
+		if err != nil {
+			return err
+		}
+		switch c.String("format") {
+		case "", "text":
+			for _, p := range out.Invalid {
+				for _, v := range p.Violations {
+					fmt.Fprintf(c.App.Writer, "payload %d %s: %s (%s)\n", p.Index, v.Path, v.Message, v.Kind)
+				}
+			}
+			fmt.Fprintf(c.App.Writer, "%d of %d payloads invalid", len(out.Invalid), out.Checked)
+			kinds := make([]string, 0, len(out.Summary))
+			for k := range out.Summary {
+				kinds = append(kinds, string(k))
+			}
+			sort.Strings(kinds)
+			for i, k := range kinds {
+				sep := ", "
+				if i == 0 {
+					sep = ": "
+				}
+				fmt.Fprintf(c.App.Writer, "%s%d %s", sep, out.Summary[schemamerge.ViolationKind(k)], k)
+			}
+			fmt.Fprintln(c.App.Writer)
+		case "json":
+			if out.Invalid == nil {
+				out.Invalid = []schemamerge.PayloadCheck{}
+			}
+			if err := moxjson.NewPrettyEncoder(c.App.Writer).Encode(out); err != nil {
+				return err
+			}
+		default:
+			return errors.New("unsupported format")
+		}
+		if n := len(out.Invalid); n > 0 {
+			return errors.Errorf("found %d invalid payloads", n)
+		}
+		return nil
+	},
+}
@@ -22,19 +22,32 @@ var voxCmd = &cli.Command{
 			Name:  "print",
 			Usage: "If given, dump requests and responses to stdout.",
 		},
+		&cli.Float64Flag{
+			Name:  "rate",
+			Usage: "Messages to send per second on each connection, for bindings with long-lived connections like 'ws'. If not given, send as fast as possible.",
+		},
+		&cli.BoolFlag{
+			Name:  "replay-examples",
+			Usage: "If given, replay the examples recorded in each message (see specgen --examples) instead of generating payloads.",
+		},
 		countFlag,
 		bindingFlag,
 	),
 	Action: func(c *cli.Context) error {
 		ctx, _ := newCtx()
-		apispec, err := loadMap(ctx, c)
+		apispec, _, err := loadSpec(ctx, c)
 		if err != nil {
 			return err
 		}
 		var voxer moxvox.Vox
-		if c.String("binding") == "http" {
+		switch c.String("binding") {
+		case "http":
 			voxer = moxvox.HttpVox
-		} else {
+		case "mqtt":
+			voxer = moxvox.MqttVox
+		case "ws":
+			voxer = moxvox.WsVox
+		default:
 			return errors.New("unsupported binding")
 		}
 		matcher, err := regexp.Compile(c.String("match"))
@@ -50,6 +63,8 @@ var voxCmd = &cli.Command{
 			Count:          c.Int("count"),
 			ChannelMatcher: matcher,
 			Printer:        printer,
+			Rate:           c.Float64("rate"),
+			ReplayExamples: c.Bool("replay-examples"),
 		})
 	},
 }
@@ -2,48 +2,94 @@ package datagen
 
 import (
 	"context"
+	"encoding/json"
 	"github.com/lithictech/moxpopuli/faker"
+	"github.com/lithictech/moxpopuli/internal"
 	. "github.com/lithictech/moxpopuli/jsonformat"
+	"github.com/lithictech/moxpopuli/moxrand"
 	. "github.com/lithictech/moxpopuli/schema"
 	"github.com/lithictech/moxpopuli/timestring"
+	"github.com/pkg/errors"
 	"github.com/rickb777/date/period"
+	"math"
+	"math/rand"
 	"net/url"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// NullMode is how often nullable values are generated as null.
+type NullMode string
+
+const (
+	// N_OBSERVED generates nulls as often as they were seen (see Schema.Nulls).
+	N_OBSERVED NullMode = "observed"
+	// N_MAX generates every nullable value as null.
+	N_MAX NullMode = "max"
+	// N_NONE generates no nulls, except for values only ever seen as null.
+	N_NONE NullMode = "none"
+)
+
+// ParseNullMode returns the null mode named s, or N_OBSERVED if s is empty.
+func ParseNullMode(s string) (NullMode, error) {
+	switch m := NullMode(s); m {
+	case "":
+		return N_OBSERVED, nil
+	case N_OBSERVED, N_MAX, N_NONE:
+		return m, nil
+	}
+	return "", errors.Errorf("invalid null mode '%s'", s)
+}
+
 type GenerateInput struct {
 	// The schema to fixture data for.
 	Schema Schema
 	// The key of the property being fixtured. Generally this is only used for recursive data generation,
 	// so you can leave it blank.
 	Key string
+	// How often to generate nullable values as null. Default to N_OBSERVED.
+	Nulls NullMode
 }
 
 func Generate(ctx context.Context, in GenerateInput) interface{} {
 	sch := in.Schema
+	if generateNull(ctx, in.Nulls, sch) {
+		return nil
+	}
+	if oneOf, ok := sch[P_ONE_OF]; ok {
+		return generateOneOf(ctx, in, CoerceSlice(oneOf))
+	}
 	f := sch.Format()
 	// Remember that 'seen min' and 'seen max' will always be valid for the format,
 	// so we can use int64 and float64 fakes and be sure we're getting int32, etc.
 	if scht, ok := sch.ToInteger(); ok {
 		if f == F_ZERO_ONE {
+			// The stats count the zeros, so we can generate 0 and 1 as often as they were seen.
+			if st := sch.Stats(PX_STATS); st != nil && st.Count > 0 {
+				return sampleInt(ctx, sch, PX_STATS, 0, 1)
+			}
 			return faker.Choice([]interface{}{0, 1})
 		}
-		return faker.Int(*scht.SeenMinimum(), *scht.SeenMaximum())
+		return sampleInt(ctx, sch, PX_STATS, *scht.SeenMinimum(), *scht.SeenMaximum())
 	} else if scht, ok := sch.ToNumber(); ok {
-		return faker.Float64(*scht.SeenMinimum(), *scht.SeenMaximum())
+		min, max := *scht.SeenMinimum(), *scht.SeenMaximum()
+		if st := sch.Stats(PX_STATS); st != nil && st.Count > 0 {
+			return math.Min(math.Max(st.Sample(moxrand.FromContext(ctx)), min), max)
+		}
+		return faker.Float64(min, max)
 	} else if _, ok := sch.ToBoolean(); ok {
-		return faker.Bool()
+		return weightedChoice(sch, []string{"false", "true"}) == "true"
 	} else if scht, ok := sch.ToString(); ok {
 		if len(scht.Enum()) > 0 {
-			return faker.ChoiceString(scht.Enum()...)
+			return weightedChoice(sch, scht.Enum())
 		}
 		switch f {
 		case F_BINARY:
-			return string(faker.Bytes(*scht.SeenMinLength(), *scht.SeenMaxLength()))
+			n := sampleInt(ctx, sch, PX_LENGTH_STATS, *scht.SeenMinLength(), *scht.SeenMaxLength())
+			return string(faker.Bytes(n, n))
 		case F_BYTE:
-			return faker.Base64(faker.Hex(*scht.SeenMinLength(), *scht.SeenMaxLength()))
+			return faker.Base64(faker.Hex(sampleInt(ctx, sch, PX_LENGTH_STATS, *scht.SeenMinLength(), *scht.SeenMaxLength())))
 		case F_EMAIL:
 			return faker.Email()
 		case F_COUNTRY:
@@ -55,12 +101,26 @@ func Generate(ctx context.Context, in GenerateInput) interface{} {
 		case F_IPV6:
 			return faker.IPv6()
 		case F_URI:
-			loc := faker.ChoiceString(scht.SeenUriLocations()...)
-			locUrl, _ := url.Parse(loc)
 			pathUrl := faker.URL()
+			locs := scht.SeenUriLocations()
+			if len(locs) == 0 {
+				// We only saw relative URIs (like '/path').
+				return "/" + strings.TrimPrefix(pathUrl.Path, "/")
+			}
+			loc := faker.ChoiceString(locs...)
+			locUrl, _ := url.Parse(loc)
 			locUrl.Path = pathUrl.Path
 			locUrl.RawQuery = pathUrl.RawQuery
 			return locUrl.String()
+		case F_JSON:
+			if content := scht.ContentSchema(); content != nil {
+				b, err := json.Marshal(Generate(ctx, GenerateInput{Key: in.Key, Schema: content, Nulls: in.Nulls}))
+				if err == nil {
+					return string(b)
+				}
+			}
+			// There is no content schema, or the generated document cannot be encoded.
+			return "{}"
 		case F_UUID4:
 			return faker.UUID4()
 		case F_NUMERICAL:
@@ -81,18 +141,18 @@ func Generate(ctx context.Context, in GenerateInput) interface{} {
 			p, _ := period.NewOf(time.Duration(d))
 			return p.Format()
 		default:
-			return faker.Hex(*scht.SeenMinLength(), *scht.SeenMaxLength())
+			return faker.Hex(sampleInt(ctx, sch, PX_LENGTH_STATS, *scht.SeenMinLength(), *scht.SeenMaxLength()))
 		}
 	} else if scht, ok := sch.ToArray(); ok {
-		arr := make([]interface{}, faker.Int(*scht.SeenMinLength(), *scht.SeenMaxLength()))
+		arr := make([]interface{}, sampleInt(ctx, sch, PX_LENGTH_STATS, *scht.SeenMinLength(), *scht.SeenMaxLength()))
 		for i := range arr {
-			arr[i] = Generate(ctx, GenerateInput{Key: strconv.Itoa(i), Schema: scht.Items()})
+			arr[i] = Generate(ctx, GenerateInput{Key: strconv.Itoa(i), Schema: scht.Items(), Nulls: in.Nulls})
 		}
 		return arr
 	} else if scht, ok := sch.ToObject(); ok {
 		r := make(map[string]interface{}, len(scht.Properties()))
 		for k, v := range scht.Properties() {
-			r[k] = Generate(ctx, GenerateInput{Key: k, Schema: v})
+			r[k] = Generate(ctx, GenerateInput{Key: k, Schema: v, Nulls: in.Nulls})
 		}
 		return r
 	} else if sch == nil || sch.Nullable() {
@@ -102,6 +162,85 @@ func Generate(ctx context.Context, in GenerateInput) interface{} {
 	}
 }
 
+// generateNull returns true if the value should be null,
+// using the random source of ctx (see moxrand.WithSeed).
+func generateNull(ctx context.Context, mode NullMode, sch Schema) bool {
+	if sch.NullOnly() {
+		return true
+	}
+	if !sch.Nullable() {
+		return false
+	}
+	switch mode {
+	case N_MAX:
+		return true
+	case N_NONE:
+		return false
+	}
+	nulls := sch.Nulls()
+	if nulls == 0 {
+		// Learned before nulls were counted, so we don't know how often it is null.
+		return false
+	}
+	// Samples include the nulls, except for a oneOf (see Schema.Nulls).
+	samples := sch.Samples()
+	if oneOf, ok := sch[P_ONE_OF]; ok {
+		samples = nulls
+		for _, s := range CoerceSlice(oneOf) {
+			samples += s.Samples()
+		}
+	}
+	intn := rand.Intn
+	if r := moxrand.FromContext(ctx); r != nil {
+		intn = r.Intn
+	}
+	return intn(internal.MaxInt(samples, nulls)) < nulls
+}
+
+// generateOneOf generates a value from one of the schemas, chosen as often as each was seen.
+func generateOneOf(ctx context.Context, in GenerateInput, oneOf []Schema) interface{} {
+	if len(oneOf) == 0 {
+		return nil
+	}
+	weights := make([]int, len(oneOf))
+	for i, s := range oneOf {
+		weights[i] = s.Samples()
+	}
+	return Generate(ctx, GenerateInput{Key: in.Key, Schema: oneOf[faker.WeightedIndex(weights)], Nulls: in.Nulls})
+}
+
+// weightedChoice returns one of values, as often as it was seen (see Schema.ValueCounts).
+// Values without counts (like those learned before counts were kept) are weighted as if seen once.
+func weightedChoice(sch Schema, values []string) string {
+	counts := sch.ValueCounts()
+	weights := make([]int, len(values))
+	for i, v := range values {
+		if n, ok := counts[v]; ok {
+			weights[i] = n
+		} else {
+			weights[i] = 1
+		}
+	}
+	return faker.WeightedChoiceString(values, weights)
+}
+
+// sampleInt returns an integer from the statistics of sch in f (see numstat.Stats.Sample),
+// like a value (PX_STATS) or length (PX_LENGTH_STATS) in the same proportions as seen,
+// or between min and max if there are none.
+// Samples use the random source of ctx (see moxrand.WithSeed).
+func sampleInt(ctx context.Context, sch Schema, f Field, min, max int) int {
+	if st := sch.Stats(f); st != nil && st.Count > 0 {
+		v := int(math.Round(st.Sample(moxrand.FromContext(ctx))))
+		if v < min {
+			return min
+		} else if v > max {
+			return max
+		}
+		return v
+	}
+	return faker.Int(min, max)
+}
+
 func timeFaker(key string, sch StringSchema, layout string) string {
 	tmin, tmax := timestring.From(layout, *sch.SeenMinimum()), timestring.From(layout, *sch.SeenMaximum())
 	// 'updated at' should be possible to create going forward,
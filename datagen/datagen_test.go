@@ -2,12 +2,21 @@ package datagen_test
 
 import (
 	"context"
+	"encoding/json"
 	"github.com/lithictech/moxpopuli/datagen"
 	"github.com/lithictech/moxpopuli/fixturegen"
+	"github.com/lithictech/moxpopuli/jsonformat"
+	"github.com/lithictech/moxpopuli/jsontype"
+	"github.com/lithictech/moxpopuli/moxio"
+	"github.com/lithictech/moxpopuli/moxrand"
 	"github.com/lithictech/moxpopuli/schema"
+	"github.com/lithictech/moxpopuli/schemamerge"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"math"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestDatagen(t *testing.T) {
@@ -23,4 +32,161 @@ var _ = Describe("datagen", func() {
 		out := datagen.Generate(ctx, datagen.GenerateInput{Schema: sch})
 		Expect(out).To(HaveKeyWithValue("float", BeAssignableToTypeOf(float64(1))))
 	})
+
+	It("generates values and lengths like the ones seen", func() {
+		var payloads []interface{}
+		for i := 0; i < 100; i++ {
+			payloads = append(payloads, map[string]interface{}{"amount": 1000 + i, "note": "abcdef"})
+		}
+		payloads = append(payloads, map[string]interface{}{"amount": 1_000_000, "note": strings.Repeat("note ", 100)})
+		out, err := schemamerge.MergeMany(ctx, schemamerge.MergeManyInput{PayloadIterator: moxio.NewMemoryIterator(payloads)})
+		Expect(err).ToNot(HaveOccurred())
+		usual := 0
+		for i := 0; i < 100; i++ {
+			gen := datagen.Generate(ctx, datagen.GenerateInput{Schema: out.Schema}).(map[string]interface{})
+			Expect(gen["amount"]).To(BeNumerically(">=", 1000))
+			if gen["amount"].(int) < 2000 && len(gen["note"].(string)) < 10 {
+				usual++
+			}
+		}
+		Expect(usual).To(BeNumerically(">", 90))
+	})
+
+	It("generates enums, booleans, and zero-ones as often as they were seen", func() {
+		var payloads []interface{}
+		for i := 0; i < 100; i++ {
+			payloads = append(payloads, map[string]interface{}{"status": "succeeded", "live": true, "retried": 0})
+		}
+		payloads = append(payloads, map[string]interface{}{"status": "failed", "live": false, "retried": 1})
+		out, err := schemamerge.MergeMany(ctx, schemamerge.MergeManyInput{PayloadIterator: moxio.NewMemoryIterator(payloads)})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(out.Schema.MustObject().Properties()["status"]).To(And(
+			HaveKeyWithValue(schema.P_ENUM, []string{"failed", "succeeded"}),
+			HaveKeyWithValue(schema.PX_VALUE_COUNTS, map[string]int{"failed": 1, "succeeded": 100}),
+		))
+		unusual := map[string]int{}
+		for i := 0; i < 200; i++ {
+			gen := datagen.Generate(ctx, datagen.GenerateInput{Schema: out.Schema}).(map[string]interface{})
+			if gen["status"] == "failed" {
+				unusual["status"]++
+			}
+			if gen["live"] == false {
+				unusual["live"]++
+			}
+			if gen["retried"] == 1 {
+				unusual["retried"]++
+			}
+		}
+		Expect(unusual["status"]).To(BeNumerically("<", 15))
+		Expect(unusual["live"]).To(BeNumerically("<", 15))
+		Expect(unusual["retried"]).To(BeNumerically("<", 15))
+	})
+
+	It("generates values like the ones seen since the schema was pruned", func() {
+		day := func(d int) time.Time { return time.Date(2022, 1, d, 12, 0, 0, 0, time.UTC) }
+		var payloads []interface{}
+		for i := 0; i < 50; i++ {
+			payloads = append(payloads,
+				map[string]interface{}{"at": day(1).Format(time.RFC3339), "amount": 1000 + i, "note": nil},
+				map[string]interface{}{"at": day(10).Format(time.RFC3339), "amount": 5 + i%3, "note": "abc"},
+			)
+		}
+		out, err := schemamerge.MergeMany(ctx, schemamerge.MergeManyInput{
+			PayloadIterator: moxio.NewMemoryIterator(payloads),
+			Provenance:      &schemamerge.ProvenanceInput{TimeField: "at"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		pruned := schemamerge.Prune(schemamerge.PruneInput{Schema: out.Schema, Since: day(5)}).Schema
+		props := pruned.MustObject().Properties()
+		Expect(props["amount"]).To(HaveKeyWithValue(schema.PX_SEEN_MAXIMUM, 7))
+		Expect(props["amount"]).To(HaveKeyWithValue(schema.PX_SAMPLES, 50))
+		Expect(props["amount"].Stats(schema.PX_STATS).Mean).To(BeNumerically("~", 6, 0.1))
+		Expect(props["note"]).ToNot(HaveKey(schema.PX_NULLS))
+		counts := map[int]int{}
+		for i := 0; i < 300; i++ {
+			gen := datagen.Generate(ctx, datagen.GenerateInput{Schema: pruned}).(map[string]interface{})
+			Expect(gen["note"]).ToNot(BeNil())
+			counts[gen["amount"].(int)]++
+		}
+		// Values are not clamped to the pruned range from the old statistics, so they don't pile up at its maximum.
+		Expect(counts).To(HaveLen(3))
+		Expect(counts[7]).To(BeNumerically("<", 150))
+	})
+
+	It("generates JSON documents encoded in strings", func() {
+		out, err := schemamerge.MergeMany(ctx, schemamerge.MergeManyInput{PayloadIterator: moxio.NewMemoryIterator([]interface{}{
+			map[string]interface{}{"metadata": `{"order_id": 5, "tags": ["a"]}`},
+			map[string]interface{}{"metadata": `{"order_id": 7, "tags": []}`},
+		})})
+		Expect(err).ToNot(HaveOccurred())
+		gen := datagen.Generate(ctx, datagen.GenerateInput{Schema: out.Schema}).(map[string]interface{})
+		Expect(gen["metadata"]).To(BeAssignableToTypeOf(""))
+		var doc map[string]interface{}
+		Expect(json.Unmarshal([]byte(gen["metadata"].(string)), &doc)).To(Succeed())
+		Expect(doc).To(HaveKeyWithValue("order_id", BeNumerically(">=", 5)))
+		Expect(doc).To(HaveKeyWithValue("tags", BeAssignableToTypeOf([]interface{}{})))
+	})
+
+	It("generates an empty document if the generated one cannot be encoded", func() {
+		sch := schema.Schema{
+			schema.P_TYPE:   jsontype.T_STRING,
+			schema.P_FORMAT: jsonformat.F_JSON,
+			schema.P_CONTENT_SCHEMA: schema.Schema{
+				schema.P_TYPE:          jsontype.T_NUMBER,
+				schema.PX_SEEN_MINIMUM: math.Inf(1),
+				schema.PX_SEEN_MAXIMUM: math.Inf(1),
+			},
+		}
+		Expect(datagen.Generate(ctx, datagen.GenerateInput{Schema: sch})).To(Equal("{}"))
+	})
+
+	It("generates relative URIs if only relative URIs were seen", func() {
+		sch := schema.Derive("", map[string]interface{}{"source": "/sensors/1"})
+		gen := datagen.Generate(ctx, datagen.GenerateInput{Schema: sch}).(map[string]interface{})
+		Expect(gen["source"]).To(HavePrefix("/"))
+
+		sch = schema.Derive("", map[string]interface{}{"source": "https://example.com/sensors/1"})
+		gen = datagen.Generate(ctx, datagen.GenerateInput{Schema: sch}).(map[string]interface{})
+		Expect(gen["source"]).To(HavePrefix("https://example.com/"))
+	})
+
+	It("generates nulls as often as they were seen, or always or never", func() {
+		var payloads []interface{}
+		for i := 0; i < 100; i++ {
+			note := []interface{}{"abc", nil}[i%2]
+			mixed := []interface{}{i, "xyz", nil, nil}[i%4]
+			payloads = append(payloads, map[string]interface{}{"note": note, "mixed": mixed, "id": i})
+		}
+		out, err := schemamerge.MergeMany(ctx, schemamerge.MergeManyInput{PayloadIterator: moxio.NewMemoryIterator(payloads)})
+		Expect(err).ToNot(HaveOccurred())
+		generate := func(mode datagen.NullMode) map[string]int {
+			nulls := map[string]int{}
+			for i := 0; i < 400; i++ {
+				gen := datagen.Generate(ctx, datagen.GenerateInput{Schema: out.Schema, Nulls: mode}).(map[string]interface{})
+				for k, v := range gen {
+					if v == nil {
+						nulls[k]++
+					}
+				}
+			}
+			return nulls
+		}
+		observed := generate(datagen.N_OBSERVED)
+		Expect(observed["note"]).To(BeNumerically("~", 200, 50))
+		Expect(observed["mixed"]).To(BeNumerically("~", 200, 50))
+		Expect(observed["id"]).To(BeZero())
+		Expect(generate(datagen.N_MAX)).To(Equal(map[string]int{"note": 400, "mixed": 400}))
+		Expect(generate(datagen.N_NONE)).To(BeEmpty())
+
+		nullPositions := func(ctx context.Context) []bool {
+			r := make([]bool, 50)
+			for i := range r {
+				r[i] = datagen.Generate(ctx, datagen.GenerateInput{Schema: out.Schema.MustObject().Properties()["note"]}) == nil
+			}
+			return r
+		}
+		Expect(nullPositions(moxrand.WithSeed(ctx, 7))).To(Equal(nullPositions(moxrand.WithSeed(ctx, 7))))
+		_, err = datagen.ParseNullMode("sometimes")
+		Expect(err).To(HaveOccurred())
+	})
 })
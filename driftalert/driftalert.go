@@ -0,0 +1,309 @@
+// Package driftalert notifies a webhook (or saver) when a scheduled schemagen or specgen run
+// changes the schema or spec it learns, like in a way that breaks consumers.
+//
+// The notification is a JSON Notification. It is documented in the README (see Drift Alerts),
+// so adapters (like to post to Slack or PagerDuty) can be built on it.
+package driftalert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/lithictech/moxpopuli/moxio"
+	"github.com/lithictech/moxpopuli/schema"
+	"github.com/lithictech/moxpopuli/schemadiff"
+	"github.com/lithictech/moxpopuli/schemamerge"
+	"github.com/lithictech/moxpopuli/specdiff"
+	"github.com/pkg/errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type Change struct {
+	// For spec changes, where the change is in the spec (see specdiff.Change).
+	Location string `json:"location,omitempty"`
+	// For schema changes, the JSON path of the changed value (see schemadiff.Change).
+	Path     string              `json:"path,omitempty"`
+	Kind     schemadiff.Kind     `json:"kind"`
+	Severity schemadiff.Severity `json:"severity"`
+	Message  string              `json:"message"`
+	// The (redacted) payload or message example that caused the change, if known.
+	Example interface{} `json:"example,omitempty"`
+}
+
+type Notification struct {
+	// The command that learned the change, like 'schemagen' or 'specgen'.
+	Source string `json:"source"`
+	// Name of the job, from Config.Name, to tell notifications from different jobs apart.
+	Name string `json:"name,omitempty"`
+	// When the notification was sent.
+	SentAt time.Time `json:"sent_at"`
+	// True if any change is breaking.
+	Breaking bool `json:"breaking"`
+	// Summary like '2 breaking, 1 non-breaking, 0 informational changes', for use as a title.
+	Summary string `json:"summary"`
+	// Changes at or above Config.MinSeverity, including those from notifications that were suppressed.
+	Changes []Change `json:"changes"`
+	// Number of notifications not sent since the last one because of Config.Interval.
+	// Their changes are included in Changes.
+	Suppressed int `json:"suppressed"`
+}
+
+type Config struct {
+	// URL to POST the notification to as JSON.
+	WebhookURL string
+	// Saver to write the notification to, like 'file://./alert.json'. Use '-' for stdout.
+	Saver string
+	// Only notify about changes of at least this severity. Default to schemadiff.S_BREAKING.
+	MinSeverity schemadiff.Severity
+	// See Notification.Name.
+	Name string
+	// If set, send at most one notification per interval. Requires State.
+	Interval time.Duration
+	// Loader and saver URI (like 'file://./alertstate.json') to remember when the last notification was sent,
+	// and the changes of suppressed notifications, since each schemagen or specgen run is a new process.
+	State string
+	// Defaults to http.DefaultClient.
+	Client *http.Client
+	// Defaults to time.Now.
+	Now func() time.Time
+}
+
+// Enabled returns true if the config has somewhere to send notifications.
+func (c Config) Enabled() bool {
+	return c.WebhookURL != "" || c.Saver != ""
+}
+
+// Validate returns an error if the config is invalid.
+func (c Config) Validate() error {
+	if _, ok := severityRanks[c.MinSeverity]; !ok && c.MinSeverity != "" {
+		return errors.Errorf("invalid severity '%s'", c.MinSeverity)
+	}
+	if c.Interval > 0 && c.State == "" {
+		return errors.New("an interval requires a state loader to remember when the last notification was sent")
+	}
+	return nil
+}
+
+var severityRanks = map[schemadiff.Severity]int{
+	schemadiff.S_INFO:         0,
+	schemadiff.S_NON_BREAKING: 1,
+	schemadiff.S_BREAKING:     2,
+}
+
+type state struct {
+	LastSentAt time.Time `json:"last_sent_at"`
+	Suppressed int       `json:"suppressed"`
+	// Changes of suppressed notifications, to send with the next notification.
+	// The schema or spec is saved with the changes, so they will not be found again.
+	Pending []Change `json:"pending,omitempty"`
+}
+
+// Alert sends a notification for the changes at or above cfg.MinSeverity, if any,
+// unless one was already sent within cfg.Interval.
+// The changes of a suppressed notification are sent with the next notification,
+// which is sent by the first call after cfg.Interval, even if it has no new changes.
+// It returns the notification, or nil if nothing was sent.
+func Alert(ctx context.Context, cfg Config, source string, changes []Change) (*Notification, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	minSeverity := cfg.MinSeverity
+	if minSeverity == "" {
+		minSeverity = schemadiff.S_BREAKING
+	}
+	var severe []Change
+	for _, ch := range changes {
+		if severityRanks[ch.Severity] >= severityRanks[minSeverity] {
+			severe = append(severe, ch)
+		}
+	}
+	now := time.Now
+	if cfg.Now != nil {
+		now = cfg.Now
+	}
+	n := &Notification{Source: source, Name: cfg.Name, SentAt: now().UTC()}
+
+	var st state
+	if cfg.State != "" {
+		m, err := moxio.LoadOneMap(ctx, cfg.State, "")
+		if err != nil {
+			return nil, errors.Wrap(err, "loading alert state")
+		}
+		b, _ := json.Marshal(m)
+		if err := json.Unmarshal(b, &st); err != nil {
+			return nil, errors.Wrap(err, "parsing alert state")
+		}
+	}
+	if len(severe) == 0 && len(st.Pending) == 0 {
+		return nil, nil
+	}
+	if cfg.State != "" && cfg.Interval > 0 && n.SentAt.Sub(st.LastSentAt) < cfg.Interval {
+		if len(severe) == 0 {
+			return nil, nil
+		}
+		st.Suppressed++
+		st.Pending = append(st.Pending, severe...)
+		return nil, errors.Wrap(moxio.Save(ctx, cfg.State, "", st), "saving alert state")
+	}
+	n.Suppressed = st.Suppressed
+	n.Changes = append(append([]Change{}, st.Pending...), severe...)
+	counts := map[schemadiff.Severity]int{}
+	for _, ch := range n.Changes {
+		counts[ch.Severity]++
+	}
+	n.Breaking = counts[schemadiff.S_BREAKING] > 0
+	n.Summary = fmt.Sprintf("%d breaking, %d non-breaking, %d informational changes",
+		counts[schemadiff.S_BREAKING], counts[schemadiff.S_NON_BREAKING], counts[schemadiff.S_INFO])
+	if cfg.WebhookURL != "" {
+		if err := post(ctx, cfg, n); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Saver != "" {
+		if err := moxio.Save(ctx, cfg.Saver, "", n); err != nil {
+			return nil, errors.Wrap(err, "saving alert")
+		}
+	}
+	if cfg.State != "" {
+		if err := moxio.Save(ctx, cfg.State, "", state{LastSentAt: n.SentAt}); err != nil {
+			return nil, errors.Wrap(err, "saving alert state")
+		}
+	}
+	return n, nil
+}
+
+func post(ctx context.Context, cfg Config, n *Notification) error {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", cfg.WebhookURL, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "creating alert request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := cfg.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "posting alert")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("posting alert: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// FromSchemaChanges returns the alert changes for schemadiff changes,
+// with the example from triggers (which can be nil) for each change.
+func FromSchemaChanges(changes schemadiff.Changes, triggers *Triggers) []Change {
+	r := make([]Change, len(changes))
+	for i, ch := range changes {
+		r[i] = Change{Path: ch.Path, Kind: ch.Kind, Severity: ch.Severity, Message: ch.Message}
+		if triggers != nil {
+			r[i].Example = triggers.Example(ch.Path, ch.Kind)
+		}
+	}
+	return r
+}
+
+// FromSpecChanges returns the alert changes for specdiff changes.
+func FromSpecChanges(changes specdiff.Changes) []Change {
+	r := make([]Change, len(changes))
+	for i, ch := range changes {
+		r[i] = Change{
+			Location: ch.Location,
+			Path:     ch.Path,
+			Kind:     ch.Kind,
+			Severity: ch.Severity,
+			Message:  ch.Message,
+			Example:  ch.Example,
+		}
+	}
+	return r
+}
+
+// Triggers wraps a payload iterator, and records the first payload that did not fit the old schema
+// in each way at each path (see schemamerge.CheckOne). Merging that payload is what changed the schema.
+// Payloads are redacted, and only one is kept for each path and kind of violation.
+type Triggers struct {
+	old    schema.Schema
+	it     moxio.Iterator
+	byPath map[trigger]interface{}
+	order  []trigger
+}
+
+type trigger struct {
+	path string
+	kind schemamerge.ViolationKind
+}
+
+func NewTriggers(old schema.Schema, it moxio.Iterator) *Triggers {
+	return &Triggers{old: old, it: it, byPath: make(map[trigger]interface{})}
+}
+
+func (t *Triggers) Next() bool {
+	return t.it.Next()
+}
+
+func (t *Triggers) Read(ctx context.Context) (interface{}, error) {
+	payload, err := t.it.Read(ctx)
+	if err != nil {
+		return payload, err
+	}
+	for _, v := range schemamerge.CheckOne(t.old, payload) {
+		// schemadiff uses '[]' for all items of an array.
+		tr := trigger{path: itemIndex.ReplaceAllString(v.Path, "[]"), kind: v.Kind}
+		if _, ok := t.byPath[tr]; !ok {
+			t.byPath[tr] = schema.Redact("", payload)
+			t.order = append(t.order, tr)
+		}
+	}
+	return payload, nil
+}
+
+func (t *Triggers) Close() error {
+	return t.it.Close()
+}
+
+var itemIndex = regexp.MustCompile(`\[\d+\]`)
+
+// The kind of violation that causes each kind of schema change.
+var causes = map[schemadiff.Kind]schemamerge.ViolationKind{
+	schemadiff.K_PROPERTY_ADDED:      schemamerge.V_UNKNOWN_PROPERTY,
+	schemadiff.K_TYPE_CHANGED:        schemamerge.V_TYPE_MISMATCH,
+	schemadiff.K_ONE_OF_ADDED:        schemamerge.V_TYPE_MISMATCH,
+	schemadiff.K_FORMAT_CHANGED:      schemamerge.V_FORMAT_MISMATCH,
+	schemadiff.K_NULLABLE_ADDED:      schemamerge.V_UNEXPECTED_NULL,
+	schemadiff.K_ENUM_VALUES_ADDED:   schemamerge.V_ENUM_MISS,
+	schemadiff.K_ENUM_REMOVED:        schemamerge.V_ENUM_MISS,
+	schemadiff.K_RANGE_EXPANDED:      schemamerge.V_OUT_OF_RANGE,
+	schemadiff.K_SEEN_RANGE_EXPANDED: schemamerge.V_OUT_OF_RANGE,
+}
+
+// Example returns the payload that caused a schemadiff change of the kind at the path.
+// If there is none, it returns the first payload that did not fit the schema at the path,
+// or under it, or nil.
+func (t *Triggers) Example(path string, kind schemadiff.Kind) interface{} {
+	if p, ok := t.byPath[trigger{path: path, kind: causes[kind]}]; ok {
+		return p
+	}
+	for _, tr := range t.order {
+		if tr.path == path {
+			return t.byPath[tr]
+		}
+	}
+	for _, tr := range t.order {
+		if strings.HasPrefix(tr.path, path+".") || strings.HasPrefix(tr.path, path+"[") {
+			return t.byPath[tr]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package driftalert_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/lithictech/moxpopuli/driftalert"
+	"github.com/lithictech/moxpopuli/moxio"
+	"github.com/lithictech/moxpopuli/schema"
+	"github.com/lithictech/moxpopuli/schemadiff"
+	"github.com/lithictech/moxpopuli/schemamerge"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDriftalert(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "driftalert Suite")
+}
+
+var _ = Describe("driftalert", func() {
+	ctx := context.Background()
+	var bodies []map[string]interface{}
+	var server *httptest.Server
+	var status int
+	BeforeEach(func() {
+		bodies = nil
+		status = 200
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body map[string]interface{}
+			Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+			Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+			bodies = append(bodies, body)
+			w.WriteHeader(status)
+		}))
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+
+	learn := func(sch schema.Schema, payloads ...interface{}) (schema.Schema, *driftalert.Triggers) {
+		old := sch.DeepClone()
+		triggers := driftalert.NewTriggers(old, moxio.NewMemoryIterator(payloads))
+		out, err := schemamerge.MergeMany(ctx, schemamerge.MergeManyInput{Schema: sch, PayloadIterator: triggers})
+		Expect(err).ToNot(HaveOccurred())
+		return out.Schema, triggers
+	}
+
+	It("posts the changes and the payloads that caused them", func() {
+		old, _ := learn(schema.Schema{}, map[string]interface{}{"id": 1, "items": []interface{}{map[string]interface{}{"x": 1}}})
+		new, triggers := learn(old.DeepClone(),
+			map[string]interface{}{"id": 2, "items": []interface{}{map[string]interface{}{"x": nil}}},
+			map[string]interface{}{"id": "x3"},
+		)
+		changes := driftalert.FromSchemaChanges(schemadiff.Diff(old, new), triggers)
+		n, err := driftalert.Alert(ctx, driftalert.Config{WebhookURL: server.URL, Name: "nightly"}, "schemagen", changes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).ToNot(BeNil())
+		Expect(bodies).To(HaveLen(1))
+		Expect(bodies[0]).To(And(
+			HaveKeyWithValue("source", "schemagen"),
+			HaveKeyWithValue("name", "nightly"),
+			HaveKeyWithValue("breaking", true),
+			HaveKeyWithValue("summary", "2 breaking, 0 non-breaking, 0 informational changes"),
+			HaveKeyWithValue("suppressed", BeEquivalentTo(0)),
+		))
+		Expect(bodies[0]["changes"]).To(ConsistOf(
+			And(
+				HaveKeyWithValue("path", "$.id"),
+				HaveKeyWithValue("kind", "one_of_added"),
+				HaveKeyWithValue("example", HaveKeyWithValue("id", "x3")),
+			),
+			And(
+				HaveKeyWithValue("path", "$.items[].x"),
+				HaveKeyWithValue("kind", "nullable_added"),
+				HaveKeyWithValue("example", HaveKeyWithValue("id", BeEquivalentTo(2))),
+			),
+		))
+	})
+
+	It("does nothing if no changes are severe enough", func() {
+		changes := []driftalert.Change{{Path: "$.x", Kind: schemadiff.K_PROPERTY_ADDED, Severity: schemadiff.S_NON_BREAKING}}
+		n, err := driftalert.Alert(ctx, driftalert.Config{WebhookURL: server.URL}, "schemagen", changes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(BeNil())
+		Expect(bodies).To(BeEmpty())
+
+		n, err = driftalert.Alert(ctx, driftalert.Config{WebhookURL: server.URL, MinSeverity: schemadiff.S_NON_BREAKING}, "schemagen", changes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n.Breaking).To(BeFalse())
+		Expect(bodies).To(HaveLen(1))
+	})
+
+	It("sends at most one notification per interval", func() {
+		dir, err := os.MkdirTemp("", "driftalert")
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(os.RemoveAll, dir)
+		now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+		cfg := driftalert.Config{
+			WebhookURL: server.URL,
+			Interval:   time.Hour,
+			State:      "file://" + filepath.Join(dir, "state.json"),
+			Now:        func() time.Time { return now },
+		}
+		alert := func(paths ...string) *driftalert.Notification {
+			var changes []driftalert.Change
+			for _, p := range paths {
+				changes = append(changes, driftalert.Change{Path: p, Kind: schemadiff.K_TYPE_CHANGED, Severity: schemadiff.S_BREAKING})
+			}
+			n, err := driftalert.Alert(ctx, cfg, "schemagen", changes)
+			Expect(err).ToNot(HaveOccurred())
+			return n
+		}
+		paths := func(n *driftalert.Notification) []string {
+			var r []string
+			for _, ch := range n.Changes {
+				r = append(r, ch.Path)
+			}
+			return r
+		}
+		Expect(alert("$.x")).ToNot(BeNil())
+		now = now.Add(30 * time.Minute)
+		Expect(alert("$.y")).To(BeNil())
+		Expect(alert("$.z")).To(BeNil())
+		Expect(alert()).To(BeNil())
+		now = now.Add(time.Hour)
+		n := alert("$.w")
+		Expect(n).To(HaveField("Suppressed", 2))
+		Expect(n).To(HaveField("Summary", "3 breaking, 0 non-breaking, 0 informational changes"))
+		Expect(paths(n)).To(Equal([]string{"$.y", "$.z", "$.w"}))
+		Expect(bodies).To(HaveLen(2))
+
+		// Suppressed changes are sent once the interval passes, even if nothing else changed.
+		now = now.Add(10 * time.Minute)
+		Expect(alert("$.v")).To(BeNil())
+		now = now.Add(time.Hour)
+		n = alert()
+		Expect(paths(n)).To(Equal([]string{"$.v"}))
+		Expect(n).To(HaveField("Suppressed", 1))
+		Expect(alert()).To(BeNil())
+		Expect(bodies).To(HaveLen(3))
+	})
+
+	It("errors if the webhook fails", func() {
+		status = 500
+		changes := []driftalert.Change{{Path: "$.x", Kind: schemadiff.K_TYPE_CHANGED, Severity: schemadiff.S_BREAKING}}
+		_, err := driftalert.Alert(ctx, driftalert.Config{WebhookURL: server.URL}, "schemagen", changes)
+		Expect(err).To(MatchError(ContainSubstring("status 500")))
+	})
+
+	It("requires a state to rate limit", func() {
+		Expect(driftalert.Config{Interval: time.Hour}.Validate()).To(HaveOccurred())
+		Expect(driftalert.Config{MinSeverity: "bad"}.Validate()).To(HaveOccurred())
+	})
+})
@@ -117,6 +117,37 @@ func ChoiceString(items ...string) string {
 	return items[rand.Intn(len(items))]
 }
 
+// WeightedChoiceString returns one of items, chosen in proportion to its weight.
+// If no weight is positive, items are chosen uniformly.
+func WeightedChoiceString(items []string, weights []int) string {
+	return items[WeightedIndex(weights)]
+}
+
+// WeightedIndex returns the index of one of weights, chosen in proportion to its weight.
+// If no weight is positive, indices are chosen uniformly.
+func WeightedIndex(weights []int) int {
+	total := 0
+	for _, w := range weights {
+		if w > 0 {
+			total += w
+		}
+	}
+	if total == 0 {
+		return rand.Intn(len(weights))
+	}
+	n := rand.Intn(total)
+	for i, w := range weights {
+		if w <= 0 {
+			continue
+		}
+		if n < w {
+			return i
+		}
+		n -= w
+	}
+	return len(weights) - 1
+}
+
 func Base64(s string) string {
 	return base64.StdEncoding.EncodeToString([]byte(s))
 }
@@ -46,6 +46,7 @@ func Generate(in GenerateInput) map[string]interface{} {
 		F_CURRENCY:      faker.Currency(),
 		F_IPV4:          faker.IPv4(),
 		F_IPV6:          faker.IPv6(),
+		F_JSON:          fixtureJson(),
 		F_URI:           faker.URL().String(),
 		F_UUID4:         faker.UUID4(),
 		F_NUMERICAL:     strconv.Itoa(faker.Int()),
@@ -87,3 +88,17 @@ func Generate(in GenerateInput) map[string]interface{} {
 	}
 	return result
 }
+
+// fixtureJson returns a JSON document encoded into a string,
+// like the 'Message' of an SNS notification.
+func fixtureJson() string {
+	b, err := json.Marshal(map[string]interface{}{
+		"order_id": faker.Int(),
+		"email":    faker.Email(),
+		"items":    []interface{}{faker.UUID4()},
+	})
+	if err != nil {
+		panic("should never have errored marshaling: " + err.Error())
+	}
+	return string(b)
+}
@@ -5,12 +5,17 @@ import (
 )
 
 // SampleOut returns a new slice, removing random elements from in until
-// it is at most length n.
-func SampleOut(in []interface{}, n int) []interface{} {
+// it is at most length n. Elements are chosen using r,
+// or the math/rand global functions if r is nil.
+func SampleOut(r *rand.Rand, in []interface{}, n int) []interface{} {
+	intn := rand.Intn
+	if r != nil {
+		intn = r.Intn
+	}
 	result := make([]interface{}, len(in))
 	copy(result, in)
 	for len(result) > n {
-		idx := rand.Intn(len(result))
+		idx := intn(len(result))
 		result = append(result[:idx], result[idx+1:]...)
 	}
 	return result
@@ -0,0 +1,96 @@
+// Package hll is a small HyperLogLog sketch, for estimating how many distinct strings were seen
+// in a constant amount of memory.
+//
+// Sketches are stored in schemas, so they are kept small (256 one-byte registers, for a standard error of about 6.5%),
+// and use a deterministic hash so they can be merged across runs.
+// Small cardinalities (below a few hundred) are estimated with linear counting, which is nearly exact.
+package hll
+
+import (
+	"encoding/base64"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+const (
+	precision = 8
+	registers = 1 << precision
+)
+
+// Sketch is the registers of a HyperLogLog sketch.
+// Use String and Parse to store it (like in a schema) as base64.
+type Sketch []byte
+
+func New() Sketch {
+	return make(Sketch, registers)
+}
+
+// Parse returns the sketch encoded by Sketch.String, or false if s is not a valid sketch.
+func Parse(s string) (Sketch, bool) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(b) != registers {
+		return nil, false
+	}
+	return Sketch(b), true
+}
+
+func (sk Sketch) String() string {
+	return base64.StdEncoding.EncodeToString(sk)
+}
+
+// Add records that s was seen.
+func (sk Sketch) Add(s string) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	x := mix(h.Sum64())
+	idx := x >> (64 - precision)
+	rho := uint8(bits.LeadingZeros64(x<<precision|1<<(precision-1)) + 1)
+	if rho > sk[idx] {
+		sk[idx] = rho
+	}
+}
+
+// Merge records everything seen by other in sk.
+func (sk Sketch) Merge(other Sketch) {
+	for i, r := range other {
+		if r > sk[i] {
+			sk[i] = r
+		}
+	}
+}
+
+func (sk Sketch) Clone() Sketch {
+	c := make(Sketch, len(sk))
+	copy(c, sk)
+	return c
+}
+
+// Estimate returns the estimated number of distinct strings seen.
+func (sk Sketch) Estimate() int {
+	sum := 0.0
+	zeros := 0
+	for _, r := range sk {
+		sum += math.Pow(2, -float64(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	m := float64(registers)
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int(math.Round(estimate))
+}
+
+// mix is the murmur3 finalizer, since FNV does not spread short strings over the high bits well.
+func mix(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb3f97a63fe53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,46 @@
+package hll_test
+
+import (
+	"fmt"
+	"github.com/lithictech/moxpopuli/hll"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestHll(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "hll Suite")
+}
+
+var _ = Describe("hll", func() {
+	add := func(sk hll.Sketch, from, to int) hll.Sketch {
+		for i := from; i < to; i++ {
+			sk.Add(fmt.Sprintf("value%d", i))
+		}
+		return sk
+	}
+
+	It("estimates the number of distinct values", func() {
+		Expect(hll.New().Estimate()).To(Equal(0))
+		Expect(add(hll.New(), 0, 50).Estimate()).To(BeNumerically("~", 50, 5))
+		sk := add(hll.New(), 0, 10000)
+		Expect(add(sk, 0, 10000).Estimate()).To(BeNumerically("~", 10000, 1500))
+	})
+
+	It("merges sketches", func() {
+		sk := add(hll.New(), 0, 600)
+		sk.Merge(add(hll.New(), 400, 1000))
+		Expect(sk).To(Equal(add(hll.New(), 0, 1000)))
+		Expect(sk.Estimate()).To(BeNumerically("~", 1000, 150))
+	})
+
+	It("can be stored as a string", func() {
+		sk := add(hll.New(), 0, 100)
+		parsed, ok := hll.Parse(sk.String())
+		Expect(ok).To(BeTrue())
+		Expect(parsed).To(Equal(sk))
+		_, ok = hll.Parse("abc")
+		Expect(ok).To(BeFalse())
+	})
+})
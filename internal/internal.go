@@ -112,6 +112,21 @@ func UniqueSortedStrings(x []string) []string {
 	return uniq
 }
 
+// SubtractStrings returns the items of x that are not in y.
+func SubtractStrings(x, y []string) []string {
+	remove := make(map[string]struct{}, len(y))
+	for _, i := range y {
+		remove[i] = struct{}{}
+	}
+	var r []string
+	for _, i := range x {
+		if _, ok := remove[i]; !ok {
+			r = append(r, i)
+		}
+	}
+	return r
+}
+
 func CompactStrings(x ...*string) []string {
 	r := make([]string, 0, len(x))
 	for _, s := range x {
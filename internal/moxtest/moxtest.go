@@ -0,0 +1,31 @@
+// Package moxtest has helpers shared by the tests of several packages.
+package moxtest
+
+import (
+	"context"
+	"github.com/lithictech/moxpopuli/asyncapispec"
+	"github.com/lithictech/moxpopuli/asyncapispecmerge"
+	"github.com/lithictech/moxpopuli/moxio"
+	"github.com/lithictech/moxpopuli/schema"
+	"github.com/lithictech/moxpopuli/schemamerge"
+	. "github.com/onsi/gomega"
+)
+
+// LearnSchema merges the payloads into a new schema.
+func LearnSchema(ctx context.Context, payloads ...interface{}) schema.Schema {
+	out, err := schemamerge.MergeMany(ctx, schemamerge.MergeManyInput{PayloadIterator: moxio.NewMemoryIterator(payloads)})
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	return out.Schema
+}
+
+// LearnHttpSpec merges the HTTP events into a new spec,
+// recording up to exampleLimit examples (or none if nil).
+func LearnHttpSpec(ctx context.Context, exampleLimit *int, events ...interface{}) asyncapispec.Specification {
+	spec := asyncapispec.Specification{}
+	ExpectWithOffset(1, asyncapispecmerge.MergeHttp(ctx, asyncapispecmerge.MergeInput{
+		Spec:          spec,
+		EventIterator: moxio.NewMemoryIterator(events),
+		ExampleLimit:  exampleLimit,
+	})).To(Succeed())
+	return spec
+}
@@ -2,6 +2,7 @@ package jsonformat
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"github.com/lithictech/moxpopuli/jsontype"
 	"github.com/rickb777/date/period"
 	"math"
@@ -30,6 +31,7 @@ const (
 	F_CURRENCY  JsonFormat = "iso-currency"
 	F_IPV4      JsonFormat = "ipv4"
 	F_IPV6      JsonFormat = "ipv6"
+	F_JSON      JsonFormat = "json"
 	F_URI       JsonFormat = "uri"
 	F_UUID4     JsonFormat = "uuid4"
 	F_NUMERICAL JsonFormat = "numerical"
@@ -58,7 +60,9 @@ func IsChronolike(f JsonFormat) bool {
 func Sniff(t jsontype.JsonType, value interface{}) JsonFormat {
 	if t == jsontype.T_STRING {
 		s := value.(string)
-		if sniffEmail(s) {
+		if sniffJson(s) {
+			return F_JSON
+		} else if sniffEmail(s) {
 			return F_EMAIL
 		} else if sniffUrl(s) {
 			return F_URI
@@ -131,6 +135,17 @@ func sniffBase64(s string) bool {
 	return err == nil
 }
 
+// sniffJson is true for JSON objects and arrays encoded into a string,
+// like '{"order_id": 5}'. Scalars are not considered JSON,
+// since every number would look like an encoded document.
+func sniffJson(s string) bool {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "{") && !strings.HasPrefix(s, "[") {
+		return false
+	}
+	return json.Valid([]byte(s))
+}
+
 func sniffUrl(s string) bool {
 	// Do not use URL parsing, it hits tons of false-positives.
 	return strings.HasPrefix(s, "/") || urlRegex.MatchString(s)
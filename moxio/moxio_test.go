@@ -2,11 +2,13 @@ package moxio_test
 
 import (
 	"context"
+	"errors"
 	"github.com/lithictech/moxpopuli/moxio"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"io"
 	"os"
+	"sync"
 	"testing"
 )
 
@@ -45,3 +47,59 @@ var _ = Describe("savers", func() {
 		})
 	})
 })
+
+var _ = Describe("Shard", func() {
+	ctx := context.Background()
+	items := func(n int) []interface{} {
+		r := make([]interface{}, n)
+		for i := range r {
+			r[i] = i
+		}
+		return r
+	}
+	It("distributes every item to one of the shards", func() {
+		mux := sync.Mutex{}
+		var seen []interface{}
+		shards := map[int]struct{}{}
+		Expect(moxio.Shard(ctx, moxio.NewMemoryIterator(items(100)), 4, func(ctx context.Context, shard int, it moxio.Iterator) error {
+			for it.Next() {
+				item, err := it.Read(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				mux.Lock()
+				seen = append(seen, item)
+				shards[shard] = struct{}{}
+				mux.Unlock()
+			}
+			return nil
+		})).To(Succeed())
+		Expect(seen).To(ConsistOf(items(100)...))
+		Expect(len(shards)).To(BeNumerically(">=", 1))
+	})
+	It("returns the first error from work", func() {
+		err := moxio.Shard(ctx, moxio.NewMemoryIterator(items(100)), 4, func(ctx context.Context, shard int, it moxio.Iterator) error {
+			for it.Next() {
+			}
+			return errors.New("hi")
+		})
+		Expect(err).To(MatchError("hi"))
+	})
+	It("returns the context's error when cancelled", func() {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		var mux sync.Mutex
+		seen := 0
+		err := moxio.Shard(ctx, moxio.NewMemoryIterator(items(100)), 4, func(ctx context.Context, shard int, it moxio.Iterator) error {
+			for it.Next() {
+				mux.Lock()
+				seen++
+				if seen == 10 {
+					cancel()
+				}
+				mux.Unlock()
+			}
+			return nil
+		})
+		Expect(err).To(MatchError(context.Canceled))
+		Expect(seen).To(BeNumerically("<", 100))
+	})
+})
@@ -0,0 +1,83 @@
+package moxio
+
+import (
+	"context"
+	"sync"
+)
+
+// Shard reads the source iterator on one goroutine, and distributes its items across n iterators,
+// each consumed by work on its own goroutine.
+// Items go to whichever worker is ready, so the shards are not ordered or evenly sized.
+//
+// It returns once all work is done, with the first error from reading the source or from work.
+// If work returns an error, the other shards stop early.
+// If ctx is cancelled, the shards stop early and the context's error is returned,
+// so callers don't mistake a partial run for a complete one.
+// The source is not closed.
+func Shard(ctx context.Context, source Iterator, n int, work func(ctx context.Context, shard int, it Iterator) error) error {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	items := make(chan interface{}, n)
+	workErrs := make(chan error, n)
+	wg := sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := work(ctx, i, &chanIterator{ctx: ctx, items: items}); err != nil {
+				workErrs <- err
+				cancel()
+			}
+		}(i)
+	}
+	readErr := func() error {
+		defer close(items)
+		for source.Next() {
+			item, err := source.Read(ctx)
+			if err != nil {
+				return err
+			}
+			select {
+			case items <- item:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		return nil
+	}()
+	wg.Wait()
+	if readErr != nil {
+		return readErr
+	}
+	select {
+	case err := <-workErrs:
+		return err
+	default:
+		return parent.Err()
+	}
+}
+
+type chanIterator struct {
+	ctx   context.Context
+	items <-chan interface{}
+	item  interface{}
+}
+
+func (m *chanIterator) Next() bool {
+	select {
+	case item, ok := <-m.items:
+		m.item = item
+		return ok
+	case <-m.ctx.Done():
+		return false
+	}
+}
+
+func (m *chanIterator) Read(_ context.Context) (interface{}, error) {
+	return m.item, nil
+}
+
+func (m *chanIterator) Close() error {
+	return nil
+}
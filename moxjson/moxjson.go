@@ -56,6 +56,17 @@ func ParsePath(s string) Path {
 	return path
 }
 
+// JsonPathKey returns the JSONPath expression for the key of the object at path,
+// like '$.user' or '$["user.name"]' for keys that are not identifiers.
+func JsonPathKey(path, key string) string {
+	if identifierRegex.MatchString(key) {
+		return path + "." + key
+	}
+	return path + "[" + strconv.Quote(key) + "]"
+}
+
+var identifierRegex = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
 var jqInd = regexp.MustCompile("^\\[\\d+\\]$")
 var numInd = regexp.MustCompile("^\\d+$")
 
@@ -39,7 +39,16 @@ var _ = Describe("schemagen", func() {
 					"format": "int32",
 					"type": "integer",
 					"x-seenMaximum": 1,
-					"x-seenMinimum": 1
+					"x-seenMinimum": 1,
+					"x-stats": {
+						"count": 1,
+						"integer": true,
+						"sum": 1,
+						"m2": 0,
+						"mean": 1,
+						"variance": 0,
+						"positive": {"0": 1}
+					}
 				}
 			},
 			"type": "object",
@@ -61,7 +70,15 @@ var _ = Describe("schemagen", func() {
 					"type": "number",
 					"x-samples": 2,
 					"x-seenMaximum": 10.5,
-					"x-seenMinimum": 10
+					"x-seenMinimum": 10,
+					"x-stats": {
+						"count": 2,
+						"sum": 20.5,
+						"m2": 0.125,
+						"mean": 10.25,
+						"variance": 0.0625,
+						"positive": {"117": 1, "119": 1}
+					}
 				}
 			},
 			"type": "object",
@@ -83,7 +100,16 @@ var _ = Describe("schemagen", func() {
 					"type": "number",
 					"x-samples": 2,
 					"x-seenMaximum": 10.1,
-					"x-seenMinimum": 0
+					"x-seenMinimum": 0,
+					"x-stats": {
+						"count": 2,
+						"zeros": 1,
+						"sum": 10.1,
+						"m2": 51.005,
+						"mean": 5.05,
+						"variance": 25.5,
+						"positive": {"117": 1}
+					}
 				}
 			},
 			"type": "object",
@@ -102,6 +128,15 @@ var _ = Describe("schemagen", func() {
 			"properties": {
 				"y": {
 					"type": "string",
+					"x-lengthStats": {
+						"count": 2,
+						"integer": true,
+						"sum": 14,
+						"m2": 72,
+						"mean": 7,
+						"variance": 36,
+						"positive": {"0": 1, "130": 1}
+					},
 					"x-samples": 2,
 					"x-seenMaxLength": 13,
 					"x-seenMinLength": 1
@@ -124,12 +159,22 @@ var _ = Describe("schemagen", func() {
 			"properties": {
 				"x": {
 					"type": "string",
+					"x-lengthStats": {
+						"count": 1,
+						"integer": true,
+						"sum": 28,
+						"m2": 0,
+						"mean": 28,
+						"variance": 0,
+						"positive": {"169": 1}
+					},
 					"x-seenMaxLength": 28,
 					"x-seenMinLength": 28,
 					"x-seenStrings": [
 						"3CSutK3KOFEaqW2zub394P5fjGDT"
 					],
-					"x-sensitive": true
+					"x-sensitive": true,
+					"x-valueCounts": {"3CSutK3KOFEaqW2zub394P5fjGDT": 1}
 				}
 			},
 			"type": "object",
@@ -159,9 +204,19 @@ var _ = Describe("schemagen", func() {
 						"VALUE_5"
 					],
 					"type": "string",
+					"x-lengthStats": {
+						"count": 50,
+						"integer": true,
+						"sum": 350,
+						"m2": 0,
+						"mean": 7,
+						"variance": 0,
+						"positive": {"99": 50}
+					},
 					"x-samples": 50,
 					"x-seenMaxLength": 7,
-					"x-seenMinLength": 7
+					"x-seenMinLength": 7,
+					"x-valueCounts": {"VALUE_1": 10, "VALUE_2": 10, "VALUE_3": 10, "VALUE_4": 10, "VALUE_5": 10}
 				}
 			},
 			"type": "object",
@@ -176,12 +231,22 @@ var _ = Describe("schemagen", func() {
 			"properties": {
 				"x": {
 					"type": "string",
+					"x-lengthStats": {
+						"count": 1,
+						"integer": true,
+						"sum": 28,
+						"m2": 0,
+						"mean": 28,
+						"variance": 0,
+						"positive": {"169": 1}
+					},
 					"x-seenMaxLength": 28,
 					"x-seenMinLength": 28,
 					"x-seenStrings": [
 						"3CSutK3KOFEaqW2zub394P5fjGDT"
 					],
-					"x-sensitive": true
+					"x-sensitive": true,
+					"x-valueCounts": {"3CSutK3KOFEaqW2zub394P5fjGDT": 1}
 				}
 			},
 			"type": "object"
@@ -190,12 +255,22 @@ var _ = Describe("schemagen", func() {
 			"properties": {
 				"x": {
 					"type": "string",
+					"x-lengthStats": {
+						"count": 1,
+						"integer": true,
+						"sum": 28,
+						"m2": 0,
+						"mean": 28,
+						"variance": 0,
+						"positive": {"169": 1}
+					},
 					"x-seenMaxLength": 28,
 					"x-seenMinLength": 28,
 					"x-seenStrings": [
 						"3CSutK3KOFEaqW2zub394P5fjGDT"
 					],
-					"x-sensitive": true
+					"x-sensitive": true,
+					"x-valueCounts": {"3CSutK3KOFEaqW2zub394P5fjGDT": 1}
 				}
 			},
 			"type": "object"
@@ -206,12 +281,22 @@ var _ = Describe("schemagen", func() {
 			"properties": {
 				"x": {
 					"type": "string",
+					"x-lengthStats": {
+						"count": 1,
+						"integer": true,
+						"sum": 28,
+						"m2": 0,
+						"mean": 28,
+						"variance": 0,
+						"positive": {"169": 1}
+					},
 					"x-seenMaxLength": 28,
 					"x-seenMinLength": 28,
 					"x-seenStrings": [
 						"7IWIqRa7Q6q2JiImGD3VQ3Guxa2a"
 					],
-					"x-sensitive": true
+					"x-sensitive": true,
+					"x-valueCounts": {"7IWIqRa7Q6q2JiImGD3VQ3Guxa2a": 1}
 				}
 			},
 			"type": "object"
@@ -230,7 +315,16 @@ var _ = Describe("schemagen", func() {
 					"type": "integer",
 					"x-samples": 2,
 					"x-seenMaximum": 0,
-					"x-seenMinimum": 0
+					"x-seenMinimum": 0,
+					"x-stats": {
+						"count": 2,
+						"zeros": 2,
+						"integer": true,
+						"sum": 0,
+						"m2": 0,
+						"mean": 0,
+						"variance": 0
+					}
 				}
 			},
 			"type": "object",
@@ -248,7 +342,17 @@ var _ = Describe("schemagen", func() {
 					"type": "integer",
 					"x-samples": 3,
 					"x-seenMaximum": 1,
-					"x-seenMinimum": 0
+					"x-seenMinimum": 0,
+					"x-stats": {
+						"count": 3,
+						"zeros": 2,
+						"integer": true,
+						"sum": 1,
+						"m2": 0.6666666666666666,
+						"mean": 0.3333,
+						"variance": 0.2222,
+						"positive": {"0": 1}
+					}
 				}
         },
         "type": "object",
@@ -270,7 +374,17 @@ var _ = Describe("schemagen", func() {
 					"type": "integer",
 					"x-samples": 6,
 					"x-seenMaximum": 1,
-					"x-seenMinimum": 0
+					"x-seenMinimum": 0,
+					"x-stats": {
+						"count": 6,
+						"zeros": 4,
+						"integer": true,
+						"sum": 2,
+						"m2": 1.3333333333333333,
+						"mean": 0.3333,
+						"variance": 0.2222,
+						"positive": {"0": 2}
+					}
 				}
 			},
 			"type": "object",
@@ -288,7 +402,17 @@ var _ = Describe("schemagen", func() {
 					"type": "integer",
 					"x-samples": 7,
 					"x-seenMaximum": 2,
-					"x-seenMinimum": 0
+					"x-seenMinimum": 0,
+					"x-stats": {
+						"count": 7,
+						"zeros": 4,
+						"integer": true,
+						"sum": 4,
+						"m2": 3.7142857142857144,
+						"mean": 0.5714,
+						"variance": 0.5306,
+						"positive": {"0": 2, "36": 1}
+					}
 				}
 			},
 			"type": "object",
@@ -0,0 +1,47 @@
+// Package moxrand carries the random source used to sample examples through a context,
+// so that output can be reproduced by seeding it.
+package moxrand
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+)
+
+type ctxKey struct{}
+
+// WithSeed returns a context whose random source is seeded with seed.
+// The source is safe to use from multiple goroutines.
+func WithSeed(ctx context.Context, seed int64) context.Context {
+	return context.WithValue(ctx, ctxKey{}, rand.New(&lockedSource{src: rand.NewSource(seed).(rand.Source64)}))
+}
+
+// FromContext returns the random source set by WithSeed,
+// or nil if there is none (callers should use the math/rand global functions).
+func FromContext(ctx context.Context) *rand.Rand {
+	r, _ := ctx.Value(ctxKey{}).(*rand.Rand)
+	return r
+}
+
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source64
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Uint64()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/lithictech/moxpopuli/asyncapispec"
+	"github.com/lithictech/moxpopuli/cloudevents"
 	"github.com/lithictech/moxpopuli/datagen"
 	"github.com/lithictech/moxpopuli/faker"
 	"github.com/lithictech/moxpopuli/fp"
@@ -26,10 +27,25 @@ type VoxInput struct {
 	Count          int
 	ChannelMatcher *regexp.Regexp
 	Printer        io.Writer
+	// Messages per second to send on each connection, for bindings with long-lived connections.
+	// If <= 0, send as fast as possible.
+	Rate float64
+	// If true, replay each message's recorded examples (in order) instead of generating them,
+	// for messages that have examples.
+	ReplayExamples bool
 }
 
 func collectEventFixturesForApiSpec(ctx context.Context, in VoxInput, binding string) []EventFixture {
-	servers := fp.Values(in.Spec.GetOrAddServers())
+	return collectEventFixturesForOperation(ctx, in, binding, asyncapispec.ChannelItem.GetOrAddSubscribe)
+}
+
+func collectEventFixturesForOperation(
+	ctx context.Context,
+	in VoxInput,
+	binding string,
+	getOperation func(asyncapispec.ChannelItem) asyncapispec.Operation,
+) []EventFixture {
+	allServers := fp.Values(in.Spec.GetOrAddServers())
 	channels := in.Spec.GetOrAddChannels()
 	eventSpecs := make([]EventFixture, 0, len(channels)*in.Count)
 	for chanName := range channels {
@@ -37,34 +53,170 @@ func collectEventFixturesForApiSpec(ctx context.Context, in VoxInput, binding st
 			continue
 		}
 		channel := channels.GetOrAddItem(chanName)
-		subscribe := channel.GetOrAddSubscribe()
-		if _, ok := subscribe.GetOrAddBindings()["http"]; !ok {
-			// No http bindings for this subscription
+		operation := getOperation(channel)
+		if _, ok := operation["message"]; !ok {
+			// Nothing is sent with this operation
 			continue
 		}
-		opBinding := subscribe.GetOrAddBindings().GetOrAdd(binding)
-		msg := subscribe.GetOrAddMessage()
-		// This is gross but we need to 'prime' this here so we don't hit a race condition later,
-		// since this mutates the receiver in place.
-		headerSchema := msg.GetOrAddHeaders()
-		payloadSchema := msg.GetOrAddPayload()
-		for i := 0; i < in.Count; i++ {
-			eventSpecs = append(eventSpecs, EventFixture{
-				Id:               fmt.Sprintf("%s-%d", chanName, i),
-				Server:           fp.Sample(servers).(map[string]interface{}),
-				ChannelName:      chanName,
-				Channel:          channel,
-				Operation:        subscribe,
-				OperationBinding: opBinding,
-				Message:          msg,
-				Headers:          datagen.Generate(ctx, datagen.GenerateInput{Schema: headerSchema}).(map[string]interface{}),
-				Payload:          datagen.Generate(ctx, datagen.GenerateInput{Schema: payloadSchema}),
-			})
+		_, opHasBinding := operation.GetOrAddBindings()[binding]
+		_, chanHasBinding := channel.GetOrAddBindings()[binding]
+		if !opHasBinding && !chanHasBinding {
+			// No bindings of this type for this channel
+			continue
+		}
+		opBinding := operation.GetOrAddBindings().GetOrAdd(binding)
+		servers := channelServers(in.Spec, channel, allServers)
+		discriminator, hasDiscriminator := operation.Discriminator()
+		for _, msg := range operation.Messages() {
+			// Not all bindings have message headers (like MQTT 3), so don't add them if they're missing.
+			_, hasHeaders := msg["headers"]
+			// This is gross but we need to 'prime' this here so we don't hit a race condition later,
+			// since this mutates the receiver in place.
+			headerSchema := msg.GetOrAddHeaders()
+			payloadSchema := msg.GetOrAddPayload()
+			ceMode, ceTrait := cloudEventTrait(in.Spec, msg)
+			examples := msg.Examples()
+			idPrefix := chanName
+			if msg.Name() != "" {
+				idPrefix = chanName + "-" + msg.Name()
+			}
+			for i := 0; i < in.Count; i++ {
+				headers := map[string]interface{}{}
+				var payload interface{}
+				if in.ReplayExamples && len(examples) > 0 {
+					example := examples[i%len(examples)]
+					for k, v := range example.Headers() {
+						headers[k] = v
+					}
+					payload = cloneJson(example.Payload())
+				} else {
+					if hasHeaders {
+						headers = datagen.Generate(ctx, datagen.GenerateInput{Schema: headerSchema}).(map[string]interface{})
+					}
+					payload = datagen.Generate(ctx, datagen.GenerateInput{Schema: payloadSchema})
+				}
+				if hasDiscriminator && msg.Name() != "" {
+					discriminator.Set(headers, payload, msg.Name())
+				}
+				if ceTrait != nil {
+					payload = wrapCloudEvent(ctx, ceMode, ceTrait, msg, headers, payload)
+				}
+				eventSpecs = append(eventSpecs, EventFixture{
+					Id:               fmt.Sprintf("%s-%d", idPrefix, i),
+					Server:           fp.Sample(servers).(map[string]interface{}),
+					ChannelName:      chanName,
+					Address:          resolveAddress(ctx, chanName, channel),
+					Channel:          channel,
+					Operation:        operation,
+					OperationBinding: opBinding,
+					Message:          msg,
+					Headers:          headers,
+					Payload:          payload,
+				})
+			}
 		}
 	}
 	return eventSpecs
 }
 
+// cloudEventTrait returns the envelope mode and trait if the message is a CloudEvent.
+func cloudEventTrait(spec asyncapispec.Specification, msg asyncapispec.Message) (string, asyncapispec.MessageTrait) {
+	for _, ref := range msg.TraitRefs() {
+		trait, ok := spec.ResolveMessageTrait(ref)
+		if !ok {
+			continue
+		}
+		if mode, ok := trait[cloudevents.TraitModeField].(string); ok {
+			// Prime the envelope schema, see above.
+			trait.GetOrAddSchema(cloudevents.EnvelopeField(mode))
+			return mode, trait
+		}
+	}
+	return "", nil
+}
+
+// wrapCloudEvent generates envelope attributes and returns the payload to send.
+// Binary-mode attributes are added to the headers.
+func wrapCloudEvent(
+	ctx context.Context,
+	mode string,
+	trait asyncapispec.MessageTrait,
+	msg asyncapispec.Message,
+	headers map[string]interface{},
+	data interface{},
+) interface{} {
+	envelope := trait.GetOrAddSchema(cloudevents.EnvelopeField(mode))
+	attrs, _ := datagen.Generate(ctx, datagen.GenerateInput{Schema: envelope}).(map[string]interface{})
+	dataBase64, _ := msg[cloudevents.MessageDataBase64Field].(bool)
+	ce := cloudevents.Event{Mode: mode, Type: msg.Name(), Attributes: attrs, Data: data, DataBase64: dataBase64}
+	// These must always be valid, so do not rely on what was generated.
+	ce.SetAttribute("specversion", cloudevents.SpecVersion)
+	ce.SetAttribute("id", faker.UUID4())
+	if mode == cloudevents.MODE_BINARY {
+		ceHeaders, body := ce.Binary()
+		for k, v := range ceHeaders {
+			headers[k] = v
+		}
+		return body
+	}
+	return ce.Structured()
+}
+
+// cloneJson returns a deep copy of a JSON value, so examples are not modified
+// when the discriminator or envelope is set into the payload.
+func cloneJson(v interface{}) interface{} {
+	switch vt := v.(type) {
+	case map[string]interface{}:
+		r := make(map[string]interface{}, len(vt))
+		for k, item := range vt {
+			r[k] = cloneJson(item)
+		}
+		return r
+	case []interface{}:
+		r := make([]interface{}, len(vt))
+		for i, item := range vt {
+			r[i] = cloneJson(item)
+		}
+		return r
+	}
+	return v
+}
+
+// channelServers returns the servers the channel is available on,
+// or all servers if the channel does not name any.
+func channelServers(spec asyncapispec.Specification, channel asyncapispec.ChannelItem, all []interface{}) []interface{} {
+	names := channel.Servers()
+	if len(names) == 0 {
+		return all
+	}
+	servers := spec.GetOrAddServers()
+	r := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		if srv, ok := servers[name]; ok {
+			r = append(r, srv)
+		}
+	}
+	if len(r) == 0 {
+		return all
+	}
+	return r
+}
+
+// resolveAddress replaces parameters in the channel name, like 'devices/{deviceId}',
+// with values generated from the parameter schemas.
+func resolveAddress(ctx context.Context, chanName string, channel asyncapispec.ChannelItem) string {
+	if _, ok := channel["parameters"]; !ok {
+		return chanName
+	}
+	params := channel.GetOrAddParameters()
+	address := chanName
+	for name := range params {
+		value := datagen.Generate(ctx, datagen.GenerateInput{Key: name, Schema: params.GetOrAdd(name).GetOrAddSchema()})
+		address = strings.ReplaceAll(address, "{"+name+"}", fmt.Sprintf("%v", value))
+	}
+	return address
+}
+
 func playEvents(ctx context.Context, events []EventFixture, playEvent func(context.Context, EventFixture) error) error {
 	// This is the only place concurrency is used so we keep it inline,
 	// we should use more sophisticated tools if we need more concurrency
@@ -97,14 +249,27 @@ func playEvents(ctx context.Context, events []EventFixture, playEvent func(conte
 func HttpVox(ctx context.Context, in VoxInput) error {
 	eventSpecs := collectEventFixturesForApiSpec(ctx, in, "http")
 	for _, e := range eventSpecs {
-		// Gross but we need to prime these to avoid mutations during concurrent requests.
-		// We replace instances of map[string]interface into map[string]Schema
-		e.Message.GetOrAddBindings().GetOrAddHttp().GetOrAddHeaders().Properties()
+		// Generate protocol headers (like User-Agent) before making requests,
+		// since generating can mutate the schema, which would race during concurrent requests.
+		httpBinding := e.Message.GetOrAddBindings().GetOrAddHttp()
+		if _, ok := httpBinding["headers"]; !ok {
+			continue
+		}
+		protoHeaders := datagen.Generate(ctx, datagen.GenerateInput{Schema: schema.Schema(httpBinding.GetOrAddHeaders())})
+		for k, v := range protoHeaders.(map[string]interface{}) {
+			if _, ok := e.Headers[k]; !ok {
+				e.Headers[k] = v
+			}
+		}
 	}
 	mux := sync.Mutex{}
 	return playEvents(ctx, eventSpecs, func(ctx context.Context, e EventFixture) error {
 		method := asyncapispec.HttpOperationBinding(e.OperationBinding).Method()
-		url := fmt.Sprintf("%s://%s%s", e.Server.Protocol(), e.Server.Url(), e.ChannelName)
+		credHeaders, credQuery := securityCredentials(in.Spec, e.Server)
+		url := fmt.Sprintf("%s://%s%s", e.Server.Protocol(), e.Server.Url(), e.Address)
+		if len(credQuery) > 0 {
+			url += "?" + credQuery.Encode()
+		}
 		body, err := e.MarshalBody()
 		if err != nil {
 			return err
@@ -113,12 +278,12 @@ func HttpVox(ctx context.Context, in VoxInput) error {
 		if err != nil {
 			return err
 		}
-		for k, v := range e.Message.GetOrAddBindings().GetOrAddHttp().GetOrAddHeaders().Properties() {
-			req.Header.Set(k, v[schema.PX_LAST_VALUE].(string))
-		}
 		for k, v := range e.Headers {
 			req.Header.Set(k, fmt.Sprintf("%v", v))
 		}
+		for k, v := range credHeaders {
+			req.Header.Set(k, v)
+		}
 		req.Header.Set("Content-Type", e.Message.ContentType())
 		if cidheader, ok := e.Message.CorrelationIdHeaderKey(); ok {
 			req.Header.Set(cidheader, faker.UUID4())
@@ -147,9 +312,11 @@ func HttpVox(ctx context.Context, in VoxInput) error {
 }
 
 type EventFixture struct {
-	Id               string
-	Server           asyncapispec.Server
-	ChannelName      string
+	Id          string
+	Server      asyncapispec.Server
+	ChannelName string
+	// Address is ChannelName with any parameters replaced with generated values.
+	Address          string
 	Channel          asyncapispec.ChannelItem
 	Operation        asyncapispec.Operation
 	OperationBinding asyncapispec.OperationBinding
@@ -160,7 +327,7 @@ type EventFixture struct {
 
 func (e EventFixture) MarshalBody() ([]byte, error) {
 	ct := e.Message.ContentType()
-	if ct == "" || strings.Contains(ct, "application/json") {
+	if ct == "" || strings.Contains(ct, "application/json") || strings.Contains(ct, "+json") {
 		return json.Marshal(e.Payload)
 	}
 	return nil, errors.New("unsupported content type for fixturing: " + e.Message.ContentType())
@@ -0,0 +1,256 @@
+package moxvox
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"github.com/lithictech/moxpopuli/asyncapispec"
+	"github.com/lithictech/moxpopuli/faker"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func MqttVox(ctx context.Context, in VoxInput) error {
+	eventSpecs := collectEventFixturesForApiSpec(ctx, in, "mqtt")
+	mux := sync.Mutex{}
+	var connections int64
+	return playEvents(ctx, eventSpecs, func(ctx context.Context, e EventFixture) error {
+		binding := asyncapispec.MqttOperationBinding(e.OperationBinding)
+		body, err := e.MarshalBody()
+		if err != nil {
+			return err
+		}
+		// Brokers disconnect the existing session when a client id is reused,
+		// so each of the concurrent connections needs its own.
+		clientId := e.Server.GetOrAddBindings().GetOrAddMqtt().ClientId()
+		if clientId == "" {
+			clientId = "moxvox-" + faker.Hex(12)
+		} else {
+			clientId = fmt.Sprintf("%s-%d", clientId, atomic.AddInt64(&connections, 1))
+		}
+		conn, err := dialMqtt(ctx, e.Server)
+		if err != nil {
+			return errors.Wrap(err, "connecting to broker")
+		}
+		defer conn.Close()
+		if err := conn.Connect(clientId); err != nil {
+			return err
+		}
+		if err := conn.Publish(e.Address, body, binding.Qos(), binding.Retain()); err != nil {
+			return err
+		}
+		if in.Printer != nil {
+			mux.Lock()
+			_, _ = fmt.Fprintf(in.Printer, "PUBLISH %s\n%s qos=%d retain=%t\n%s\n\n", e.Id, e.Address, binding.Qos(), binding.Retain(), body)
+			mux.Unlock()
+		}
+		return conn.Disconnect()
+	})
+}
+
+// mqttConn is the bare minimum of an MQTT 3.1.1 client needed to publish messages.
+// See https://docs.oasis-open.org/mqtt/mqtt/v3.1.1/os/mqtt-v3.1.1-os.html
+type mqttConn struct {
+	ctx      context.Context
+	conn     net.Conn
+	r        *bufio.Reader
+	packetId uint16
+	closed   chan struct{}
+}
+
+// mqttTimeout limits each read and write, so a broker that stops responding
+// (like one that never sends a connack) fails the publish rather than hanging it.
+const mqttTimeout = 10 * time.Second
+
+func dialMqtt(ctx context.Context, srv asyncapispec.Server) (*mqttConn, error) {
+	dialer := &net.Dialer{Timeout: mqttTimeout}
+	var conn net.Conn
+	var err error
+	switch srv.Protocol() {
+	case "mqtt", "tcp":
+		conn, err = dialer.DialContext(ctx, "tcp", srv.Url())
+	case "mqtts", "ssl", "tls":
+		conn, err = (&tls.Dialer{NetDialer: dialer}).DialContext(ctx, "tcp", srv.Url())
+	default:
+		return nil, errors.Errorf("unsupported mqtt server protocol '%s'", srv.Protocol())
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &mqttConn{ctx: ctx, conn: conn, r: bufio.NewReader(conn), closed: make(chan struct{})}
+	go func() {
+		// Unblock any read or write when the context is done.
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-c.closed:
+		}
+	}()
+	return c, nil
+}
+
+const (
+	mqttConnect    byte = 0x10
+	mqttConnack    byte = 0x20
+	mqttPublish    byte = 0x30
+	mqttPuback     byte = 0x40
+	mqttPubrec     byte = 0x50
+	mqttPubrel     byte = 0x62
+	mqttPubcomp    byte = 0x70
+	mqttDisconnect byte = 0xe0
+)
+
+func (c *mqttConn) Connect(clientId string) error {
+	body := mqttString("MQTT")
+	body = append(body, 4)     // protocol level 3.1.1
+	body = append(body, 0x02)  // clean session
+	body = append(body, 0, 60) // keepalive seconds
+	body = append(body, mqttString(clientId)...)
+	if err := c.write(mqttConnect, body); err != nil {
+		return errors.Wrap(err, "writing connect")
+	}
+	header, resp, err := c.read()
+	if err != nil {
+		return errors.Wrap(err, "reading connack")
+	}
+	if header != mqttConnack || len(resp) != 2 {
+		return errors.Errorf("expected connack, got packet 0x%x", header)
+	}
+	if resp[1] != 0 {
+		return errors.Errorf("broker refused connection with return code %d", resp[1])
+	}
+	return nil
+}
+
+func (c *mqttConn) Publish(topic string, payload []byte, qos int, retain bool) error {
+	header := mqttPublish | byte(qos<<1)
+	if retain {
+		header |= 0x01
+	}
+	body := mqttString(topic)
+	var id []byte
+	if qos > 0 {
+		c.packetId++
+		id = binary.BigEndian.AppendUint16(nil, c.packetId)
+		body = append(body, id...)
+	}
+	body = append(body, payload...)
+	if err := c.write(header, body); err != nil {
+		return errors.Wrap(err, "writing publish")
+	}
+	switch qos {
+	case 1:
+		return c.expect(mqttPuback, id)
+	case 2:
+		if err := c.expect(mqttPubrec, id); err != nil {
+			return err
+		}
+		if err := c.write(mqttPubrel, id); err != nil {
+			return errors.Wrap(err, "writing pubrel")
+		}
+		return c.expect(mqttPubcomp, id)
+	}
+	return nil
+}
+
+func (c *mqttConn) Disconnect() error {
+	return c.write(mqttDisconnect, nil)
+}
+
+func (c *mqttConn) Close() error {
+	close(c.closed)
+	return c.conn.Close()
+}
+
+func (c *mqttConn) expect(header byte, id []byte) error {
+	got, body, err := c.read()
+	if err != nil {
+		return errors.Wrapf(err, "reading ack 0x%x", header)
+	}
+	if got != header || string(body) != string(id) {
+		return errors.Errorf("expected ack 0x%x for packet %v, got 0x%x for %v", header, id, got, body)
+	}
+	return nil
+}
+
+// setDeadline limits the next read or write to mqttTimeout, or the context's deadline if sooner.
+func (c *mqttConn) setDeadline() error {
+	d := time.Now().Add(mqttTimeout)
+	if ctxd, ok := c.ctx.Deadline(); ok && ctxd.Before(d) {
+		d = ctxd
+	}
+	return c.conn.SetDeadline(d)
+}
+
+// ioErr returns the context's error if it is done, since that is why the connection was closed.
+func (c *mqttConn) ioErr(err error) error {
+	if ctxErr := c.ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+func (c *mqttConn) write(header byte, body []byte) error {
+	if err := c.setDeadline(); err != nil {
+		return c.ioErr(err)
+	}
+	pkt := append([]byte{header}, mqttRemainingLength(len(body))...)
+	_, err := c.conn.Write(append(pkt, body...))
+	return c.ioErr(err)
+}
+
+func (c *mqttConn) read() (byte, []byte, error) {
+	if err := c.setDeadline(); err != nil {
+		return 0, nil, c.ioErr(err)
+	}
+	header, err := c.r.ReadByte()
+	if err != nil {
+		return 0, nil, c.ioErr(err)
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		// The remaining length is at most 4 bytes.
+		if i == 4 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return 0, nil, c.ioErr(err)
+		}
+		length += int(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return 0, nil, c.ioErr(err)
+	}
+	return header, body, nil
+}
+
+func mqttString(s string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+}
+
+func mqttRemainingLength(n int) []byte {
+	r := make([]byte, 0, 4)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		r = append(r, b)
+		if n == 0 {
+			return r
+		}
+	}
+}
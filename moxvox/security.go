@@ -0,0 +1,85 @@
+package moxvox
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/lithictech/moxpopuli/asyncapispec"
+	"github.com/lithictech/moxpopuli/faker"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// securityCredentials generates credentials for each of the server's security requirements.
+// Real credentials are never stored in the spec, so receivers that verify them will reject these;
+// use them against servers that only check for their presence and shape.
+func securityCredentials(spec asyncapispec.Specification, server asyncapispec.Server) (map[string]string, url.Values) {
+	headers := make(map[string]string, 2)
+	query := url.Values{}
+	for _, name := range server.SecurityRequirements() {
+		scheme, ok := spec.ResolveSecurityScheme(name)
+		if !ok {
+			continue
+		}
+		switch scheme.Type() {
+		case "http":
+			switch scheme.Scheme() {
+			case "bearer":
+				token := faker.Hex(40)
+				if scheme["bearerFormat"] == "JWT" {
+					token = fakeJwt()
+				}
+				headers["Authorization"] = "Bearer " + token
+			case "basic":
+				headers["Authorization"] = "Basic " + faker.Base64("moxvox:"+faker.Hex(16))
+			}
+		case "httpApiKey":
+			value := faker.Hex(40)
+			if algorithm, ok := scheme["x-signature"].(string); ok {
+				value = fakeSignature(scheme.Name(), algorithm)
+			}
+			if scheme.In() == "query" {
+				query.Set(scheme.Name(), value)
+			} else {
+				headers[scheme.Name()] = value
+			}
+		}
+	}
+	return headers, query
+}
+
+// fakeSignature returns a signature in the shape the header uses,
+// like Stripe's 't=1492774577,v1=5257a869...'.
+func fakeSignature(header, algorithm string) string {
+	size := 32
+	switch algorithm {
+	case "hmac-sha1":
+		size = 20
+	case "hmac-sha512":
+		size = 64
+	}
+	hexDigest := faker.Hex(size * 2)
+	b64Digest := base64.StdEncoding.EncodeToString(faker.Bytes(size, size))
+	switch strings.ToLower(header) {
+	case "stripe-signature":
+		return fmt.Sprintf("t=%d,v1=%s", time.Now().Unix(), hexDigest)
+	case "x-hub-signature":
+		return "sha1=" + hexDigest
+	case "x-hub-signature-256":
+		return "sha256=" + hexDigest
+	case "x-slack-signature":
+		return "v0=" + hexDigest
+	case "svix-signature", "webhook-signature":
+		return "v1," + b64Digest
+	case "x-shopify-hmac-sha256", "x-twilio-signature", "x-square-hmacsha256-signature":
+		return b64Digest
+	}
+	return hexDigest
+}
+
+func fakeJwt() string {
+	enc := base64.RawURLEncoding
+	header := enc.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	claims := enc.EncodeToString([]byte(`{"sub":"` + faker.UUID4() + `"}`))
+	return header + "." + claims + "." + enc.EncodeToString(faker.Bytes(32, 32))
+}
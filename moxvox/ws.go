@@ -0,0 +1,221 @@
+package moxvox
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"github.com/lithictech/moxpopuli/asyncapispec"
+	"github.com/lithictech/moxpopuli/datagen"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WsVox opens one connection for each channel with client-to-server ('publish') messages,
+// and sends generated frames over it at VoxInput.Rate.
+func WsVox(ctx context.Context, in VoxInput) error {
+	eventSpecs := collectEventFixturesForOperation(ctx, in, "ws", asyncapispec.ChannelItem.GetOrAddPublish)
+	connections := make([]wsConnectionFixture, 0, 4)
+	byChannel := make(map[string]int, 4)
+	for _, e := range eventSpecs {
+		idx, ok := byChannel[e.ChannelName]
+		if !ok {
+			idx = len(connections)
+			byChannel[e.ChannelName] = idx
+			connections = append(connections, newWsConnectionFixture(ctx, in.Spec, e))
+		}
+		connections[idx].Frames = append(connections[idx].Frames, e)
+	}
+	firsts := make([]EventFixture, len(connections))
+	for i, c := range connections {
+		firsts[i] = c.Frames[0]
+	}
+	mux := sync.Mutex{}
+	return playEvents(ctx, firsts, func(ctx context.Context, e EventFixture) error {
+		c := connections[byChannel[e.ChannelName]]
+		conn, err := dialWs(ctx, c.Url, c.Headers)
+		if err != nil {
+			return errors.Wrap(err, "connecting to "+c.Url.String())
+		}
+		defer conn.Close()
+		for i, frame := range c.Frames {
+			if i > 0 && in.Rate > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(time.Duration(float64(time.Second) / in.Rate)):
+				}
+			}
+			body, err := frame.MarshalBody()
+			if err != nil {
+				return err
+			}
+			if err := conn.WriteText(body); err != nil {
+				return errors.Wrap(err, "writing frame")
+			}
+			if in.Printer != nil {
+				mux.Lock()
+				_, _ = fmt.Fprintf(in.Printer, "FRAME %s\n%s\n%s\n\n", frame.Id, c.Url.String(), body)
+				mux.Unlock()
+			}
+		}
+		return conn.WriteClose()
+	})
+}
+
+type wsConnectionFixture struct {
+	Url     *url.URL
+	Headers http.Header
+	Frames  []EventFixture
+}
+
+func newWsConnectionFixture(ctx context.Context, spec asyncapispec.Specification, e EventFixture) wsConnectionFixture {
+	c := wsConnectionFixture{
+		// Channels are named by host and path, see wsmerge.
+		Url:     &url.URL{Scheme: e.Server.Protocol(), Host: e.Server.Url(), Path: strings.TrimPrefix(e.Address, e.Server.Url())},
+		Headers: http.Header{},
+	}
+	credHeaders, values := securityCredentials(spec, e.Server)
+	wsBinding := e.Channel.GetOrAddBindings().GetOrAddWs()
+	if _, ok := wsBinding["query"]; ok {
+		q := datagen.Generate(ctx, datagen.GenerateInput{Schema: wsBinding.GetOrAddOrTypeQuery()})
+		for k, v := range q.(map[string]interface{}) {
+			values.Set(k, fmt.Sprintf("%v", v))
+		}
+	}
+	c.Url.RawQuery = values.Encode()
+	if _, ok := wsBinding["headers"]; ok {
+		h := datagen.Generate(ctx, datagen.GenerateInput{Schema: wsBinding.GetOrAddOrTypeHeaders()})
+		for k, v := range h.(map[string]interface{}) {
+			c.Headers.Set(k, fmt.Sprintf("%v", v))
+		}
+	}
+	for k, v := range credHeaders {
+		c.Headers.Set(k, v)
+	}
+	return c
+}
+
+// wsConn is the bare minimum of a WebSocket client needed to send text frames.
+// See https://www.rfc-editor.org/rfc/rfc6455
+type wsConn struct {
+	conn net.Conn
+}
+
+const wsGuid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+func dialWs(ctx context.Context, u *url.URL, headers http.Header) (*wsConn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	var err error
+	switch u.Scheme {
+	case "ws", "http":
+		conn, err = dialer.DialContext(ctx, "tcp", hostWithPort(u, "80"))
+	case "wss", "https":
+		conn, err = (&tls.Dialer{NetDialer: dialer}).DialContext(ctx, "tcp", hostWithPort(u, "443"))
+	default:
+		return nil, errors.Errorf("unsupported ws server protocol '%s'", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	keyBytes := make([]byte, 16)
+	if _, err := rand.Read(keyBytes); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(keyBytes)
+	req := &http.Request{
+		Method:     "GET",
+		URL:        &url.URL{Path: u.Path, RawQuery: u.RawQuery},
+		Host:       u.Host,
+		Header:     headers.Clone(),
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(err, "writing handshake")
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(err, "reading handshake")
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		_ = conn.Close()
+		return nil, errors.Errorf("handshake failed with status %d", resp.StatusCode)
+	}
+	accept := sha1.Sum([]byte(key + wsGuid))
+	if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(accept[:]) {
+		_ = conn.Close()
+		return nil, errors.New("handshake returned an invalid Sec-WebSocket-Accept")
+	}
+	return &wsConn{conn: conn}, nil
+}
+
+func hostWithPort(u *url.URL, defaultPort string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), defaultPort)
+}
+
+const (
+	wsOpText  byte = 0x1
+	wsOpClose byte = 0x8
+)
+
+func (c *wsConn) WriteText(payload []byte) error {
+	return c.writeFrame(wsOpText, payload)
+}
+
+func (c *wsConn) WriteClose() error {
+	// 1000 is a normal closure.
+	return c.writeFrame(wsOpClose, binary.BigEndian.AppendUint16(nil, 1000))
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	// Clients must always mask frames.
+	n := len(payload)
+	switch {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	mask := make([]byte, 4)
+	if _, err := rand.Read(mask); err != nil {
+		return err
+	}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
@@ -0,0 +1,340 @@
+// Package numstat keeps streaming statistics about numbers (like the values of a number field,
+// or the lengths of a string field), for describing and generating realistic values.
+//
+// Values are counted in logarithmic buckets, so quantiles and samples are within a few percent of real values.
+// There are at most MaxBuckets buckets; when there would be more, all buckets are made coarser,
+// so the statistics of the same values are the same no matter what order they are added
+// or merged in. The sum and the sum of squared differences from the mean (which give the mean and variance)
+// are kept exactly while merging for the same reason, since summing floats in a different order
+// gives a different result. Values are taken to be the simplest fraction that rounds to them
+// (so 10.1 is 101/10), which lets the exact sum and M2 be recovered from the floats in JSON.
+package numstat
+
+import (
+	"math"
+	"math/big"
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+const (
+	// MaxBuckets is the most buckets kept.
+	MaxBuckets = 64
+	// Width of the finest buckets. Each bucket holds values up to this factor larger than its smallest value.
+	baseGamma = 1.02
+	// Absolute values smaller than this are counted as zeros.
+	zeroThreshold = 1e-9
+)
+
+type Stats struct {
+	// Number of values seen.
+	Count int `json:"count"`
+	// Number of values that were zero.
+	Zeros int `json:"zeros,omitempty"`
+	// Number of values that were negative.
+	Negatives int `json:"negatives,omitempty"`
+	// True if every value was an integer (like lengths), so estimates and samples are integers too.
+	Integer bool `json:"integer,omitempty"`
+	// The sum of the values, and the sum of their squared differences from the mean.
+	Sum float64 `json:"sum"`
+	M2  float64 `json:"m2"`
+	// The mean and (population) variance, from Sum and M2, to 4 significant digits.
+	// Use Quantile for quantiles.
+	Mean     float64 `json:"mean"`
+	Variance float64 `json:"variance"`
+	// How many times the buckets were made coarser (each time, twice as wide).
+	Level int `json:"level,omitempty"`
+	// Counts of positive values (and negative values, by their absolute value) in each bucket.
+	// Bucket i holds values in (gamma^(i-1), gamma^i], where gamma is 1.02^(2^Level).
+	Positive map[int]int `json:"positive,omitempty"`
+	Negative map[int]int `json:"negative,omitempty"`
+	// Exact Sum and M2, so merging does not depend on the order of the values.
+	// Stats loaded from JSON use Sum and M2.
+	sum, m2 *big.Rat
+}
+
+// Of returns the statistics of values.
+func Of(values ...float64) *Stats {
+	s := &Stats{Integer: true, Positive: map[int]int{}, Negative: map[int]int{}, sum: new(big.Rat), m2: new(big.Rat)}
+	for _, v := range values {
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			continue
+		}
+		s.sum, s.m2 = moments(s.Count, s.sum, s.m2, 1, rat(v), new(big.Rat))
+		s.Count++
+		s.Integer = s.Integer && v == math.Trunc(v)
+		switch {
+		case math.Abs(v) < zeroThreshold:
+			s.Zeros++
+		case v < 0:
+			s.Negatives++
+			s.Negative[index(-v)]++
+		default:
+			s.Positive[index(v)]++
+		}
+	}
+	s.collapse()
+	s.summarize()
+	return s
+}
+
+// Merge returns the statistics of the values of a and b, which can be nil.
+// It returns nil if both are nil.
+func Merge(a, b *Stats) *Stats {
+	if a == nil && b == nil {
+		return nil
+	}
+	r := Of()
+	for _, s := range []*Stats{a, b} {
+		if s != nil && s.Level > r.Level {
+			r.Level = s.Level
+		}
+	}
+	for _, s := range []*Stats{a, b} {
+		if s == nil {
+			continue
+		}
+		sum, m2 := s.exact()
+		r.sum, r.m2 = moments(r.Count, r.sum, r.m2, s.Count, sum, m2)
+		r.Count += s.Count
+		r.Integer = r.Integer && s.Integer
+		r.Zeros += s.Zeros
+		r.Negatives += s.Negatives
+		shift := r.Level - s.Level
+		for i, n := range s.Positive {
+			r.Positive[coarsen(i, shift)] += n
+		}
+		for i, n := range s.Negative {
+			r.Negative[coarsen(i, shift)] += n
+		}
+	}
+	r.collapse()
+	r.summarize()
+	return r
+}
+
+// moments returns the sum and M2 of two sets of values with counts na and nb,
+// using Chan et al.'s parallel formula: M2 = M2a + M2b + delta^2 * na * nb / n,
+// where delta is the difference of their means.
+func moments(na int, suma, m2a *big.Rat, nb int, sumb, m2b *big.Rat) (*big.Rat, *big.Rat) {
+	sum := new(big.Rat).Add(suma, sumb)
+	m2 := new(big.Rat).Add(m2a, m2b)
+	if na == 0 || nb == 0 {
+		return sum, m2
+	}
+	delta := new(big.Rat).Sub(
+		new(big.Rat).Quo(sumb, new(big.Rat).SetInt64(int64(nb))),
+		new(big.Rat).Quo(suma, new(big.Rat).SetInt64(int64(na))),
+	)
+	delta.Mul(delta, delta)
+	delta.Mul(delta, new(big.Rat).SetFrac64(int64(na)*int64(nb), int64(na+nb)))
+	return sum, m2.Add(m2, delta)
+}
+
+// exact returns the exact sum and M2 of the values,
+// which for Stats loaded from JSON are the published ones.
+func (s *Stats) exact() (*big.Rat, *big.Rat) {
+	if s.sum != nil && s.m2 != nil {
+		return s.sum, s.m2
+	}
+	sum, m2 := s.Sum, s.M2
+	if sum == 0 && m2 == 0 && s.Mean != 0 {
+		// Stats from before the sum was kept only have the mean and variance.
+		sum, m2 = s.Mean*float64(s.Count), s.Variance*float64(s.Count)
+	}
+	return rat(sum), rat(m2)
+}
+
+// rat returns the simplest fraction that rounds to v, or 0 if v is infinite or NaN.
+func rat(v float64) *big.Rat {
+	if math.IsInf(v, 0) || math.IsNaN(v) || v == 0 {
+		return new(big.Rat)
+	}
+	// Everything between the midpoints to the neighboring floats rounds to v.
+	x := new(big.Rat).SetFloat64(math.Abs(v))
+	below := new(big.Rat).SetFloat64(math.Nextafter(math.Abs(v), 0))
+	above := new(big.Rat).SetFloat64(math.Nextafter(math.Abs(v), math.Inf(1)))
+	half := big.NewRat(1, 2)
+	lo := below.Mul(below.Add(below, x), half)
+	hi := above.Mul(above.Add(above, x), half)
+	r := simplest(lo, hi)
+	if v < 0 {
+		r.Neg(r)
+	}
+	return r
+}
+
+// simplest returns the fraction with the smallest denominator in [lo, hi], where 0 <= lo <= hi.
+func simplest(lo, hi *big.Rat) *big.Rat {
+	fl := new(big.Int).Quo(lo.Num(), lo.Denom())
+	if lo.IsInt() {
+		return new(big.Rat).SetInt(fl)
+	}
+	next := new(big.Rat).SetInt(new(big.Int).Add(fl, big.NewInt(1)))
+	if next.Cmp(hi) <= 0 {
+		return next
+	}
+	// Both are between fl and fl+1, so the answer is fl + 1/x for the simplest x between their reciprocals.
+	flr := new(big.Rat).SetInt(fl)
+	x := simplest(
+		new(big.Rat).Inv(new(big.Rat).Sub(hi, flr)),
+		new(big.Rat).Inv(new(big.Rat).Sub(lo, flr)),
+	)
+	return x.Add(x.Inv(x), flr)
+}
+
+// index returns the bucket of positive v at level 0.
+func index(v float64) int {
+	return int(math.Ceil(math.Log(v) / math.Log(baseGamma)))
+}
+
+// coarsen returns the bucket holding bucket i after making the buckets coarser by levels.
+// Since ceil(ceil(x/a)/b) == ceil(x/(a*b)), this is the same as bucketing at the coarser level directly.
+func coarsen(i, levels int) int {
+	d := 1 << levels
+	if i <= 0 {
+		// Integer division rounds towards zero, which is up for negative numbers.
+		return i / d
+	}
+	return (i + d - 1) / d
+}
+
+func (s *Stats) collapse() {
+	for len(s.Positive)+len(s.Negative) > MaxBuckets {
+		s.Level++
+		for _, m := range []*map[int]int{&s.Positive, &s.Negative} {
+			coarser := make(map[int]int, len(*m))
+			for i, n := range *m {
+				coarser[coarsen(i, 1)] += n
+			}
+			*m = coarser
+		}
+	}
+}
+
+func (s *Stats) gamma() float64 {
+	return math.Pow(baseGamma, float64(int(1)<<s.Level))
+}
+
+// bucket is a range of values, and the number of values in it.
+// For integer statistics, it is the range of integers in the bucket.
+type bucket struct {
+	lo, hi float64
+	count  int
+}
+
+// mid returns the value used to estimate the values in the bucket,
+// which is off by at most (gamma-1)/(gamma+1) relative to any of them.
+func (b bucket) mid() float64 {
+	return (b.lo + b.hi) / 2
+}
+
+func (b bucket) sample(r *rand.Rand, integer bool) float64 {
+	int63n, float64n := rand.Int63n, rand.Float64
+	if r != nil {
+		int63n, float64n = r.Int63n, r.Float64
+	}
+	if integer && b.hi-b.lo < 1<<52 {
+		return b.lo + float64(int63n(int64(b.hi-b.lo)+1))
+	}
+	v := b.lo + float64n()*(b.hi-b.lo)
+	if integer {
+		return math.Round(v)
+	}
+	return v
+}
+
+// buckets returns the buckets from the smallest to the largest values.
+func (s *Stats) buckets() []bucket {
+	gamma := s.gamma()
+	r := make([]bucket, 0, len(s.Positive)+len(s.Negative)+1)
+	for _, i := range sortedKeys(s.Negative, true) {
+		lo, hi := s.bounds(gamma, i)
+		r = append(r, bucket{lo: -hi, hi: -lo, count: s.Negative[i]})
+	}
+	if s.Zeros > 0 {
+		r = append(r, bucket{count: s.Zeros})
+	}
+	for _, i := range sortedKeys(s.Positive, false) {
+		lo, hi := s.bounds(gamma, i)
+		r = append(r, bucket{lo: lo, hi: hi, count: s.Positive[i]})
+	}
+	return r
+}
+
+// bounds returns the range of absolute values in bucket i.
+func (s *Stats) bounds(gamma float64, i int) (float64, float64) {
+	lo, hi := math.Pow(gamma, float64(i-1)), math.Pow(gamma, float64(i))
+	if s.Integer {
+		// Integers in (lo, hi]. Every bucket with a value has at least one.
+		return math.Floor(lo) + 1, math.Max(math.Floor(hi), math.Floor(lo)+1)
+	}
+	return lo, hi
+}
+
+func sortedKeys(m map[int]int, desc bool) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	if desc {
+		sort.Sort(sort.Reverse(sort.IntSlice(keys)))
+	} else {
+		sort.Ints(keys)
+	}
+	return keys
+}
+
+func (s *Stats) summarize() {
+	s.Sum, _ = s.sum.Float64()
+	s.M2, _ = s.m2.Float64()
+	s.Mean, s.Variance = 0, 0
+	if s.Count == 0 {
+		return
+	}
+	n := new(big.Rat).SetInt64(int64(s.Count))
+	mean, _ := new(big.Rat).Quo(s.sum, n).Float64()
+	variance, _ := new(big.Rat).Quo(s.m2, n).Float64()
+	s.Mean = round(mean)
+	s.Variance = round(variance)
+}
+
+func round(v float64) float64 {
+	r, _ := strconv.ParseFloat(strconv.FormatFloat(v, 'g', 4, 64), 64)
+	return r
+}
+
+// Quantile returns the estimated value below which q (0 to 1) of the values are.
+func (s *Stats) Quantile(q float64) float64 {
+	rank := int(math.Round(q * float64(s.Count-1)))
+	seen := 0
+	for _, b := range s.buckets() {
+		seen += b.count
+		if seen > rank {
+			return b.mid()
+		}
+	}
+	return 0
+}
+
+// Sample returns a random value from the distribution of the values seen,
+// chosen using r, or the math/rand global functions if r is nil.
+func (s *Stats) Sample(r *rand.Rand) float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	intn := rand.Intn
+	if r != nil {
+		intn = r.Intn
+	}
+	rank := intn(s.Count)
+	seen := 0
+	for _, b := range s.buckets() {
+		seen += b.count
+		if seen > rank {
+			return b.sample(r, s.Integer)
+		}
+	}
+	return 0
+}
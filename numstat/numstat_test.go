@@ -0,0 +1,101 @@
+package numstat_test
+
+import (
+	"encoding/json"
+	"github.com/lithictech/moxpopuli/numstat"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"math/rand"
+	"testing"
+)
+
+func TestNumstat(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "numstat Suite")
+}
+
+var _ = Describe("numstat", func() {
+	// Mostly small amounts, with a few huge outliers.
+	amounts := func() []float64 {
+		r := rand.New(rand.NewSource(1))
+		var values []float64
+		for i := 0; i < 1000; i++ {
+			values = append(values, float64(r.Intn(5000)+500))
+		}
+		values = append(values, 0, 0, -250, 1_000_000, 5_000_000)
+		return values
+	}()
+
+	It("estimates the distribution of values", func() {
+		s := numstat.Of(amounts...)
+		Expect(s.Count).To(Equal(1005))
+		Expect(s.Zeros).To(Equal(2))
+		Expect(s.Negatives).To(Equal(1))
+		Expect(len(s.Positive) + len(s.Negative)).To(BeNumerically("<=", numstat.MaxBuckets))
+		Expect(s.Quantile(0.5)).To(BeNumerically("~", 3000, 300))
+		Expect(s.Quantile(0.99)).To(BeNumerically("<", 6000))
+		Expect(s.Mean).To(BeNumerically("~", 8700, 900))
+		Expect(s.Variance).To(BeNumerically(">", 0))
+		Expect(numstat.Of().Quantile(0.5)).To(BeZero())
+	})
+
+	It("keeps the exact mean and variance", func() {
+		s := numstat.Of(10, 10.5)
+		Expect(s.Sum).To(Equal(20.5))
+		Expect(s.M2).To(Equal(0.125))
+		Expect(s.Mean).To(Equal(10.25))
+		Expect(s.Variance).To(Equal(0.0625))
+		merged := numstat.Merge(numstat.Of(0), numstat.Of(10.1))
+		Expect(merged.Mean).To(Equal(5.05))
+		Expect(merged.Variance).To(Equal(25.5))
+
+		// Stats loaded from JSON merge from their published sum and M2.
+		b, err := json.Marshal(numstat.Of(10))
+		Expect(err).ToNot(HaveOccurred())
+		var loaded numstat.Stats
+		Expect(json.Unmarshal(b, &loaded)).To(Succeed())
+		Expect(numstat.Merge(&loaded, numstat.Of(10.5)).Variance).To(Equal(0.0625))
+	})
+
+	It("is the same when merged in any order", func() {
+		all := numstat.Of(amounts...)
+		Expect(numstat.Merge(numstat.Of(amounts[:10]...), numstat.Of(amounts[10:]...))).To(Equal(all))
+		Expect(numstat.Merge(numstat.Of(amounts[600:]...), numstat.Of(amounts[:600]...))).To(Equal(all))
+		Expect(numstat.Merge(nil, all)).To(Equal(all))
+		Expect(numstat.Merge(nil, nil)).To(BeNil())
+	})
+
+	It("is the same when merging stats loaded from JSON", func() {
+		load := func(s *numstat.Stats) *numstat.Stats {
+			b, err := json.Marshal(s)
+			Expect(err).ToNot(HaveOccurred())
+			var loaded numstat.Stats
+			Expect(json.Unmarshal(b, &loaded)).To(Succeed())
+			return &loaded
+		}
+		// The M2 of each part is a fraction that a float can't hold exactly.
+		all := load(numstat.Of(1, 2, 4, 7, 11, 0.1, 3.3))
+		merged := load(numstat.Merge(load(numstat.Of(1, 2, 4, 7, 11, 0.1)), load(numstat.Of(3.3))))
+		Expect(merged.Sum).To(Equal(all.Sum))
+		Expect(merged.M2).To(Equal(all.M2))
+	})
+
+	It("samples values like the ones seen", func() {
+		s := numstat.Of(amounts...)
+		under := 0
+		for i := 0; i < 1000; i++ {
+			if v := s.Sample(nil); v < 10_000 {
+				under++
+			}
+		}
+		Expect(under).To(BeNumerically(">", 950))
+	})
+
+	It("samples the same values from the same random source", func() {
+		s := numstat.Of(amounts...)
+		r1, r2 := rand.New(rand.NewSource(5)), rand.New(rand.NewSource(5))
+		for i := 0; i < 100; i++ {
+			Expect(s.Sample(r1)).To(Equal(s.Sample(r2)))
+		}
+	})
+})
@@ -0,0 +1,344 @@
+// Package openapispec converts the HTTP parts of a Mox Populi AsyncAPI specification
+// into an OpenAPI 3.1 document, describing each channel as a webhook.
+// See https://spec.openapis.org/oas/v3.1.0#oas-webhooks
+package openapispec
+
+import (
+	"github.com/lithictech/moxpopuli/asyncapispec"
+	"github.com/lithictech/moxpopuli/cloudevents"
+	"github.com/lithictech/moxpopuli/internal"
+	"github.com/lithictech/moxpopuli/schema"
+	"github.com/pkg/errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const Version = "3.1.0"
+
+type FromAsyncApiInput struct {
+	// AsyncAPI 2.x spec, as learned by specgen with the 'http' binding.
+	Spec asyncapispec.Specification
+	// If true, keep the 'x-' fields Mox Populi uses for merging and data generation in schemas.
+	// Otherwise, remove them. Provenance fields (see schema.ProvenanceFields) are always removed.
+	KeepExtensions bool
+}
+
+// FromAsyncApi returns an OpenAPI 3.1 document with a webhook for each channel with an 'http' binding.
+// Channels are named for their address, like '/my/webhook'.
+//
+// Query parameters, headers, and request bodies come from the learned schemas.
+// Operations have a generic 2XX response, since Mox Populi does not learn responses.
+// Request bodies of structured-mode CloudEvents (see cloudevents.StructuredContentType)
+// describe the whole envelope, with the learned payload as its 'data'.
+// Security requirements of all servers are added to each operation.
+func FromAsyncApi(in FromAsyncApiInput) (map[string]interface{}, error) {
+	// Schemas may be typed in-memory (like schema.Schema), so use plain JSON values throughout.
+	spec, err := internal.ToPlainMap(in.Spec)
+	if err != nil {
+		return nil, errors.Wrap(err, "converting spec to plain map")
+	}
+	c := converter{spec: asyncapispec.Specification(spec), keepExtensions: in.KeepExtensions}
+	doc := map[string]interface{}{
+		"openapi": Version,
+		"info":    map[string]interface{}{"title": "Webhooks", "version": "1.0.0"},
+	}
+	if info, ok := spec["info"].(map[string]interface{}); ok {
+		doc["info"] = info
+	}
+	security := c.security(asyncapispec.Specification(spec), doc)
+	channels, _ := spec["channels"].(map[string]interface{})
+	webhooks := make(map[string]interface{}, len(channels))
+	for address, chv := range channels {
+		op, ok := chv.(map[string]interface{})["subscribe"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, ok := asyncapispec.Operation(op).GetOrAddBindings()["http"]; !ok {
+			continue
+		}
+		method := strings.ToLower(asyncapispec.Operation(op).GetOrAddBindings().GetOrAddHttp().Method())
+		if method == "" {
+			method = "post"
+		}
+		operation := c.operation(address, method, op)
+		if len(security) > 0 {
+			operation["security"] = security
+		}
+		webhooks[address] = map[string]interface{}{method: operation}
+	}
+	doc["webhooks"] = webhooks
+	return doc, nil
+}
+
+type converter struct {
+	spec           asyncapispec.Specification
+	keepExtensions bool
+}
+
+func (c converter) operation(address, method string, op map[string]interface{}) map[string]interface{} {
+	operation := map[string]interface{}{
+		"operationId": method + strings.ReplaceAll(address, "/", "_"),
+		"responses": map[string]interface{}{
+			"2XX": map[string]interface{}{"description": "Return a 2XX status to indicate the webhook was received."},
+		},
+	}
+	parameters := make([]interface{}, 0, 4)
+	httpBinding := asyncapispec.Operation(op).GetOrAddBindings().GetOrAddHttp()
+	if query, ok := httpBinding["query"].(map[string]interface{}); ok {
+		parameters = append(parameters, c.parameters("query", query, nil)...)
+	}
+	messages := asyncapispec.Operation(op).Messages()
+	discriminator, hasDiscriminator := asyncapispec.Operation(op).Discriminator()
+	names := make([]interface{}, 0, len(messages))
+	for _, msg := range messages {
+		if msg.Name() != "" {
+			names = append(names, msg.Name())
+		}
+	}
+	seenHeaders := make(map[string]struct{}, 4)
+	for _, msg := range messages {
+		headers, ok := msg["headers"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var discriminatorHeader string
+		if hasDiscriminator {
+			discriminatorHeader = discriminator.Header
+		}
+		for _, p := range c.parameters("header", headers, seenHeaders) {
+			param := p.(map[string]interface{})
+			if param["name"] == discriminatorHeader && len(names) > 0 {
+				param["schema"] = map[string]interface{}{"type": "string", "enum": names}
+			}
+			parameters = append(parameters, param)
+		}
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+	if body := c.requestBody(messages, discriminator); body != nil {
+		operation["requestBody"] = body
+	}
+	return operation
+}
+
+// parameters returns a parameter for each property of the object schema.
+// If seen is not nil, properties in it are skipped, and new properties are added.
+func (c converter) parameters(in string, objectSchema map[string]interface{}, seen map[string]struct{}) []interface{} {
+	props, _ := objectSchema["properties"].(map[string]interface{})
+	r := make([]interface{}, 0, len(props))
+	for _, name := range sortedKeys(props) {
+		if seen != nil {
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+		}
+		r = append(r, map[string]interface{}{
+			"name":   name,
+			"in":     in,
+			"schema": c.schema(props[name]),
+		})
+	}
+	return r
+}
+
+func (c converter) requestBody(messages []asyncapispec.Message, discriminator asyncapispec.Discriminator) map[string]interface{} {
+	payloadsByContentType := make(map[string][]interface{}, 1)
+	examplesByContentType := make(map[string]map[string]interface{}, 1)
+	for _, msg := range messages {
+		payload, ok := msg["payload"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		ct := msg.ContentType()
+		if ct == "" {
+			ct = "application/json"
+		}
+		sch := c.schema(payload).(map[string]interface{})
+		envelope := ct == cloudevents.StructuredContentType
+		if envelope {
+			sch = c.cloudEventEnvelope(msg, sch)
+		}
+		if msg.Name() != "" {
+			sch["title"] = msg.Name()
+		}
+		payloadsByContentType[ct] = append(payloadsByContentType[ct], sch)
+		if envelope {
+			// Examples only have the data, which doesn't match the envelope schema.
+			continue
+		}
+		for i, example := range msg.Examples() {
+			if _, ok := examplesByContentType[ct]; !ok {
+				examplesByContentType[ct] = make(map[string]interface{}, 2)
+			}
+			name := msg.Name()
+			if name == "" {
+				name = "example"
+			}
+			examplesByContentType[ct][name+"-"+strconv.Itoa(i+1)] = map[string]interface{}{"value": example.Payload()}
+		}
+	}
+	if len(payloadsByContentType) == 0 {
+		return nil
+	}
+	content := make(map[string]interface{}, len(payloadsByContentType))
+	for ct, payloads := range payloadsByContentType {
+		var sch map[string]interface{}
+		if len(payloads) == 1 {
+			sch = payloads[0].(map[string]interface{})
+		} else {
+			sch = map[string]interface{}{"oneOf": payloads}
+			if len(discriminator.Path) == 1 {
+				sch["discriminator"] = map[string]interface{}{"propertyName": discriminator.Path[0]}
+			}
+		}
+		media := map[string]interface{}{"schema": sch}
+		if examples, ok := examplesByContentType[ct]; ok {
+			media["examples"] = examples
+		}
+		content[ct] = media
+	}
+	return map[string]interface{}{"required": true, "content": content}
+}
+
+// cloudEventEnvelope returns the schema of a structured-mode CloudEvent body,
+// with the message's payload as 'data' (or base64-encoded in 'data_base64').
+// The envelope attributes come from the message's CloudEvents trait, if it has one.
+func (c converter) cloudEventEnvelope(msg asyncapispec.Message, data map[string]interface{}) map[string]interface{} {
+	props := map[string]interface{}{
+		"specversion": map[string]interface{}{"type": "string", "const": cloudevents.SpecVersion},
+		"source":      map[string]interface{}{"type": "string", "format": "uri-reference"},
+		"id":          map[string]interface{}{"type": "string"},
+	}
+	for _, ref := range msg.TraitRefs() {
+		trait, ok := c.spec.ResolveMessageTrait(ref)
+		if !ok || trait[cloudevents.TraitModeField] != cloudevents.MODE_STRUCTURED {
+			continue
+		}
+		envelope, _ := c.schema(trait[cloudevents.TraitEnvelopeField]).(map[string]interface{})
+		attrs, _ := envelope["properties"].(map[string]interface{})
+		for name, attr := range attrs {
+			if _, ok := props[name]; !ok {
+				props[name] = attr
+			}
+		}
+	}
+	typ := map[string]interface{}{"type": "string"}
+	if msg.Name() != "" {
+		typ["const"] = msg.Name()
+	}
+	props["type"] = typ
+	if dataBase64, _ := msg[cloudevents.MessageDataBase64Field].(bool); dataBase64 {
+		props["data_base64"] = map[string]interface{}{"type": "string", "contentEncoding": "base64", "contentSchema": data}
+	} else {
+		props["data"] = data
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"required":   []interface{}{"id", "source", "specversion", "type"},
+		"properties": props,
+	}
+}
+
+// schema returns a copy of the schema, with 'x-' fields removed unless they are being kept.
+// Provenance fields are always removed, since sources can identify individual payloads.
+// Nullable schemas use a type array, like ["string", "null"], as in JSON Schema 2020-12.
+func (c converter) schema(v interface{}) interface{} {
+	s, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+	r := make(map[string]interface{}, len(s))
+	for k, v := range s {
+		switch {
+		case k == "properties":
+			props := v.(map[string]interface{})
+			rprops := make(map[string]interface{}, len(props))
+			for name, prop := range props {
+				rprops[name] = c.schema(prop)
+			}
+			r[k] = rprops
+		case k == "items" || k == "contentSchema":
+			r[k] = c.schema(v)
+		case k == "oneOf" || k == "anyOf" || k == "allOf":
+			subs, _ := v.([]interface{})
+			rsubs := make([]interface{}, len(subs))
+			for i, sub := range subs {
+				rsubs[i] = c.schema(sub)
+			}
+			r[k] = rsubs
+		case strings.HasPrefix(k, "x-") && !c.keepExtensions:
+		case provenanceFields[k]:
+		default:
+			r[k] = v
+		}
+	}
+	if nullable, _ := s["x-nullable"].(bool); nullable {
+		if t, ok := s["type"].(string); ok {
+			r["type"] = []interface{}{t, "null"}
+		}
+	}
+	return r
+}
+
+// security adds components.securitySchemes to the doc,
+// and returns the security requirements for operations.
+func (c converter) security(spec asyncapispec.Specification, doc map[string]interface{}) []interface{} {
+	servers, _ := spec["servers"].(map[string]interface{})
+	names := make(map[string]struct{}, 2)
+	for _, srv := range servers {
+		for _, name := range asyncapispec.Server(srv.(map[string]interface{})).SecurityRequirements() {
+			names[name] = struct{}{}
+		}
+	}
+	schemes := make(map[string]interface{}, len(names))
+	requirements := make([]interface{}, 0, len(names))
+	for _, name := range sortedKeys(names) {
+		scheme, ok := spec.ResolveSecurityScheme(name)
+		if !ok {
+			continue
+		}
+		var oscheme map[string]interface{}
+		switch scheme.Type() {
+		case "http":
+			oscheme = map[string]interface{}{"type": "http", "scheme": scheme.Scheme()}
+			if f, ok := scheme["bearerFormat"]; ok {
+				oscheme["bearerFormat"] = f
+			}
+		case "httpApiKey":
+			oscheme = map[string]interface{}{"type": "apiKey", "name": scheme.Name(), "in": scheme.In()}
+		default:
+			continue
+		}
+		for k, v := range scheme {
+			if k == "description" || (strings.HasPrefix(k, "x-") && c.keepExtensions) {
+				oscheme[k] = v
+			}
+		}
+		schemes[name] = oscheme
+		requirements = append(requirements, map[string]interface{}{name: []interface{}{}})
+	}
+	if len(schemes) > 0 {
+		doc["components"] = map[string]interface{}{"securitySchemes": schemes}
+	}
+	return requirements
+}
+
+var provenanceFields = func() map[string]bool {
+	r := make(map[string]bool, len(schema.ProvenanceFields))
+	for _, f := range schema.ProvenanceFields {
+		r[string(f)] = true
+	}
+	return r
+}()
+
+func sortedKeys[V interface{}](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/lithictech/moxpopuli/internal"
 	"math/rand"
 	"regexp"
+	"strings"
 	"sync"
 )
 
@@ -86,7 +87,7 @@ func UnsafeVariableHash(data, salt []byte) string {
 	h.Write(data)
 	h.Write(salt)
 	sum := h.Sum(nil)
-	s := unsafeBase64.EncodeToString(sum)
+	s := unsafeBase64Replacer.Replace(base64.RawStdEncoding.EncodeToString(sum))
 	if len(data) == len(s) {
 		return s
 	}
@@ -97,4 +98,48 @@ func UnsafeVariableHash(data, salt []byte) string {
 	return s2
 }
 
-var unsafeBase64 = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789ab").WithPadding(base64.NoPadding)
+// The hash only needs to look like a token, not be decodable,
+// so swap the URL-unfriendly characters out for letters.
+// base64.NewEncoding refuses alphabets with duplicate symbols, so do this as a replacement.
+var unsafeBase64Replacer = strings.NewReplacer("+", "a", "/", "b")
+
+// IsCredentialKey returns true if values for the key always carry credentials,
+// so must always be redacted regardless of what they look like.
+// Keys are compared without case or punctuation, so 'Proxy-Authorization' and 'proxyauthorization' are the same.
+func IsCredentialKey(key string) bool {
+	_, ok := credentialKeys[credentialKey(key)]
+	return ok
+}
+
+func credentialKey(key string) string {
+	return credentialKeyReplacement.ReplaceAllString(strings.ToLower(key), "")
+}
+
+var credentialKeys = map[string]struct{}{
+	"authorization":      {},
+	"proxyauthorization": {},
+	"cookie":             {},
+	"setcookie":          {},
+}
+
+var credentialKeyReplacement = regexp.MustCompile("[^a-z0-9]+")
+
+// Credential hashes the secret parts of a credential-bearing value (see IsCredentialKey),
+// while keeping its structure, like the scheme in 'Bearer abc123' or the cookie names in 'a=1; b=2'.
+func Credential(key, v string, salt []byte) string {
+	if k := credentialKey(key); k == "cookie" || k == "setcookie" {
+		pairs := strings.Split(v, ";")
+		for i, pair := range pairs {
+			if name, value, ok := strings.Cut(pair, "="); ok {
+				pairs[i] = name + "=" + UnsafeVariableHash([]byte(value), salt)
+			}
+		}
+		return strings.Join(pairs, ";")
+	}
+	if scheme, credentials, ok := strings.Cut(v, " "); ok && authSchemeRegex.MatchString(scheme) {
+		return scheme + " " + UnsafeVariableHash([]byte(credentials), salt)
+	}
+	return UnsafeVariableHash([]byte(v), salt)
+}
+
+var authSchemeRegex = regexp.MustCompile("^[A-Za-z][A-Za-z0-9-]*$")
@@ -0,0 +1,194 @@
+package schema
+
+import (
+	"fmt"
+	"github.com/lithictech/moxpopuli/internal"
+	"time"
+)
+
+// Provenance is when, and optionally where, a payload was seen.
+type Provenance struct {
+	At time.Time
+	// Identifies the payload, like 'payloads.jsonl:12' or an event id.
+	Source string
+}
+
+// Before returns true if p sorts before other, by time and then source,
+// so the earliest and latest of the same provenances do not depend on merge order.
+func (p Provenance) Before(other Provenance) bool {
+	if p.At.Equal(other.At) {
+		return p.Source < other.Source
+	}
+	return p.At.Before(other.At)
+}
+
+// ProvenanceFields are the fields recording provenance.
+// They are only meaningful to Mox Populi, so are removed from exported documents.
+var ProvenanceFields = []Field{
+	PX_FIRST_SEEN, PX_FIRST_SOURCE, PX_LAST_SEEN, PX_LAST_SOURCE,
+	PX_ENUM_LAST_SEEN, PX_URI_LOCATIONS_LAST_SEEN, PX_SEEN_WINDOWS,
+}
+
+// SeenRangeFields are the fields of a seen range, which are also recorded for each window in PX_SEEN_WINDOWS.
+var SeenRangeFields = []Field{PX_SEEN_MINIMUM, PX_SEEN_MAXIMUM, PX_SEEN_MIN_LENGTH, PX_SEEN_MAX_LENGTH}
+
+// WindowCountFields are the counts recorded for each window in PX_SEEN_WINDOWS, along with the seen range,
+// so they can be rebuilt from recent windows.
+var WindowCountFields = []Field{PX_SAMPLES, PX_NULLS, PX_STATS, PX_LENGTH_STATS}
+
+// Seen ranges and counts are recorded for each UTC day, keyed by the date (like '2022-01-31').
+// So the windows don't grow without limit, only the latest MaxDayWindows days are kept as days,
+// and older days are rolled into a window for their month (like '2022-01').
+// Likewise, only the latest MaxMonthWindows months are kept, and older months are rolled into their year (like '2022').
+const (
+	WindowLayout      = TF_DATE
+	MonthWindowLayout = "2006-01"
+	YearWindowLayout  = "2006"
+	MaxDayWindows     = 31
+	MaxMonthWindows   = 24
+)
+
+// WindowEnd returns when the window with the given key (a day, month, or year) ends,
+// and false if the key is not a window.
+func WindowEnd(key string) (time.Time, bool) {
+	for _, w := range []struct {
+		layout     string
+		y, m, days int
+	}{{WindowLayout, 0, 0, 1}, {MonthWindowLayout, 0, 1, 0}, {YearWindowLayout, 1, 0, 0}} {
+		if len(key) != len(w.layout) {
+			continue
+		}
+		start, err := time.Parse(w.layout, key)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return start.AddDate(w.y, w.m, w.days), true
+	}
+	return time.Time{}, false
+}
+
+// FirstSeen returns when and where the schema was first seen, if recorded.
+func (s Schema) FirstSeen() (Provenance, bool) {
+	return s.provenance(PX_FIRST_SEEN, PX_FIRST_SOURCE)
+}
+
+// LastSeen returns when and where the schema was last seen, if recorded.
+func (s Schema) LastSeen() (Provenance, bool) {
+	return s.provenance(PX_LAST_SEEN, PX_LAST_SOURCE)
+}
+
+func (s Schema) SetFirstSeen(p Provenance) {
+	s.setProvenance(PX_FIRST_SEEN, PX_FIRST_SOURCE, p)
+}
+
+func (s Schema) SetLastSeen(p Provenance) {
+	s.setProvenance(PX_LAST_SEEN, PX_LAST_SOURCE, p)
+}
+
+func (s Schema) provenance(atField, sourceField Field) (Provenance, bool) {
+	at, ok := s[atField].(string)
+	if !ok {
+		return Provenance{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return Provenance{}, false
+	}
+	source, _ := s[sourceField].(string)
+	return Provenance{At: t, Source: source}, true
+}
+
+func (s Schema) setProvenance(atField, sourceField Field, p Provenance) {
+	s[atField] = p.At.UTC().Format(time.RFC3339Nano)
+	if p.Source == "" {
+		delete(s, sourceField)
+	} else {
+		s[sourceField] = p.Source
+	}
+}
+
+// SeenWindows returns the seen range and counts of each window the schema was seen in, by the window's date.
+func (s Schema) SeenWindows() map[string]Schema {
+	switch w := s[PX_SEEN_WINDOWS].(type) {
+	case map[string]Schema:
+		return w
+	case map[string]interface{}:
+		r := make(map[string]Schema, len(w))
+		for k, v := range w {
+			r[k] = Coerce(v)
+		}
+		return r
+	}
+	return nil
+}
+
+// ValuesLastSeen returns when each value was last seen, for f of PX_ENUM_LAST_SEEN
+// (for the strings in P_ENUM and PX_SEEN_STRINGS) or PX_URI_LOCATIONS_LAST_SEEN.
+func (s Schema) ValuesLastSeen(f Field) map[string]time.Time {
+	r := make(map[string]time.Time)
+	switch m := s[f].(type) {
+	case map[string]string:
+		for k, v := range m {
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				r[k] = t
+			}
+		}
+	case map[string]interface{}:
+		for k, v := range m {
+			if t, err := time.Parse(time.RFC3339Nano, fmt.Sprintf("%v", v)); err == nil {
+				r[k] = t
+			}
+		}
+	}
+	return r
+}
+
+// SetValuesLastSeen sets when each value was last seen (see ValuesLastSeen), or deletes f if there are none.
+func (s Schema) SetValuesLastSeen(f Field, m map[string]time.Time) {
+	if len(m) == 0 {
+		delete(s, f)
+		return
+	}
+	r := make(map[string]string, len(m))
+	for k, t := range m {
+		r[k] = t.UTC().Format(time.RFC3339Nano)
+	}
+	s[f] = r
+}
+
+// Stamp records p as when the schema, and all of its subschemas, were first and last seen.
+// The seen range and counts are recorded as those of p's window, and each seen string and URI location
+// as last seen at p. Use it on newly derived schemas, before merging them.
+func (s Schema) Stamp(p Provenance) {
+	s.SetFirstSeen(p)
+	s.SetLastSeen(p)
+	window := Schema{}
+	for _, f := range append(append([]Field{}, SeenRangeFields...), WindowCountFields...) {
+		if v, ok := s[f]; ok {
+			window[f] = v
+		}
+	}
+	// Newly derived schemas have no samples, but count as one when merged.
+	window[PX_SAMPLES] = internal.MaxInt(s.Samples(), 1)
+	s[PX_SEEN_WINDOWS] = map[string]Schema{p.At.UTC().Format(WindowLayout): window}
+	stampValues := func(f Field, values []string) {
+		m := make(map[string]time.Time, len(values))
+		for _, v := range values {
+			m[v] = p.At
+		}
+		s.SetValuesLastSeen(f, m)
+	}
+	stampValues(PX_ENUM_LAST_SEEN, StringSchema(s).SeenStrings())
+	stampValues(PX_URI_LOCATIONS_LAST_SEEN, StringSchema(s).SeenUriLocations())
+	if props, ok := s[P_PROPERTIES].(map[string]Schema); ok {
+		for _, prop := range props {
+			prop.Stamp(p)
+		}
+	}
+	if items, ok := s[P_ITEMS].(Schema); ok && len(items) > 0 {
+		items.Stamp(p)
+	}
+	if content, ok := s[P_CONTENT_SCHEMA].(Schema); ok {
+		content.Stamp(p)
+	}
+}
@@ -6,16 +6,19 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"github.com/lithictech/moxpopuli/hll"
 	"github.com/lithictech/moxpopuli/internal"
 	"github.com/lithictech/moxpopuli/jsonformat"
 	"github.com/lithictech/moxpopuli/jsontype"
 	"github.com/lithictech/moxpopuli/moxio"
 	"github.com/lithictech/moxpopuli/moxjson"
+	"github.com/lithictech/moxpopuli/numstat"
 	"github.com/lithictech/moxpopuli/redact"
 	"io"
 	"net/url"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -24,29 +27,44 @@ type Field string
 
 //goland:noinspection GoSnakeCaseUsage
 const (
-	P_ENUM       Field = "enum"
-	P_EXAMPLES   Field = "examples"
-	P_FORMAT     Field = "format"
-	P_ITEMS      Field = "items"
-	P_MINIMUM    Field = "minimum"
-	P_MIN_LENGTH Field = "minLength"
-	P_MAXIMUM    Field = "maximum"
-	P_MAX_LENGTH Field = "maxLength"
-	P_ONE_OF     Field = "oneOf"
-	P_PROPERTIES Field = "properties"
-	P_TYPE       Field = "type"
-
-	PX_IDENTIFIER      Field = "x-identifier"
-	PX_NULLABLE        Field = "x-nullable"
-	PX_LAST_VALUE      Field = "x-lastValue"
-	PX_SAMPLES         Field = "x-samples"
-	PX_SEEN_MINIMUM    Field = "x-seenMinimum"
-	PX_SEEN_MAXIMUM    Field = "x-seenMaximum"
-	PX_SEEN_MIN_LENGTH Field = "x-seenMinLength"
-	PX_SEEN_MAX_LENGTH Field = "x-seenMaxLength"
-	PX_SEEN_STRINGS    Field = "x-seenStrings"
-	PX_SENSITIVE       Field = "x-sensitive"
-	PX_URI_LOCATIONS   Field = "x-uriLocations"
+	P_CONTENT_MEDIA_TYPE Field = "contentMediaType"
+	P_CONTENT_SCHEMA     Field = "contentSchema"
+	P_ENUM               Field = "enum"
+	P_EXAMPLES           Field = "examples"
+	P_FORMAT             Field = "format"
+	P_ITEMS              Field = "items"
+	P_MINIMUM            Field = "minimum"
+	P_MIN_LENGTH         Field = "minLength"
+	P_MAXIMUM            Field = "maximum"
+	P_MAX_LENGTH         Field = "maxLength"
+	P_ONE_OF             Field = "oneOf"
+	P_PROPERTIES         Field = "properties"
+	P_TYPE               Field = "type"
+
+	PX_DISTINCT_STRINGS        Field = "x-distinctStrings"
+	PX_ENUM_LAST_SEEN          Field = "x-enumLastSeen"
+	PX_FIRST_SEEN              Field = "x-firstSeen"
+	PX_FIRST_SOURCE            Field = "x-firstSource"
+	PX_IDENTIFIER              Field = "x-identifier"
+	PX_LAST_SEEN               Field = "x-lastSeen"
+	PX_LAST_SOURCE             Field = "x-lastSource"
+	PX_NULLABLE                Field = "x-nullable"
+	PX_NULLS                   Field = "x-nulls"
+	PX_LENGTH_STATS            Field = "x-lengthStats"
+	PX_LAST_VALUE              Field = "x-lastValue" // Deprecated: Only used to remove raw values stored by older specs.
+	PX_SAMPLES                 Field = "x-samples"
+	PX_SEEN_MINIMUM            Field = "x-seenMinimum"
+	PX_SEEN_MAXIMUM            Field = "x-seenMaximum"
+	PX_SEEN_MIN_LENGTH         Field = "x-seenMinLength"
+	PX_SEEN_MAX_LENGTH         Field = "x-seenMaxLength"
+	PX_SEEN_STRINGS            Field = "x-seenStrings"
+	PX_SEEN_STRINGS_SKETCH     Field = "x-seenStringsSketch"
+	PX_SEEN_WINDOWS            Field = "x-seenWindows"
+	PX_SENSITIVE               Field = "x-sensitive"
+	PX_STATS                   Field = "x-stats"
+	PX_URI_LOCATIONS           Field = "x-uriLocations"
+	PX_URI_LOCATIONS_LAST_SEEN Field = "x-uriLocationsLastSeen"
+	PX_VALUE_COUNTS            Field = "x-valueCounts"
 )
 
 type Schema map[Field]interface{}
@@ -108,6 +126,20 @@ func CoerceSlice(i interface{}) []Schema {
 	return r
 }
 
+// ToFloat returns the float value of a numeric schema field, like x-seenMinimum,
+// which is an int or int64 when merged in memory and a float64 when loaded from JSON.
+func ToFloat(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case int:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	return 0, false
+}
+
 func (s Schema) Type() jsontype.JsonType {
 	if i, ok := s[P_TYPE]; !ok {
 		return jsontype.T_NOTYPE
@@ -140,9 +172,11 @@ func (s Schema) DeepClone() Schema {
 	return c
 }
 
+// NullOnly returns true if the schema was only seen as null.
+// A nullable oneOf has no type, but is not null-only.
 func (s Schema) NullOnly() bool {
-	return s.Nullable() && s.Type() == jsontype.T_NOTYPE
-
+	_, oneOf := s[P_ONE_OF]
+	return s.Nullable() && s.Type() == jsontype.T_NOTYPE && !oneOf
 }
 
 func (s Schema) Nullable() bool {
@@ -153,6 +187,16 @@ func (s Schema) Nullable() bool {
 	return x.(bool)
 }
 
+// Nulls returns how many times the schema was seen as null.
+// Samples include nulls, except for oneOf schemas, which have no samples of their own,
+// so the nulls of a oneOf are not included in the samples of its schemas.
+func (s Schema) Nulls() int {
+	if _, ok := s[PX_NULLS]; !ok {
+		return 0
+	}
+	return *unwrapIntPtr(s, PX_NULLS)
+}
+
 func (s Schema) Samples() int {
 	if _, ok := s[PX_SAMPLES]; !ok {
 		return 0
@@ -160,6 +204,54 @@ func (s Schema) Samples() int {
 	return *unwrapIntPtr(s, PX_SAMPLES)
 }
 
+// ValueCounts returns how many times each value was seen, for the strings in P_ENUM and PX_SEEN_STRINGS,
+// or for booleans ("true" and "false").
+func (s Schema) ValueCounts() map[string]int {
+	r := make(map[string]int)
+	switch m := s[PX_VALUE_COUNTS].(type) {
+	case map[string]int:
+		for k, v := range m {
+			r[k] = v
+		}
+	case map[string]interface{}:
+		for k, v := range m {
+			if n, ok := v.(float64); ok {
+				r[k] = int(n)
+			}
+		}
+	}
+	return r
+}
+
+// SetValueCounts sets the value counts to m, or removes them if m is empty.
+func (s Schema) SetValueCounts(m map[string]int) {
+	if len(m) == 0 {
+		delete(s, PX_VALUE_COUNTS)
+	} else {
+		s[PX_VALUE_COUNTS] = m
+	}
+}
+
+// Stats returns the statistics in f, which is PX_STATS (for the values of integers and numbers)
+// or PX_LENGTH_STATS (for the lengths of strings and arrays), or nil if there are none.
+func (s Schema) Stats(f Field) *numstat.Stats {
+	switch x := s[f].(type) {
+	case *numstat.Stats:
+		return x
+	case map[string]interface{}:
+		b, err := json.Marshal(x)
+		if err != nil {
+			return nil
+		}
+		var st numstat.Stats
+		if err := json.Unmarshal(b, &st); err != nil {
+			return nil
+		}
+		return &st
+	}
+	return nil
+}
+
 func (s Schema) IncrSamples() {
 	s[PX_SAMPLES] = s.Samples() + 1
 }
@@ -278,6 +370,14 @@ func (s StringSchema) SeenStrings() []string {
 	return internal.SliceIToStr(e)
 }
 
+// SeenStringsSketch returns the sketch of seen strings,
+// used instead of PX_SEEN_STRINGS once there are too many to keep, or nil.
+func (s StringSchema) SeenStringsSketch() hll.Sketch {
+	x, _ := s[PX_SEEN_STRINGS_SKETCH].(string)
+	sk, _ := hll.Parse(x)
+	return sk
+}
+
 func (s StringSchema) SeenMinLength() *int {
 	return unwrapIntPtr(Schema(s), PX_SEEN_MIN_LENGTH)
 }
@@ -300,6 +400,16 @@ func (s StringSchema) SeenUriLocations() []string {
 	return internal.SliceIToStr(e)
 }
 
+// ContentSchema returns the schema of the JSON document encoded in the string,
+// or nil if the string does not have the json format.
+func (s StringSchema) ContentSchema() Schema {
+	x, ok := s[P_CONTENT_SCHEMA]
+	if !ok {
+		return nil
+	}
+	return Coerce(x)
+}
+
 func (s StringSchema) Sensitive() bool {
 	x, ok := s[PX_SENSITIVE].(bool)
 	if !ok {
@@ -376,13 +486,13 @@ func maybeString(i string, ok bool) *string {
 
 func Derive(key string, o interface{}) Schema {
 	if o == nil {
-		return Schema{PX_NULLABLE: true}
+		return Schema{PX_NULLABLE: true, PX_NULLS: 1}
 	}
 	o = internal.CoerceToLikelyGoType(o)
 	t := jsontype.Sniff(o)
 	switch t {
 	case jsontype.T_BOOLEAN:
-		return Schema{P_TYPE: jsontype.T_BOOLEAN}
+		return Schema{P_TYPE: jsontype.T_BOOLEAN, PX_VALUE_COUNTS: map[string]int{strconv.FormatBool(o.(bool)): 1}}
 	case jsontype.T_NUMBER:
 		return deriveNumber(o.(float64))
 	case jsontype.T_INTEGER:
@@ -407,6 +517,7 @@ func deriveNumber(v float64) Schema {
 	}
 	s[PX_SEEN_MINIMUM] = v
 	s[PX_SEEN_MAXIMUM] = v
+	s[PX_STATS] = numstat.Of(v)
 	return s
 }
 
@@ -419,11 +530,18 @@ func deriveInteger(v int) Schema {
 	// Record these in all cases, in case we need to coerce ZERO_ONE to a number.
 	s[PX_SEEN_MINIMUM] = v
 	s[PX_SEEN_MAXIMUM] = v
+	s[PX_STATS] = numstat.Of(float64(v))
 	return s
 }
 
 func deriveString(k, v string) Schema {
 	f := jsonformat.Sniff(jsontype.T_STRING, v)
+	if f == jsonformat.F_JSON && !sensitiveKey(k) {
+		if s, ok := deriveJsonString(k, v); ok {
+			return s
+		}
+		f = jsonformat.F_NOFORMAT
+	}
 	s := Schema{P_TYPE: jsontype.T_STRING}
 	if sens, ok := sensitive(f, k, v); ok {
 		// If our string is sensitive, do NOT analyze sensitive content.
@@ -433,6 +551,7 @@ func deriveString(k, v string) Schema {
 	}
 	// Record the value so we can handle it when merging
 	s[PX_SEEN_STRINGS] = []string{v}
+	s[PX_VALUE_COUNTS] = map[string]int{v: 1}
 	if f != jsonformat.F_NOFORMAT {
 		s[P_FORMAT] = f
 	}
@@ -457,23 +576,44 @@ func deriveString(k, v string) Schema {
 		// Normally we don't care about this, but we may need to merge into a string.
 		s[PX_SEEN_MIN_LENGTH] = len(v)
 		s[PX_SEEN_MAX_LENGTH] = len(v)
+		s[PX_LENGTH_STATS] = numstat.Of(float64(len(v)))
 	} else {
 		s[PX_SEEN_MIN_LENGTH] = len(v)
 		s[PX_SEEN_MAX_LENGTH] = len(v)
+		s[PX_LENGTH_STATS] = numstat.Of(float64(len(v)))
 	}
 	return s
 }
 
+// deriveJsonString derives a schema for a string holding an encoded JSON document,
+// like '{"order_id": 5}'. Rather than keeping the string,
+// the schema of the decoded document is kept in P_CONTENT_SCHEMA.
+// Values in the document are redacted according to their own keys.
+// It returns false if the document cannot be decoded, so it can be kept as a plain string.
+func deriveJsonString(k, v string) (Schema, bool) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(v), &doc); err != nil {
+		return nil, false
+	}
+	return Schema{
+		P_TYPE:               jsontype.T_STRING,
+		P_FORMAT:             jsonformat.F_JSON,
+		P_CONTENT_MEDIA_TYPE: "application/json",
+		P_CONTENT_SCHEMA:     Derive(k, doc),
+		PX_SEEN_MIN_LENGTH:   len(v),
+		PX_SEEN_MAX_LENGTH:   len(v),
+		PX_LENGTH_STATS:      numstat.Of(float64(len(v))),
+	}, true
+}
+
 func sensitive(f jsonformat.JsonFormat, k, v string) (string, bool) {
+	if redact.IsCredentialKey(k) {
+		return redact.Credential(k, v, []byte(SensitiveSalt)), true
+	}
 	if jsonformat.IsChronolike(f) {
 		return "", false
 	}
-	canonical := canonicalKey(k)
-	shouldRedact := strings.HasSuffix(canonical, "token") ||
-		strings.HasSuffix(canonical, "code") ||
-		strings.HasSuffix(canonical, "secret") ||
-		strings.HasSuffix(canonical, "digest")
-	if shouldRedact {
+	if sensitiveKey(k) {
 		return redact.Zero(v), true
 	}
 	if len(v) < 8 {
@@ -497,6 +637,53 @@ func sensitive(f jsonformat.JsonFormat, k, v string) (string, bool) {
 	return "", false
 }
 
+// Redact returns a copy of o with any sensitive strings redacted,
+// using the same rules as when deriving a schema.
+// Use it before storing raw values, like examples.
+func Redact(key string, o interface{}) interface{} {
+	switch v := o.(type) {
+	case string:
+		f := jsonformat.Sniff(jsontype.T_STRING, v)
+		if f == jsonformat.F_JSON && !sensitiveKey(key) {
+			if r, ok := redactJsonString(key, v); ok {
+				return r
+			}
+			f = jsonformat.F_NOFORMAT
+		}
+		if sens, ok := sensitive(f, key, v); ok {
+			return sens
+		}
+		return v
+	case map[string]interface{}:
+		r := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			r[k] = Redact(k, item)
+		}
+		return r
+	case []interface{}:
+		r := make([]interface{}, len(v))
+		for i, item := range v {
+			r[i] = Redact(key, item)
+		}
+		return r
+	}
+	return o
+}
+
+// redactJsonString redacts the document encoded in v, and encodes it again.
+// It returns false if the document cannot be decoded or encoded, so it can be redacted as a plain string.
+func redactJsonString(key, v string) (string, bool) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(v), &doc); err != nil {
+		return "", false
+	}
+	b, err := json.Marshal(Redact(key, doc))
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
+
 var SensitiveSalt string
 
 func init() {
@@ -511,6 +698,18 @@ func init() {
 	}
 }
 
+// sensitiveKey returns true if the key name alone means its values are sensitive.
+func sensitiveKey(k string) bool {
+	if redact.IsCredentialKey(k) {
+		return true
+	}
+	canonical := canonicalKey(k)
+	return strings.HasSuffix(canonical, "token") ||
+		strings.HasSuffix(canonical, "code") ||
+		strings.HasSuffix(canonical, "secret") ||
+		strings.HasSuffix(canonical, "digest")
+}
+
 func canonicalKey(s string) string {
 	return strings.ToLower(canonicalReplacement.ReplaceAllString(s, ""))
 }
@@ -540,6 +739,7 @@ func deriveArray(k string, v []interface{}) Schema {
 	}
 	s[PX_SEEN_MIN_LENGTH] = len(v)
 	s[PX_SEEN_MAX_LENGTH] = len(v)
+	s[PX_LENGTH_STATS] = numstat.Of(float64(len(v)))
 	return s
 }
 
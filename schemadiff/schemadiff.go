@@ -0,0 +1,365 @@
+// Package schemadiff compares two schemas learned by Mox Populi,
+// like from nightly schemagen runs, and classifies how each change affects
+// consumers of the payloads the schemas describe.
+package schemadiff
+
+import (
+	"fmt"
+	"github.com/lithictech/moxpopuli/internal"
+	. "github.com/lithictech/moxpopuli/jsonformat"
+	"github.com/lithictech/moxpopuli/jsontype"
+	"github.com/lithictech/moxpopuli/moxjson"
+	. "github.com/lithictech/moxpopuli/schema"
+	"github.com/lithictech/moxpopuli/schemamerge"
+	"sort"
+	"strconv"
+)
+
+type Severity string
+
+const (
+	// S_BREAKING changes can break consumers, like a property being removed or changing type.
+	S_BREAKING Severity = "breaking"
+	// S_NON_BREAKING changes are compatible with existing consumers, like a new property.
+	S_NON_BREAKING Severity = "non-breaking"
+	// S_INFO changes only describe what was seen, like a wider range of seen values.
+	S_INFO Severity = "informational"
+)
+
+type Kind string
+
+const (
+	K_PROPERTY_ADDED      Kind = "property_added"
+	K_PROPERTY_REMOVED    Kind = "property_removed"
+	K_TYPE_CHANGED        Kind = "type_changed"
+	K_FORMAT_CHANGED      Kind = "format_changed"
+	K_ONE_OF_ADDED        Kind = "one_of_added"
+	K_ONE_OF_REMOVED      Kind = "one_of_removed"
+	K_NULLABLE_ADDED      Kind = "nullable_added"
+	K_NULLABLE_REMOVED    Kind = "nullable_removed"
+	K_ENUM_ADDED          Kind = "enum_added"
+	K_ENUM_REMOVED        Kind = "enum_removed"
+	K_ENUM_VALUES_ADDED   Kind = "enum_values_added"
+	K_ENUM_VALUES_REMOVED Kind = "enum_values_removed"
+	K_RANGE_EXPANDED      Kind = "range_expanded"
+	K_RANGE_NARROWED      Kind = "range_narrowed"
+	K_SEEN_RANGE_EXPANDED Kind = "seen_range_expanded"
+)
+
+type Change struct {
+	// JSON path of the changed value in payloads, like '$.user.id' or '$.items[]'.
+	Path     string      `json:"path,omitempty"`
+	Kind     Kind        `json:"kind"`
+	Severity Severity    `json:"severity"`
+	Old      interface{} `json:"old,omitempty"`
+	New      interface{} `json:"new,omitempty"`
+	Message  string      `json:"message"`
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s %s: %s", c.Severity, c.Path, c.Message)
+}
+
+// Is returns true if the change has the given severity.
+func (c Change) Is(sev Severity) bool {
+	return c.Severity == sev
+}
+
+// ChangeList is a list of Change, or of changes that embed it, like specdiff.Change.
+type ChangeList[T interface{ Is(Severity) bool }] []T
+
+type Changes = ChangeList[Change]
+
+// Breaking returns true if any change is S_BREAKING.
+func (c ChangeList[T]) Breaking() bool {
+	return len(c.Filter(S_BREAKING)) > 0
+}
+
+// Filter returns the changes with the given severity.
+func (c ChangeList[T]) Filter(sev Severity) ChangeList[T] {
+	var r ChangeList[T]
+	for _, ch := range c {
+		if ch.Is(sev) {
+			r = append(r, ch)
+		}
+	}
+	return r
+}
+
+// Diff returns the changes from old to new, in the order they appear in the schemas
+// (properties are sorted by name).
+//
+// Schemas in a 'oneOf' are matched by type, like schemamerge.Merge does,
+// so a new type for a value is reported as an added 'oneOf' schema.
+// Samples and other fields only used to learn the schema are ignored.
+func Diff(old, new Schema) Changes {
+	d := &differ{}
+	d.diff("$", old, new)
+	return d.changes
+}
+
+type differ struct {
+	changes Changes
+}
+
+func (d *differ) add(path string, kind Kind, sev Severity, old, new interface{}, msg string, args ...interface{}) {
+	d.changes = append(d.changes, Change{
+		Path:     path,
+		Kind:     kind,
+		Severity: sev,
+		Old:      old,
+		New:      new,
+		Message:  fmt.Sprintf(msg, args...),
+	})
+}
+
+func (d *differ) diff(path string, old, new Schema) {
+	if old.Nullable() && !new.Nullable() {
+		d.add(path, K_NULLABLE_REMOVED, S_NON_BREAKING, nil, nil, "no longer nullable")
+	} else if !old.Nullable() && new.Nullable() {
+		d.add(path, K_NULLABLE_ADDED, S_BREAKING, nil, nil, "became nullable")
+	}
+	_, oldOneOf := old[P_ONE_OF]
+	_, newOneOf := new[P_ONE_OF]
+	if !oldOneOf && !newOneOf {
+		d.diffTyped(path, old, new)
+		return
+	}
+	oldBranches, newBranches := branches(old), branches(new)
+	keys := make([]string, 0, len(oldBranches)+len(newBranches))
+	for k := range oldBranches {
+		keys = append(keys, k)
+	}
+	for k := range newBranches {
+		if _, ok := oldBranches[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		o, oldOk := oldBranches[k]
+		n, newOk := newBranches[k]
+		if !oldOk {
+			// If there was no type before, a new type is not breaking.
+			sev := S_BREAKING
+			if len(oldBranches) == 0 {
+				sev = S_NON_BREAKING
+			}
+			d.add(path, K_ONE_OF_ADDED, sev, nil, n.Type(), "can also be %s", typeName(n))
+		} else if !newOk {
+			d.add(path, K_ONE_OF_REMOVED, S_NON_BREAKING, o.Type(), nil, "can no longer be %s", typeName(o))
+		} else {
+			d.diffTyped(path, o, n)
+		}
+	}
+}
+
+// branches returns the schemas that a value can be, keyed by type.
+// Integers and numbers are keyed together, since schemamerge merges them.
+func branches(s Schema) map[string]Schema {
+	r := make(map[string]Schema)
+	if oneOf, ok := s[P_ONE_OF]; ok {
+		for _, sch := range CoerceSlice(oneOf) {
+			r[branchKey(sch)] = sch
+		}
+	} else if s.Type() != jsontype.T_NOTYPE {
+		r[branchKey(s)] = s
+	}
+	return r
+}
+
+func branchKey(s Schema) string {
+	if t := s.Type(); t != jsontype.T_INTEGER {
+		return string(t)
+	}
+	return string(jsontype.T_NUMBER)
+}
+
+func typeName(s Schema) string {
+	if f := s.Format(); f != F_NOFORMAT {
+		return fmt.Sprintf("%s (%s)", s.Type(), f)
+	}
+	return string(s.Type())
+}
+
+func (d *differ) diffTyped(path string, old, new Schema) {
+	ot, nt := old.Type(), new.Type()
+	if ot != nt {
+		// Learning a type, or an integer where there were numbers, is compatible with consumers.
+		sev := S_BREAKING
+		if ot == jsontype.T_NOTYPE || (ot == jsontype.T_NUMBER && nt == jsontype.T_INTEGER) {
+			sev = S_NON_BREAKING
+		}
+		d.add(path, K_TYPE_CHANGED, sev, ot, nt, "type changed from %s to %s", nameOrNone(ot), nameOrNone(nt))
+		if !isNumeric(ot) || !isNumeric(nt) {
+			return
+		}
+	}
+	d.diffFormat(path, old.Format(), new.Format())
+	d.diffEnum(path, old, new)
+	d.diffRange(path, "minimum", old[P_MINIMUM], new[P_MINIMUM], true)
+	d.diffRange(path, "maximum", old[P_MAXIMUM], new[P_MAXIMUM], false)
+	d.diffRange(path, "minLength", old[P_MIN_LENGTH], new[P_MIN_LENGTH], true)
+	d.diffRange(path, "maxLength", old[P_MAX_LENGTH], new[P_MAX_LENGTH], false)
+	d.diffSeenRange(path, "minimum", old[PX_SEEN_MINIMUM], new[PX_SEEN_MINIMUM], true)
+	d.diffSeenRange(path, "maximum", old[PX_SEEN_MAXIMUM], new[PX_SEEN_MAXIMUM], false)
+	d.diffSeenRange(path, "length minimum", old[PX_SEEN_MIN_LENGTH], new[PX_SEEN_MIN_LENGTH], true)
+	d.diffSeenRange(path, "length maximum", old[PX_SEEN_MAX_LENGTH], new[PX_SEEN_MAX_LENGTH], false)
+	if _, ok := old.ToObject(); ok {
+		d.diffProperties(path, properties(old), properties(new))
+	} else if _, ok := old.ToArray(); ok {
+		d.diff(path+"[]", items(old), items(new))
+	} else if oc, nc := StringSchema(old).ContentSchema(), StringSchema(new).ContentSchema(); oc != nil && nc != nil {
+		// Both strings hold JSON documents, so diff the documents like the rest of the payload.
+		d.diff(path, oc, nc)
+	}
+}
+
+func nameOrNone(t jsontype.JsonType) string {
+	if t == jsontype.T_NOTYPE {
+		return "none"
+	}
+	return string(t)
+}
+
+func isNumeric(t jsontype.JsonType) bool {
+	return t == jsontype.T_INTEGER || t == jsontype.T_NUMBER
+}
+
+// diffFormat reports a format change as breaking if the new format is not a narrower kind of the old one,
+// like an int32 becoming an int64, or a uuid becoming any string.
+func (d *differ) diffFormat(path string, old, new JsonFormat) {
+	if old == new {
+		return
+	}
+	sev := S_BREAKING
+	if schemamerge.MergeFormat(old, new) == old {
+		sev = S_NON_BREAKING
+	}
+	d.add(path, K_FORMAT_CHANGED, sev, old, new, "format changed from %s to %s", formatName(old), formatName(new))
+}
+
+func formatName(f JsonFormat) string {
+	if f == F_NOFORMAT {
+		return "none"
+	}
+	return string(f)
+}
+
+func (d *differ) diffEnum(path string, old, new Schema) {
+	oldEnum, oldOk := enumValues(old)
+	newEnum, newOk := enumValues(new)
+	if !oldOk && !newOk {
+		return
+	} else if !oldOk {
+		d.add(path, K_ENUM_ADDED, S_NON_BREAKING, nil, newEnum, "became an enum of %v", newEnum)
+		return
+	} else if !newOk {
+		d.add(path, K_ENUM_REMOVED, S_BREAKING, oldEnum, nil, "is no longer an enum")
+		return
+	}
+	if added := internal.SubtractStrings(newEnum, oldEnum); len(added) > 0 {
+		d.add(path, K_ENUM_VALUES_ADDED, S_BREAKING, nil, added, "added enum values %v", added)
+	}
+	if removed := internal.SubtractStrings(oldEnum, newEnum); len(removed) > 0 {
+		d.add(path, K_ENUM_VALUES_REMOVED, S_NON_BREAKING, removed, nil, "removed enum values %v", removed)
+	}
+}
+
+// enumValues returns the schema's enum values as sorted strings, so enums of any type can be compared.
+func enumValues(s Schema) ([]string, bool) {
+	e, ok := s[P_ENUM]
+	if !ok {
+		return nil, false
+	}
+	var values []string
+	switch ev := e.(type) {
+	case []string:
+		values = ev
+	case []int:
+		for _, v := range ev {
+			values = append(values, strconv.Itoa(v))
+		}
+	case []interface{}:
+		for _, v := range ev {
+			values = append(values, fmt.Sprintf("%v", v))
+		}
+	}
+	return internal.UniqueSortedStrings(values), true
+}
+
+// diffRange reports changes to declared bounds, like 'minimum'.
+// A wider range is breaking, since consumers may validate against the old one.
+func (d *differ) diffRange(path, name string, old, new interface{}, isMin bool) {
+	o, oldOk := ToFloat(old)
+	n, newOk := ToFloat(new)
+	if oldOk && !newOk {
+		d.add(path, K_RANGE_EXPANDED, S_BREAKING, old, nil, "%s removed", name)
+	} else if !oldOk && newOk {
+		d.add(path, K_RANGE_NARROWED, S_NON_BREAKING, nil, new, "%s set to %v", name, new)
+	} else if oldOk && newOk && o != n {
+		if expanded(o, n, isMin) {
+			d.add(path, K_RANGE_EXPANDED, S_BREAKING, old, new, "%s expanded from %v to %v", name, old, new)
+		} else {
+			d.add(path, K_RANGE_NARROWED, S_NON_BREAKING, old, new, "%s narrowed from %v to %v", name, old, new)
+		}
+	}
+}
+
+// diffSeenRange reports values seen outside of the previously seen range.
+// Seen ranges only grow as more payloads are learned, so narrowing is not reported.
+func (d *differ) diffSeenRange(path, name string, old, new interface{}, isMin bool) {
+	o, oldOk := ToFloat(old)
+	n, newOk := ToFloat(new)
+	if oldOk && newOk && expanded(o, n, isMin) {
+		d.add(path, K_SEEN_RANGE_EXPANDED, S_INFO, old, new, "seen %s expanded from %v to %v", name, old, new)
+	}
+}
+
+func expanded(old, new float64, isMin bool) bool {
+	if isMin {
+		return new < old
+	}
+	return new > old
+}
+
+func (d *differ) diffProperties(path string, oldProps, newProps map[string]Schema) {
+	keys := make([]string, 0, len(oldProps)+len(newProps))
+	for k := range oldProps {
+		keys = append(keys, k)
+	}
+	for k := range newProps {
+		if _, ok := oldProps[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		ppath := moxjson.JsonPathKey(path, k)
+		o, oldOk := oldProps[k]
+		n, newOk := newProps[k]
+		if !oldOk {
+			d.add(ppath, K_PROPERTY_ADDED, S_NON_BREAKING, nil, n.Type(), "property added")
+		} else if !newOk {
+			d.add(ppath, K_PROPERTY_REMOVED, S_BREAKING, o.Type(), nil, "property removed")
+		} else {
+			d.diff(ppath, o, n)
+		}
+	}
+}
+
+// properties returns the object's properties, which hand-written schemas may not have.
+func properties(s Schema) map[string]Schema {
+	if _, ok := s[P_PROPERTIES]; !ok {
+		return nil
+	}
+	return ObjectSchema(s).Properties()
+}
+
+// items returns the array's items schema, which hand-written schemas may not have.
+func items(s Schema) Schema {
+	if _, ok := s[P_ITEMS]; !ok {
+		return Schema{}
+	}
+	return ArraySchema(s).Items()
+}
@@ -0,0 +1,138 @@
+package schemadiff_test
+
+import (
+	"context"
+	"github.com/lithictech/moxpopuli/internal/moxtest"
+	"github.com/lithictech/moxpopuli/schema"
+	"github.com/lithictech/moxpopuli/schemadiff"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestSchemadiff(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "schemadiff Suite")
+}
+
+var _ = Describe("schemadiff", func() {
+	ctx := context.Background()
+	change := func(path string, kind schemadiff.Kind, sev schemadiff.Severity) OmegaMatcher {
+		return And(
+			HaveField("Path", path),
+			HaveField("Kind", kind),
+			HaveField("Severity", sev),
+		)
+	}
+	m := func(kv ...interface{}) map[string]interface{} {
+		r := make(map[string]interface{}, len(kv)/2)
+		for i := 0; i < len(kv); i += 2 {
+			r[kv[i].(string)] = kv[i+1]
+		}
+		return r
+	}
+
+	It("has no changes for the same schema", func() {
+		sch := moxtest.LearnSchema(ctx, m("x", 1, "y", []interface{}{"a"}))
+		changes := schemadiff.Diff(sch, sch)
+		Expect(changes).To(BeEmpty())
+		Expect(changes.Breaking()).To(BeFalse())
+	})
+
+	It("reports added and removed properties", func() {
+		old := moxtest.LearnSchema(ctx, m("a", 1, "b", m("c", "x")))
+		new := moxtest.LearnSchema(ctx, m("a", 1, "b", m("d", "x")), m("a", 1, "b", m("d", "y")), m("a", 1, "b", m("d", "z"), "e", true))
+		changes := schemadiff.Diff(old, new)
+		Expect(changes).To(ConsistOf(
+			change("$.b.c", schemadiff.K_PROPERTY_REMOVED, schemadiff.S_BREAKING),
+			change("$.b.d", schemadiff.K_PROPERTY_ADDED, schemadiff.S_NON_BREAKING),
+			change("$.e", schemadiff.K_PROPERTY_ADDED, schemadiff.S_NON_BREAKING),
+		))
+		Expect(changes.Breaking()).To(BeTrue())
+	})
+
+	It("reports type changes and new oneOf schemas", func() {
+		old := moxtest.LearnSchema(ctx, m("a", 1, "b", 1.5, "c", "x"))
+		Expect(schemadiff.Diff(old, moxtest.LearnSchema(ctx, m("a", "x", "b", 1, "c", "x")))).To(ConsistOf(
+			change("$.a", schemadiff.K_TYPE_CHANGED, schemadiff.S_BREAKING),
+			change("$.b", schemadiff.K_TYPE_CHANGED, schemadiff.S_NON_BREAKING),
+			change("$.b", schemadiff.K_FORMAT_CHANGED, schemadiff.S_NON_BREAKING),
+			change("$.b", schemadiff.K_SEEN_RANGE_EXPANDED, schemadiff.S_INFO),
+		))
+		new := moxtest.LearnSchema(ctx, m("a", 1, "b", 1.5, "c", "x"), m("a", 1, "b", 1.5, "c", 5))
+		Expect(schemadiff.Diff(old, new)).To(ConsistOf(
+			change("$.c", schemadiff.K_ONE_OF_ADDED, schemadiff.S_BREAKING),
+		))
+		Expect(schemadiff.Diff(new, old)).To(ConsistOf(
+			change("$.c", schemadiff.K_ONE_OF_REMOVED, schemadiff.S_NON_BREAKING),
+		))
+	})
+
+	It("reports widened formats as breaking", func() {
+		old := moxtest.LearnSchema(ctx, m("a", 5))
+		new := moxtest.LearnSchema(ctx, m("a", 5), m("a", -(1<<40)))
+		Expect(schemadiff.Diff(old, new)).To(ContainElement(And(
+			change("$.a", schemadiff.K_FORMAT_CHANGED, schemadiff.S_BREAKING),
+			HaveField("Message", "format changed from int32 to int64"),
+		)))
+		Expect(schemadiff.Diff(new, old)).To(ContainElement(
+			change("$.a", schemadiff.K_FORMAT_CHANGED, schemadiff.S_NON_BREAKING),
+		))
+	})
+
+	It("reports nullability changes", func() {
+		old := moxtest.LearnSchema(ctx, m("a", 1))
+		new := moxtest.LearnSchema(ctx, m("a", 1), m("a", nil))
+		Expect(schemadiff.Diff(old, new)).To(ConsistOf(
+			change("$.a", schemadiff.K_NULLABLE_ADDED, schemadiff.S_BREAKING),
+		))
+		Expect(schemadiff.Diff(new, old)).To(ConsistOf(
+			change("$.a", schemadiff.K_NULLABLE_REMOVED, schemadiff.S_NON_BREAKING),
+		))
+	})
+
+	It("reports enum changes", func() {
+		old := schema.Schema{schema.P_TYPE: "string", schema.P_ENUM: []string{"active", "inactive"}}
+		new := schema.Schema{schema.P_TYPE: "string", schema.P_ENUM: []interface{}{"active", "pending"}}
+		Expect(schemadiff.Diff(old, new)).To(ConsistOf(
+			And(
+				change("$", schemadiff.K_ENUM_VALUES_ADDED, schemadiff.S_BREAKING),
+				HaveField("New", []string{"pending"}),
+			),
+			And(
+				change("$", schemadiff.K_ENUM_VALUES_REMOVED, schemadiff.S_NON_BREAKING),
+				HaveField("Old", []string{"inactive"}),
+			),
+		))
+		Expect(schemadiff.Diff(old, schema.Schema{schema.P_TYPE: "string"})).To(ConsistOf(
+			change("$", schemadiff.K_ENUM_REMOVED, schemadiff.S_BREAKING),
+		))
+	})
+
+	It("reports range changes", func() {
+		old := moxtest.LearnSchema(ctx, m("a", []interface{}{5}))
+		old.MustObject().Properties()["a"][schema.P_ITEMS].(schema.Schema)[schema.P_MAXIMUM] = 10
+		new := moxtest.LearnSchema(ctx, m("a", []interface{}{5}), m("a", []interface{}{-3}))
+		changes := schemadiff.Diff(old, new)
+		Expect(changes).To(ConsistOf(
+			change("$.a[]", schemadiff.K_RANGE_EXPANDED, schemadiff.S_BREAKING),
+			And(
+				change("$.a[]", schemadiff.K_SEEN_RANGE_EXPANDED, schemadiff.S_INFO),
+				HaveField("Message", "seen minimum expanded from 5 to -3"),
+			),
+		))
+	})
+
+	It("quotes property names that are not identifiers", func() {
+		changes := schemadiff.Diff(moxtest.LearnSchema(ctx, m("a", 1)), moxtest.LearnSchema(ctx, m("a", 1, "b.c", 1)))
+		Expect(changes).To(ConsistOf(change(`$["b.c"]`, schemadiff.K_PROPERTY_ADDED, schemadiff.S_NON_BREAKING)))
+	})
+
+	It("diffs JSON documents encoded in strings", func() {
+		changes := schemadiff.Diff(moxtest.LearnSchema(ctx, m("meta", `{"a": 1}`)), moxtest.LearnSchema(ctx, m("meta", `{"a": "x", "b": 1}`)))
+		Expect(changes).To(ContainElements(
+			change("$.meta.a", schemadiff.K_TYPE_CHANGED, schemadiff.S_BREAKING),
+			change("$.meta.b", schemadiff.K_PROPERTY_ADDED, schemadiff.S_NON_BREAKING),
+		))
+	})
+})
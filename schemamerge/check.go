@@ -0,0 +1,274 @@
+package schemamerge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/lithictech/moxpopuli/internal"
+	. "github.com/lithictech/moxpopuli/jsonformat"
+	"github.com/lithictech/moxpopuli/jsontype"
+	"github.com/lithictech/moxpopuli/moxio"
+	"github.com/lithictech/moxpopuli/moxjson"
+	. "github.com/lithictech/moxpopuli/schema"
+	"github.com/pkg/errors"
+	"sort"
+	"strconv"
+)
+
+type ViolationKind string
+
+const (
+	V_UNKNOWN_PROPERTY ViolationKind = "unknown_property"
+	V_TYPE_MISMATCH    ViolationKind = "type_mismatch"
+	V_FORMAT_MISMATCH  ViolationKind = "format_mismatch"
+	V_OUT_OF_RANGE     ViolationKind = "out_of_range"
+	V_UNEXPECTED_NULL  ViolationKind = "unexpected_null"
+	V_ENUM_MISS        ViolationKind = "enum_miss"
+	// V_NULL is a null where the schema is nullable. It is only reported by CheckOneWithNulls.
+	V_NULL ViolationKind = "null"
+)
+
+type Violation struct {
+	// JSON path of the value in the payload, like '$.user.id' or '$.items[2]'.
+	Path    string        `json:"path"`
+	Kind    ViolationKind `json:"kind"`
+	Message string        `json:"message"`
+	// The schema (or 'oneOf' schema) the value was checked against.
+	// For unknown properties, this is the schema of the object.
+	Schema Schema `json:"-"`
+	// The value that was checked, like the number for out of range violations.
+	// It is never serialized, since it may be sensitive.
+	Value interface{} `json:"-"`
+}
+
+type PayloadCheck struct {
+	// Index of the payload in the iterator, starting at 0.
+	Index      int         `json:"index"`
+	Violations []Violation `json:"violations"`
+}
+
+type CheckInput struct {
+	// The learned schema. It is not modified.
+	Schema          Schema
+	PayloadIterator moxio.Iterator
+}
+
+type CheckOutput struct {
+	// Results of the payloads with violations, in iterator order.
+	Invalid []PayloadCheck `json:"invalid"`
+	// Number of payloads checked.
+	Checked int `json:"checked"`
+	// Number of violations of each kind, across all payloads.
+	Summary map[ViolationKind]int `json:"summary"`
+}
+
+// Check reports how each payload does not fit what the schema has seen,
+// without merging the payloads into the schema (see CheckOne).
+func Check(ctx context.Context, in CheckInput) (CheckOutput, error) {
+	out := CheckOutput{Summary: make(map[ViolationKind]int)}
+	for in.PayloadIterator.Next() {
+		payload, err := in.PayloadIterator.Read(ctx)
+		if err != nil {
+			return out, errors.Wrap(err, "payload loader iterator")
+		}
+		if violations := CheckOne(in.Schema, payload); len(violations) > 0 {
+			out.Invalid = append(out.Invalid, PayloadCheck{Index: out.Checked, Violations: violations})
+			for _, v := range violations {
+				out.Summary[v.Kind]++
+			}
+		}
+		out.Checked++
+	}
+	return out, nil
+}
+
+// CheckOne returns the ways the payload does not fit the schema:
+//   - Properties the schema does not have. Missing properties are fine, since properties are not required.
+//   - Values of a type the schema (or its 'oneOf') does not have. Integers fit number schemas.
+//   - Values that do not fit the schema's format, like an int64 for an int32, or any string for a uuid.
+//   - Numbers outside of the declared or seen range, and arrays outside of the seen length.
+//   - Nulls where the schema is not nullable.
+//   - Strings not in the schema's enum.
+//
+// String lengths are not checked, since they are only recorded for strings without a format,
+// so they do not cover all the strings the schema has seen. Use the format and enum instead.
+//
+// Values of schemas without a type (like the items of arrays that were always empty) always fit.
+func CheckOne(sch Schema, payload interface{}) []Violation {
+	c := &checker{}
+	c.check("$", sch, payload)
+	return c.violations
+}
+
+// CheckOneWithNulls is like CheckOne, but also reports nulls where the schema is nullable as V_NULL.
+// These fit the schema, but can still be unusual, like in a field that is almost never null
+// (see anomaly.ScoreOne).
+func CheckOneWithNulls(sch Schema, payload interface{}) []Violation {
+	c := &checker{nulls: true}
+	c.check("$", sch, payload)
+	return c.violations
+}
+
+type checker struct {
+	violations []Violation
+	nulls      bool
+}
+
+func (c *checker) add(path string, sch Schema, v interface{}, kind ViolationKind, msg string, args ...interface{}) {
+	c.violations = append(c.violations, Violation{
+		Path:    path,
+		Kind:    kind,
+		Message: fmt.Sprintf(msg, args...),
+		Schema:  sch,
+		Value:   v,
+	})
+}
+
+func (c *checker) check(path string, sch Schema, v interface{}) {
+	if v == nil {
+		if !sch.Nullable() {
+			c.add(path, sch, v, V_UNEXPECTED_NULL, "unexpected null")
+		} else if c.nulls {
+			c.add(path, sch, v, V_NULL, "null")
+		}
+		return
+	}
+	v = internal.CoerceToLikelyGoType(v)
+	t := jsontype.Sniff(v)
+	if oneOf, ok := sch[P_ONE_OF]; ok {
+		for _, branch := range CoerceSlice(oneOf) {
+			if sliceKey(branch) == sliceKey(Schema{P_TYPE: t}) {
+				c.checkTyped(path, branch, t, v)
+				return
+			}
+		}
+		c.add(path, sch, v, V_TYPE_MISMATCH, "unexpected %s", t)
+		return
+	}
+	st := sch.Type()
+	if st == jsontype.T_NOTYPE {
+		if sch.NullOnly() {
+			c.add(path, sch, v, V_TYPE_MISMATCH, "expected null, got %s", t)
+		}
+		return
+	}
+	if st != t && !(st == jsontype.T_NUMBER && t == jsontype.T_INTEGER) {
+		c.add(path, sch, v, V_TYPE_MISMATCH, "expected %s, got %s", st, t)
+		return
+	}
+	c.checkTyped(path, sch, t, v)
+}
+
+func (c *checker) checkTyped(path string, sch Schema, t jsontype.JsonType, v interface{}) {
+	c.checkFormat(path, sch, t, v)
+	switch t {
+	case jsontype.T_INTEGER:
+		c.checkRange(path, "value", sch, float64(v.(int)), P_MINIMUM, P_MAXIMUM, PX_SEEN_MINIMUM, PX_SEEN_MAXIMUM)
+	case jsontype.T_NUMBER:
+		c.checkRange(path, "value", sch, v.(float64), P_MINIMUM, P_MAXIMUM, PX_SEEN_MINIMUM, PX_SEEN_MAXIMUM)
+	case jsontype.T_STRING:
+		s := v.(string)
+		ss, _ := sch.ToString()
+		if enum := ss.Enum(); len(enum) > 0 {
+			found := false
+			for _, e := range enum {
+				found = found || e == s
+			}
+			if !found {
+				if ss.Sensitive() {
+					c.add(path, sch, v, V_ENUM_MISS, "value not in enum")
+				} else {
+					c.add(path, sch, v, V_ENUM_MISS, "%s not in enum %v", strconv.Quote(s), enum)
+				}
+			}
+		}
+		if content := ss.ContentSchema(); content != nil {
+			// Paths into the document continue from the string, like '$.metadata.order_id'.
+			var doc interface{}
+			if err := json.Unmarshal([]byte(s), &doc); err == nil {
+				c.check(path, content, doc)
+			}
+		}
+	case jsontype.T_ARRAY:
+		arr := v.([]interface{})
+		c.checkRange(path, "length", sch, float64(len(arr)), "", "", PX_SEEN_MIN_LENGTH, PX_SEEN_MAX_LENGTH)
+		if _, ok := sch[P_ITEMS]; !ok {
+			return
+		}
+		items := ArraySchema(sch).Items()
+		for i, item := range arr {
+			c.check(fmt.Sprintf("%s[%d]", path, i), items, item)
+		}
+	case jsontype.T_OBJECT:
+		var props map[string]Schema
+		if _, ok := sch[P_PROPERTIES]; ok {
+			props = ObjectSchema(sch).Properties()
+		}
+		for _, k := range sortedKeys(v.(map[string]interface{})) {
+			ppath := moxjson.JsonPathKey(path, k)
+			if prop, ok := props[k]; ok {
+				c.check(ppath, prop, v.(map[string]interface{})[k])
+			} else {
+				c.add(ppath, sch, v.(map[string]interface{})[k], V_UNKNOWN_PROPERTY, "unknown property")
+			}
+		}
+	}
+}
+
+// checkFormat reports values that the schema's format cannot hold,
+// which is when merging in the value's format would change the schema's format.
+func (c *checker) checkFormat(path string, sch Schema, t jsontype.JsonType, v interface{}) {
+	f := sch.Format()
+	if f == F_NOFORMAT {
+		return
+	}
+	if f == F_ZERO_ONE {
+		if i, ok := v.(int); !ok || (i != 0 && i != 1) {
+			c.add(path, sch, v, V_FORMAT_MISMATCH, "expected 0 or 1")
+		}
+		return
+	}
+	if vf := Sniff(t, v); MergeFormat(f, vf) != f {
+		c.add(path, sch, v, V_FORMAT_MISMATCH, "expected format %s, got %s", f, formatOrNone(vf))
+	}
+}
+
+func formatOrNone(f JsonFormat) string {
+	if f == F_NOFORMAT {
+		return "none"
+	}
+	return string(f)
+}
+
+// checkRange reports n if it is outside of the declared range (like 'minimum' and 'maximum'),
+// or the seen range (like 'x-seenMinimum' and 'x-seenMaximum').
+// Pass empty fields for ranges the schema cannot have.
+func (c *checker) checkRange(path, what string, sch Schema, n float64, minField, maxField, seenMinField, seenMaxField Field) {
+	for _, r := range []struct {
+		min, max Field
+		desc     string
+	}{{minField, maxField, "range"}, {seenMinField, seenMaxField, "seen range"}} {
+		min, minOk := ToFloat(sch[r.min])
+		max, maxOk := ToFloat(sch[r.max])
+		if (minOk && n < min) || (maxOk && n > max) {
+			c.add(path, sch, n, V_OUT_OF_RANGE, "%s %v outside of %s %s to %s", what, n, r.desc, boundOrNone(sch[r.min], minOk), boundOrNone(sch[r.max], maxOk))
+			return
+		}
+	}
+}
+
+func boundOrNone(v interface{}, ok bool) string {
+	if !ok {
+		return "none"
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
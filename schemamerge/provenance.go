@@ -0,0 +1,323 @@
+package schemamerge
+
+import (
+	"context"
+	"fmt"
+	"github.com/lithictech/moxpopuli/internal"
+	"github.com/lithictech/moxpopuli/jsonformat"
+	"github.com/lithictech/moxpopuli/jsontype"
+	"github.com/lithictech/moxpopuli/moxio"
+	"github.com/lithictech/moxpopuli/moxjson"
+	. "github.com/lithictech/moxpopuli/schema"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type ProvenanceInput struct {
+	// Path of the payload's event time, like 'created_at' or 'meta.time',
+	// as an RFC3339 string or a Unix timestamp (in seconds or milliseconds).
+	// If empty, or the payload has no valid time at the path, the time the payload is merged is used.
+	TimeField string
+	// Path of the payload's identifier, like 'id' or 'event.id', to use as the source.
+	// If empty, or the payload has no identifier at the path, the source is Source and the payload's
+	// position in the iterator (starting at 1), like 'payloads.jsonl:12'.
+	SourceField string
+	// Where payloads come from, like a file name. If empty, the source is only the SourceField.
+	Source string
+	// Defaults to time.Now.
+	Now func() time.Time
+}
+
+// provenanced is a payload read by a provenanceIterator.
+// Payloads are stamped as they are read, since sharded payloads lose their position.
+type provenanced struct {
+	payload    interface{}
+	provenance Provenance
+}
+
+type provenanceIterator struct {
+	moxio.Iterator
+	in       ProvenanceInput
+	position int
+}
+
+func (it *provenanceIterator) Read(ctx context.Context) (interface{}, error) {
+	payload, err := it.Iterator.Read(ctx)
+	if err != nil {
+		return payload, err
+	}
+	it.position++
+	return provenanced{payload: payload, provenance: it.in.Of(payload, it.position)}, nil
+}
+
+// Of returns the provenance of a payload at a position (starting at 1) in its iterator.
+// Use it to stamp payloads merged one at a time (see MergeOneInput.Seen).
+func (in ProvenanceInput) Of(payload interface{}, position int) Provenance {
+	p := Provenance{}
+	if in.TimeField != "" {
+		p.At, _ = parseEventTime(fieldValue(payload, in.TimeField))
+	}
+	if p.At.IsZero() {
+		now := time.Now
+		if in.Now != nil {
+			now = in.Now
+		}
+		p.At = now()
+	}
+	if in.SourceField != "" {
+		if v := fieldValue(payload, in.SourceField); v != nil {
+			p.Source = fmt.Sprintf("%v", internal.CoerceToLikelyGoType(v))
+		}
+	}
+	if p.Source == "" && in.Source != "" {
+		p.Source = fmt.Sprintf("%s:%d", in.Source, position)
+	}
+	return p
+}
+
+func fieldValue(payload interface{}, path string) interface{} {
+	v, err := moxjson.Get(payload, moxjson.ParsePath(path))
+	if err != nil {
+		return nil
+	}
+	return v
+}
+
+func parseEventTime(v interface{}) (time.Time, bool) {
+	var ts float64
+	switch x := v.(type) {
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, TF_DATETIME_NOTZ, TF_DATE} {
+			if t, err := time.Parse(layout, x); err == nil {
+				return t, true
+			}
+		}
+		return time.Time{}, false
+	case int:
+		ts = float64(x)
+	case float64:
+		ts = x
+	default:
+		return time.Time{}, false
+	}
+	if jsonformat.Sniff(jsontype.T_NUMBER, ts) == jsonformat.F_TIMESTAMP_MS {
+		return time.UnixMilli(int64(ts)), true
+	}
+	return time.Unix(int64(ts), 0), true
+}
+
+// unwrapProvenance returns the payload read from a provenanceIterator, and its provenance, if any.
+func unwrapProvenance(msg interface{}) (interface{}, *Provenance) {
+	if p, ok := msg.(provenanced); ok {
+		return p.payload, &p.provenance
+	}
+	return msg, nil
+}
+
+// mergeProvenance records the earliest first seen, and the latest last seen, of s1 and s2 on sr.
+// Schemas learned before provenance was recorded have none, so the provenance of the other is used.
+func mergeProvenance(sr, s1, s2 Schema) {
+	f1, ok1 := s1.FirstSeen()
+	f2, ok2 := s2.FirstSeen()
+	if ok1 && (!ok2 || f1.Before(f2)) {
+		sr.SetFirstSeen(f1)
+	} else if ok2 {
+		sr.SetFirstSeen(f2)
+	}
+	l1, ok1 := s1.LastSeen()
+	l2, ok2 := s2.LastSeen()
+	if ok1 && (!ok2 || l2.Before(l1)) {
+		sr.SetLastSeen(l1)
+	} else if ok2 {
+		sr.SetLastSeen(l2)
+	}
+}
+
+// mergeSeenWindows records the seen ranges and counts of each window of s1 and s2 on sr,
+// combining windows in both like seen ranges and counts are combined.
+func mergeSeenWindows(sr, s1, s2 Schema) {
+	w1, w2 := s1.SeenWindows(), s2.SeenWindows()
+	if len(w1) == 0 && len(w2) == 0 {
+		return
+	}
+	r := make(map[string]Schema, len(w1)+len(w2))
+	for k, w := range w1 {
+		r[k] = w
+	}
+	for k, w := range w2 {
+		addWindow(sr, r, k, w)
+	}
+	rollUpWindows(sr, r, len(WindowLayout), MaxDayWindows, MonthWindowLayout)
+	rollUpWindows(sr, r, len(MonthWindowLayout), MaxMonthWindows, YearWindowLayout)
+	sr[PX_SEEN_WINDOWS] = r
+}
+
+// addWindow adds w to the windows, merging it into any window with the same key.
+func addWindow(sch Schema, windows map[string]Schema, key string, w Schema) {
+	if existing, ok := windows[key]; ok {
+		windows[key] = mergeWindow(sch, existing, w)
+	} else {
+		windows[key] = w
+	}
+}
+
+// rollUpWindows keeps only the latest max windows with keys of keyLen (like days),
+// and merges older ones into the coarser window with keys of layout (like months).
+// A window that isn't among the latest of some windows isn't among the latest of more windows either,
+// so the same windows are rolled up no matter what order schemas are merged in.
+func rollUpWindows(sch Schema, windows map[string]Schema, keyLen, max int, layout string) {
+	keys := make([]string, 0, len(windows))
+	for k := range windows {
+		if len(k) == keyLen {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) <= max {
+		return
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	for _, k := range keys[max:] {
+		w := windows[k]
+		delete(windows, k)
+		addWindow(sch, windows, k[:len(layout)], w)
+	}
+}
+
+// mergeWindow returns the window with the seen range and counts of both windows.
+func mergeWindow(sch, w1, w2 Schema) Schema {
+	r := seenRange(sch, w1, w2)
+	if n := w1.Samples() + w2.Samples(); n > 0 {
+		r[PX_SAMPLES] = n
+	}
+	setNulls(r, w1.Nulls()+w2.Nulls())
+	mergeStats(r, PX_STATS, w1, w2)
+	mergeStats(r, PX_LENGTH_STATS, w1, w2)
+	return r
+}
+
+// withoutWindowNulls returns the windows of sch without their nulls,
+// for the schemas of a oneOf, whose nulls are recorded on the oneOf (see Schema.Nulls).
+func withoutWindowNulls(sch Schema) map[string]Schema {
+	windows := sch.SeenWindows()
+	r := make(map[string]Schema, len(windows))
+	for k, w := range windows {
+		if n := w.Nulls(); n > 0 {
+			w = w.DeepClone()
+			w[PX_SAMPLES] = w.Samples() - n
+			delete(w, PX_NULLS)
+		}
+		r[k] = w
+	}
+	return r
+}
+
+// mergeValuesLastSeen records the latest time each value of s1 and s2 was seen on sr.
+func mergeValuesLastSeen(sr, s1, s2 Schema) {
+	for _, f := range []Field{PX_ENUM_LAST_SEEN, PX_URI_LOCATIONS_LAST_SEEN} {
+		m := s1.ValuesLastSeen(f)
+		for k, t := range s2.ValuesLastSeen(f) {
+			if existing, ok := m[k]; !ok || t.After(existing) {
+				m[k] = t
+			}
+		}
+		sr.SetValuesLastSeen(f, m)
+	}
+}
+
+// handleValuesLastSeen forgets when values were last seen once they are no longer recorded,
+// like when a string is found to not be an enum.
+func handleValuesLastSeen(_ string, sch Schema) {
+	ssch, ok := sch.ToString()
+	if !ok {
+		return
+	}
+	keep := func(f Field, values []string) {
+		times := sch.ValuesLastSeen(f)
+		if len(times) == 0 {
+			return
+		}
+		kept := make(map[string]time.Time, len(values))
+		for _, v := range values {
+			if t, ok := times[v]; ok {
+				kept[v] = t
+			}
+		}
+		sch.SetValuesLastSeen(f, kept)
+	}
+	keep(PX_ENUM_LAST_SEEN, append(ssch.Enum(), ssch.SeenStrings()...))
+	keep(PX_URI_LOCATIONS_LAST_SEEN, ssch.SeenUriLocations())
+}
+
+// seenRange returns the seen range covering all of the ranges,
+// compared like values of the type and format of sch.
+func seenRange(sch Schema, ranges ...Schema) Schema {
+	r := Schema{}
+	var mins, maxs []interface{}
+	var minLen, maxLen *int
+	for _, rng := range ranges {
+		if v, ok := rng[PX_SEEN_MINIMUM]; ok {
+			mins = append(mins, v)
+		}
+		if v, ok := rng[PX_SEEN_MAXIMUM]; ok {
+			maxs = append(maxs, v)
+		}
+		minLen = internal.MinIntPtr(minLen, StringSchema(rng).SeenMinLength())
+		maxLen = internal.MaxIntPtr(maxLen, StringSchema(rng).SeenMaxLength())
+	}
+	if min, _, ok := valueRange(sch, mins); ok {
+		r[PX_SEEN_MINIMUM] = min
+	}
+	if _, max, ok := valueRange(sch, maxs); ok {
+		r[PX_SEEN_MAXIMUM] = max
+	}
+	setIfNotNull(r, PX_SEEN_MIN_LENGTH, minLen)
+	setIfNotNull(r, PX_SEEN_MAX_LENGTH, maxLen)
+	return r
+}
+
+// valueRange returns the smallest and largest of the values, compared like values of the type and format of sch.
+// It returns false if there are no values, or they cannot be compared.
+func valueRange(sch Schema, values []interface{}) (min, max interface{}, ok bool) {
+	if len(values) == 0 {
+		return nil, nil, false
+	}
+	switch sch.Type() {
+	case jsontype.T_INTEGER, jsontype.T_NUMBER:
+		var fmin, fmax *float64
+		for _, v := range values {
+			f, ok := ToFloat(v)
+			if !ok {
+				return nil, nil, false
+			}
+			fmin, fmax = internal.MinFloat64Ptr(fmin, &f), internal.MaxFloat64Ptr(fmax, &f)
+		}
+		if sch.Type() == jsontype.T_INTEGER {
+			return int(*fmin), int(*fmax), true
+		}
+		return *fmin, *fmax, true
+	case jsontype.T_STRING:
+		strs := make([]string, len(values))
+		for i, v := range values {
+			if strs[i], ok = v.(string); !ok {
+				return nil, nil, false
+			}
+		}
+		if sch.Format() == jsonformat.F_NUMERICAL {
+			var imin, imax *int
+			for _, s := range strs {
+				i, err := strconv.Atoi(s)
+				if err != nil {
+					return nil, nil, false
+				}
+				imin, imax = internal.MinIntPtr(imin, &i), internal.MaxIntPtr(imax, &i)
+			}
+			return strconv.Itoa(*imin), strconv.Itoa(*imax), true
+		}
+		if timecomp, ok := timeFormatValueComparers[sch.Format()]; ok {
+			min, max := timecomp(strs...)
+			return min, max, true
+		}
+	}
+	return nil, nil, false
+}
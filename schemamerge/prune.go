@@ -0,0 +1,254 @@
+package schemamerge
+
+import (
+	"github.com/lithictech/moxpopuli/jsontype"
+	"github.com/lithictech/moxpopuli/moxjson"
+	. "github.com/lithictech/moxpopuli/schema"
+	"sort"
+	"time"
+)
+
+type PrunedKind string
+
+const (
+	PR_PROPERTY     PrunedKind = "property"
+	PR_ONE_OF       PrunedKind = "one_of"
+	PR_ENUM_VALUE   PrunedKind = "enum_value"
+	PR_URI_LOCATION PrunedKind = "uri_location"
+)
+
+type Pruned struct {
+	// JSON path of the schema, like '$.user.id' or '$.items[]'.
+	// For properties, this is the path of the removed property.
+	Path string     `json:"path"`
+	Kind PrunedKind `json:"kind"`
+	// The removed enum value or URI location, or the type of the removed oneOf schema.
+	Value    string    `json:"value,omitempty"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+type PruneInput struct {
+	// The schema to prune. It is not modified.
+	Schema Schema
+	// Forget what was last seen before this time.
+	Since time.Time
+}
+
+type PruneOutput struct {
+	Schema Schema   `json:"schema"`
+	Pruned []Pruned `json:"pruned"`
+}
+
+// Prune forgets the parts of a schema that have not been seen since in.Since,
+// using the provenance recorded when merging (see ProvenanceInput):
+//
+//   - Properties and oneOf schemas last seen before in.Since are removed.
+//     If only one oneOf schema is left, it replaces the oneOf.
+//   - Enum values, seen strings, and URI locations last seen before in.Since are removed.
+//     Strings only counted in a sketch (see MaxSeenStrings) cannot be forgotten.
+//   - Seen ranges, samples, nulls, and statistics are recomputed from the windows (see schema.PX_SEEN_WINDOWS)
+//     ending after in.Since, and older windows are removed. Nulls and statistics that cannot be recomputed
+//     (like from windows recorded before they kept counts) are removed, so they do not describe forgotten values.
+//     The nulls of a oneOf are not recorded in windows, so are kept.
+//
+// Parts without provenance, like those learned before provenance was recorded, are kept.
+// If none of the windows of a schema end after in.Since (like if it was only seen as null since),
+// its seen range is kept, but the windows are still removed.
+func Prune(in PruneInput) PruneOutput {
+	p := &pruner{since: in.Since}
+	return PruneOutput{Schema: p.prune("$", in.Schema), Pruned: p.pruned}
+}
+
+type pruner struct {
+	since  time.Time
+	pruned []Pruned
+}
+
+// stale returns when the schema was last seen, and true if it was before the cutoff.
+func (p *pruner) stale(sch Schema) (time.Time, bool) {
+	ls, ok := sch.LastSeen()
+	return ls.At, ok && ls.At.Before(p.since)
+}
+
+func (p *pruner) prune(path string, sch Schema) Schema {
+	r := sch.DeepClone()
+	if oneOf, ok := r[P_ONE_OF]; ok {
+		var kept []Schema
+		var pruned []Pruned
+		for _, branch := range CoerceSlice(oneOf) {
+			if at, stale := p.stale(branch); stale {
+				pruned = append(pruned, Pruned{Path: path, Kind: PR_ONE_OF, Value: string(branch.Type()), LastSeen: at})
+			} else {
+				kept = append(kept, p.prune(path, branch))
+			}
+		}
+		if len(kept) == 0 {
+			// Only seen as null since, so there is nothing better to keep.
+			return r
+		}
+		p.pruned = append(p.pruned, pruned...)
+		if len(kept) > 1 {
+			r[P_ONE_OF] = kept
+			return r
+		}
+		hoisted := kept[0]
+		if r.Nullable() {
+			hoisted[PX_NULLABLE] = true
+			// Outside of a oneOf, samples include nulls.
+			if n := r.Nulls(); n > 0 {
+				setNulls(hoisted, n)
+				hoisted[PX_SAMPLES] = hoisted.Samples() + n
+			}
+		}
+		return hoisted
+	}
+	switch r.Type() {
+	case jsontype.T_OBJECT:
+		props := ObjectSchema(r).Properties()
+		keys := make([]string, 0, len(props))
+		for k := range props {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		kept := make(map[string]Schema, len(props))
+		for _, k := range keys {
+			propPath := moxjson.JsonPathKey(path, k)
+			if at, stale := p.stale(props[k]); stale {
+				p.pruned = append(p.pruned, Pruned{Path: propPath, Kind: PR_PROPERTY, LastSeen: at})
+			} else {
+				kept[k] = p.prune(propPath, props[k])
+			}
+		}
+		r[P_PROPERTIES] = kept
+	case jsontype.T_ARRAY:
+		if items := ArraySchema(r).Items(); len(items) > 0 {
+			r[P_ITEMS] = p.prune(path+"[]", items)
+		}
+	case jsontype.T_STRING:
+		p.pruneValues(path, r, PX_ENUM_LAST_SEEN, PR_ENUM_VALUE, P_ENUM, PX_SEEN_STRINGS)
+		p.pruneValues(path, r, PX_URI_LOCATIONS_LAST_SEEN, PR_URI_LOCATION, PX_URI_LOCATIONS)
+		if _, ok := r[PX_VALUE_COUNTS]; ok {
+			keepValueCounts(r, append(StringSchema(r).Enum(), StringSchema(r).SeenStrings()...))
+		}
+		if content := StringSchema(r).ContentSchema(); content != nil {
+			r[P_CONTENT_SCHEMA] = p.prune(path, content)
+		}
+	}
+	p.pruneWindows(r)
+	return r
+}
+
+// pruneValues removes the values last seen before the cutoff (according to timesField) from each of the fields.
+func (p *pruner) pruneValues(path string, sch Schema, timesField Field, kind PrunedKind, fields ...Field) {
+	times := sch.ValuesLastSeen(timesField)
+	staleValues := make(map[string]time.Time)
+	for v, at := range times {
+		if at.Before(p.since) {
+			staleValues[v] = at
+			delete(times, v)
+		}
+	}
+	if len(staleValues) == 0 {
+		return
+	}
+	sch.SetValuesLastSeen(timesField, times)
+	for _, f := range fields {
+		values := stringValues(sch, f)
+		if values == nil {
+			continue
+		}
+		kept := make([]string, 0, len(values))
+		for _, v := range values {
+			if at, ok := staleValues[v]; ok {
+				p.pruned = append(p.pruned, Pruned{Path: path, Kind: kind, Value: v, LastSeen: at})
+			} else {
+				kept = append(kept, v)
+			}
+		}
+		if len(kept) == 0 {
+			delete(sch, f)
+		} else {
+			sch[f] = kept
+		}
+	}
+}
+
+func stringValues(sch Schema, f Field) []string {
+	ssch := StringSchema(sch)
+	switch f {
+	case P_ENUM:
+		return ssch.Enum()
+	case PX_SEEN_STRINGS:
+		return ssch.SeenStrings()
+	case PX_URI_LOCATIONS:
+		return ssch.SeenUriLocations()
+	}
+	return nil
+}
+
+// pruneWindows removes the windows (days, months, or years) ending before the cutoff,
+// and recomputes the seen range and counts from the rest.
+func (p *pruner) pruneWindows(sch Schema) {
+	windows := sch.SeenWindows()
+	if len(windows) == 0 {
+		return
+	}
+	kept := make(map[string]Schema, len(windows))
+	recent := make([]Schema, 0, len(windows))
+	for key, w := range windows {
+		if end, ok := WindowEnd(key); ok && end.Before(p.since) {
+			continue
+		}
+		kept[key] = w
+		recent = append(recent, w)
+	}
+	if len(kept) == len(windows) {
+		return
+	}
+	if len(kept) == 0 {
+		// Only seen as null since, so keep the seen range, but forget the windows outside the horizon.
+		delete(sch, PX_SEEN_WINDOWS)
+		return
+	}
+	sch[PX_SEEN_WINDOWS] = kept
+	rng := seenRange(sch, recent...)
+	for _, f := range SeenRangeFields {
+		if _, ok := sch[f]; !ok {
+			continue
+		}
+		if v, ok := rng[f]; ok {
+			sch[f] = v
+		}
+	}
+	rebuildCounts(sch, windows, recent)
+}
+
+// rebuildCounts recomputes the samples, nulls, and statistics of sch from its recent windows,
+// so they describe the same values as the recomputed seen range.
+// If the windows do not count every sample (like if part of the schema was learned without provenance,
+// or before windows kept counts), the nulls and statistics cannot be rebuilt, so they are removed.
+func rebuildCounts(sch Schema, windows map[string]Schema, recent []Schema) {
+	total := 0
+	for _, w := range windows {
+		total += w.Samples()
+	}
+	if total != sch.Samples() {
+		delete(sch, PX_NULLS)
+		delete(sch, PX_STATS)
+		delete(sch, PX_LENGTH_STATS)
+		return
+	}
+	r := Schema{}
+	for _, w := range recent {
+		r = mergeWindow(sch, r, w)
+	}
+	sch[PX_SAMPLES] = r.Samples()
+	setNulls(sch, r.Nulls())
+	for _, f := range []Field{PX_STATS, PX_LENGTH_STATS} {
+		if st, ok := r[f]; ok {
+			sch[f] = st
+		} else {
+			delete(sch, f)
+		}
+	}
+}
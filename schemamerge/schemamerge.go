@@ -2,17 +2,20 @@ package schemamerge
 
 import (
 	"context"
-	"fmt"
 	"github.com/lithictech/moxpopuli/fp"
+	"github.com/lithictech/moxpopuli/hll"
 	"github.com/lithictech/moxpopuli/internal"
 	. "github.com/lithictech/moxpopuli/jsonformat"
 	"github.com/lithictech/moxpopuli/jsontype"
 	"github.com/lithictech/moxpopuli/moxio"
+	"github.com/lithictech/moxpopuli/moxrand"
+	"github.com/lithictech/moxpopuli/numstat"
 	. "github.com/lithictech/moxpopuli/schema"
 	"github.com/lithictech/moxpopuli/timestring"
 	"github.com/pkg/errors"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -23,6 +26,8 @@ type MergeInput struct {
 	// One of the schemas, usually the "source" (existing schema).
 	S1 Schema
 	// The other schema, usually the newly derived one.
+	// It can also be a previously merged schema (with 'x-samples'),
+	// in which case its samples are added to the samples of S1.
 	S2 Schema
 }
 
@@ -44,7 +49,9 @@ func Merge(ctx context.Context, in MergeInput) MergeOutput {
 		// Make sure all the subschemas get incremented,
 		// since it's the first time we've seen them.
 		sr = s2.DeepClone()
-		sr.IncrSamplesDeep()
+		if s2.Samples() == 0 {
+			sr.IncrSamplesDeep()
+		}
 		return MergeOutput{Schema: sr, TypeChanged: true}
 	}
 	// If one or the other are 'null only' because there was no value,
@@ -52,30 +59,82 @@ func Merge(ctx context.Context, in MergeInput) MergeOutput {
 	if s1.NullOnly() && !s2.NullOnly() {
 		s2 = s2.DeepClone()
 		s2[PX_NULLABLE] = true
-		s2.IncrSamples()
+		setNulls(s2, s1.Nulls()+s2.Nulls())
+		if _, ok := s2[P_ONE_OF]; !ok {
+			// Count the null samples too, so the result is the same as if the null came after s2.
+			s2[PX_SAMPLES] = internal.MaxInt(s2.Samples(), 1) + internal.MaxInt(s1.Samples(), 1)
+			mergeSeenWindows(s2, s1, s2)
+		}
+		mergeProvenance(s2, s1, s2)
 		return MergeOutput{Schema: s2, TypeChanged: true}
 	} else if !s1.NullOnly() && s2.NullOnly() {
 		s1 = s1.DeepClone()
 		s1[PX_NULLABLE] = true
-		s1.IncrSamples()
+		setNulls(s1, s1.Nulls()+s2.Nulls())
+		if _, ok := s1[P_ONE_OF]; !ok {
+			// See below for why samples default to 1.
+			s1[PX_SAMPLES] = internal.MaxInt(s1.Samples(), 1)
+			incrSamplesBy(s1, s2)
+			mergeSeenWindows(s1, s1, s2)
+		}
+		mergeProvenance(s1, s1, s2)
 		return MergeOutput{Schema: s1, TypeChanged: true}
 	}
 	mo := MergeOutput{Schema: sr}
 	var convertIntToFloat IntegerSchema
 	if s1.Type() == jsontype.T_INTEGER && s2.Type() == jsontype.T_NUMBER {
+		s1 = s1.DeepClone()
 		convertIntToFloat, _ = s1.ToInteger()
 	} else if s1.Type() == jsontype.T_NUMBER && s2.Type() == jsontype.T_INTEGER {
+		s2 = s2.DeepClone()
 		convertIntToFloat, _ = s2.ToInteger()
-	} else if s1.Type() != s2.Type() {
+	} else if _, ok := s2[P_ONE_OF]; ok || s1.Type() != s2.Type() {
 		// If types are not the same, compose the schemas with oneOf.
-		// Assume s1 here already has samples, since it was originally added
-		// as a homogenous schema.
+		// s1 usually already has samples, since it was originally added
+		// as a homogenous schema. But properties first seen in a nested object
+		// do not, so count that first sample.
 		// We must record the samples on the new schema though,
 		// since it's the first time we're seeing it.
 		// NOTE: I'm not certain this is right, it may need to be in mergeSliceProperty.
+		if _, ok := s1[P_ONE_OF]; !ok && s1.Samples() == 0 {
+			s1 = s1.DeepClone()
+			s1.IncrSamples()
+		}
 		s2 = s2.DeepClone()
-		s2.IncrSamples()
+		if s2.Samples() == 0 {
+			s2.IncrSamples()
+		}
 		sr[P_ONE_OF], mo.TypeChanged = mergeSliceProperty(ctx, P_ONE_OF, s1, s2)
+		// Whether a null is recorded on the oneOf or on one of its schemas depends on
+		// the order it was seen in, so always record it (and count it) on the oneOf,
+		// and do not count it in the samples of the schema.
+		nulls := 0
+		for _, s := range []Schema{s1, s2} {
+			if _, ok := s[P_ONE_OF]; ok {
+				nulls += s.Nulls()
+			}
+		}
+		oneOf := sr[P_ONE_OF].([]Schema)
+		for i, sch := range oneOf {
+			if sch.Nullable() {
+				sr[PX_NULLABLE] = true
+				oneOf[i] = sch.DeepClone()
+				delete(oneOf[i], PX_NULLABLE)
+				if n := sch.Nulls(); n > 0 {
+					nulls += n
+					oneOf[i][PX_SAMPLES] = sch.Samples() - n
+					delete(oneOf[i], PX_NULLS)
+					if _, ok := sch[PX_SEEN_WINDOWS]; ok {
+						oneOf[i][PX_SEEN_WINDOWS] = withoutWindowNulls(sch)
+					}
+				}
+			}
+		}
+		setNulls(sr, nulls)
+		if s1.Nullable() || s2.Nullable() {
+			sr[PX_NULLABLE] = true
+		}
+		mergeProvenance(sr, s1, s2)
 		return mo
 	}
 	if convertIntToFloat != nil {
@@ -88,11 +147,13 @@ func Merge(ctx context.Context, in MergeInput) MergeOutput {
 	// s1 may or may not have samples, depending on how it came in
 	// (ie load, subschema merge, etc), so default to 1
 	sr[PX_SAMPLES] = internal.MaxInt(s1.Samples(), 1)
-	sr.IncrSamples()
+	incrSamplesBy(sr, s2)
 
 	if s1.Nullable() || s2.Nullable() {
 		sr[PX_NULLABLE] = true
 	}
+	setNulls(sr, s1.Nulls()+s2.Nulls())
+	mergeProvenance(sr, s1, s2)
 	if t := s1.Type(); t != jsontype.T_NOTYPE {
 		// Generally this means the schemas are both from nulls
 		sr[P_TYPE] = t
@@ -102,21 +163,24 @@ func Merge(ctx context.Context, in MergeInput) MergeOutput {
 		sr[P_FORMAT] = jfmt
 	}
 	if s2t, ok := s2.ToInteger(); ok {
+		s1t, _ := s1.ToInteger()
 		if jfmt == F_ZERO_ONE {
 			sr[P_ENUM] = s2t.Enum()
 		} else {
-			s1t, _ := s1.ToInteger()
 			setIfNotNull(sr, P_MINIMUM, internal.MinIntPtr(s1t.Minimum(), s2t.Minimum()))
 			setIfNotNull(sr, P_MAXIMUM, internal.MaxIntPtr(s1t.Maximum(), s2t.Maximum()))
-			setIfNotNull(sr, PX_SEEN_MINIMUM, internal.MinIntPtr(s1t.SeenMinimum(), s2t.SeenMinimum()))
-			setIfNotNull(sr, PX_SEEN_MAXIMUM, internal.MaxIntPtr(s1t.SeenMaximum(), s2t.SeenMaximum()))
 		}
+		// Both schemas are only zero-one if they were merged previously, so keep the seen range.
+		setIfNotNull(sr, PX_SEEN_MINIMUM, internal.MinIntPtr(s1t.SeenMinimum(), s2t.SeenMinimum()))
+		setIfNotNull(sr, PX_SEEN_MAXIMUM, internal.MaxIntPtr(s1t.SeenMaximum(), s2t.SeenMaximum()))
+		mergeStats(sr, PX_STATS, s1, s2)
 	} else if s2t, ok := s2.ToNumber(); ok {
 		s1t, _ := s1.ToNumber()
 		setIfNotNull(sr, P_MINIMUM, internal.MinFloat64Ptr(s1t.Minimum(), s2t.Minimum()))
 		setIfNotNull(sr, P_MAXIMUM, internal.MaxFloat64Ptr(s1t.Maximum(), s2t.Maximum()))
 		setIfNotNull(sr, PX_SEEN_MINIMUM, internal.MinFloat64Ptr(s1t.SeenMinimum(), s2t.SeenMinimum()))
 		setIfNotNull(sr, PX_SEEN_MAXIMUM, internal.MaxFloat64Ptr(s1t.SeenMaximum(), s2t.SeenMaximum()))
+		mergeStats(sr, PX_STATS, s1, s2)
 	} else if s2t, ok := s2.ToString(); ok {
 		s1t, _ := s1.ToString()
 		if jfmt != F_URI {
@@ -124,7 +188,14 @@ func Merge(ctx context.Context, in MergeInput) MergeOutput {
 		} else if uriLocs := internal.UniqueSortedStrings(append(s1t.SeenUriLocations(), s2t.SeenUriLocations()...)); len(uriLocs) > 0 {
 			sr[PX_URI_LOCATIONS] = uriLocs
 		}
-		if jfmt == F_NUMERICAL {
+		if jfmt == F_JSON {
+			// Both strings hold JSON documents, so learn the schema of the documents.
+			// Once other strings are seen, the content is dropped, like other formats.
+			sr[P_CONTENT_MEDIA_TYPE] = "application/json"
+			cmo := Merge(ctx, MergeInput{Key: string(P_CONTENT_SCHEMA), S1: s1t.ContentSchema(), S2: s2t.ContentSchema()})
+			sr[P_CONTENT_SCHEMA] = cmo.Schema
+			mo.TypeChanged = cmo.TypeChanged
+		} else if jfmt == F_NUMERICAL {
 			handleNumerical(sr, s1t, s2t)
 		} else if timecomp, ok := timeFormatValueComparers[jfmt]; ok {
 			sr[PX_SEEN_MINIMUM], _ = timecomp(internal.CompactStrings(s1t.SeenMinimum(), s2t.SeenMinimum())...)
@@ -134,15 +205,26 @@ func Merge(ctx context.Context, in MergeInput) MergeOutput {
 		setIfNotNull(sr, P_MAX_LENGTH, internal.MaxIntPtr(s1t.MaxLength(), s2t.MaxLength()))
 		setIfNotNull(sr, PX_SEEN_MIN_LENGTH, internal.MinIntPtr(s1t.SeenMinLength(), s2t.SeenMinLength()))
 		setIfNotNull(sr, PX_SEEN_MAX_LENGTH, internal.MaxIntPtr(s1t.SeenMaxLength(), s2t.SeenMaxLength()))
+		mergeStats(sr, PX_LENGTH_STATS, s1, s2)
 		if s1t.Sensitive() || s2t.Sensitive() {
 			sr[PX_SENSITIVE] = true
 		}
-		if enum := append(s1t.Enum(), s2t.Enum()...); len(enum) > 0 {
-			sr[P_ENUM] = enum
-		}
-		if seen := append(s1t.SeenStrings(), s2t.SeenStrings()...); len(seen) > 0 {
-			sr[PX_SEEN_STRINGS] = seen
+		if notEnum(s1t) || notEnum(s2t) {
+			// Once we know there is no enum, do not start collecting seen strings again.
+		} else {
+			if enum := append(s1t.Enum(), s2t.Enum()...); len(enum) > 0 {
+				sr[P_ENUM] = enum
+			}
+			if seen := append(s1t.SeenStrings(), s2t.SeenStrings()...); len(seen) > 0 {
+				sr[PX_SEEN_STRINGS] = seen
+			}
+			if sk := mergeSketches(s1t.SeenStringsSketch(), s2t.SeenStringsSketch()); sk != nil {
+				sr[PX_SEEN_STRINGS_SKETCH] = sk.String()
+			}
+			mergeValueCounts(sr, s1, s2)
 		}
+	} else if _, ok := s2.ToBoolean(); ok {
+		mergeValueCounts(sr, s1, s2)
 	} else if s2t, ok := s2.ToObject(); ok {
 		s1t, _ := s1.ToObject()
 		sr[P_PROPERTIES], mo.TypeChanged = mergeObjects(ctx, s1t, s2t)
@@ -171,11 +253,84 @@ func Merge(ctx context.Context, in MergeInput) MergeOutput {
 		}
 		setIfNotNull(sr, PX_SEEN_MIN_LENGTH, internal.MinIntPtr(s1t.SeenMinLength(), s2t.SeenMinLength()))
 		setIfNotNull(sr, PX_SEEN_MAX_LENGTH, internal.MaxIntPtr(s1t.SeenMaxLength(), s2t.SeenMaxLength()))
+		mergeStats(sr, PX_LENGTH_STATS, s1, s2)
 	}
+	mergeSeenWindows(sr, s1, s2)
+	mergeValuesLastSeen(sr, s1, s2)
 	postprocess(in.Key, sr)
 	return mo
 }
 
+// incrSamplesBy increments the samples of s by the samples of other,
+// or by 1 if other was newly derived (and has no samples).
+func incrSamplesBy(s, other Schema) {
+	if n := other.Samples(); n > 0 {
+		s[PX_SAMPLES] = s.Samples() + n
+	} else {
+		s.IncrSamples()
+	}
+}
+
+// setNulls sets the null count of s to n, if there were any nulls.
+func setNulls(s Schema, n int) {
+	if n > 0 {
+		s[PX_NULLS] = n
+	} else {
+		delete(s, PX_NULLS)
+	}
+}
+
+// mergeStats sets the statistics in f of sr to those of s1 and s2, if either has any.
+func mergeStats(sr Schema, f Field, s1, s2 Schema) {
+	if st := numstat.Merge(s1.Stats(f), s2.Stats(f)); st != nil {
+		sr[f] = st
+	}
+}
+
+// mergeValueCounts sets the value counts of sr to the sum of those of s1 and s2.
+func mergeValueCounts(sr, s1, s2 Schema) {
+	counts := s1.ValueCounts()
+	for v, n := range s2.ValueCounts() {
+		counts[v] += n
+	}
+	sr.SetValueCounts(counts)
+}
+
+// keepValueCounts removes the counts of values not in values.
+func keepValueCounts(sch Schema, values []string) {
+	counts := sch.ValueCounts()
+	keep := make(map[string]bool, len(values))
+	for _, v := range values {
+		keep[v] = true
+	}
+	for v := range counts {
+		if !keep[v] {
+			delete(counts, v)
+		}
+	}
+	sch.SetValueCounts(counts)
+}
+
+// notEnum returns true if s was merged previously,
+// and handleStringEnum decided it cannot be an enum.
+func notEnum(s StringSchema) bool {
+	return Schema(s).Samples() > 0 && len(s.Enum()) == 0 && len(s.SeenStrings()) == 0 && s.SeenStringsSketch() == nil
+}
+
+// mergeSketches returns a new sketch of what both sketches have seen, or nil if neither is present.
+func mergeSketches(sk1, sk2 hll.Sketch) hll.Sketch {
+	if sk1 == nil && sk2 == nil {
+		return nil
+	}
+	r := hll.New()
+	for _, sk := range []hll.Sketch{sk1, sk2} {
+		if sk != nil {
+			r.Merge(sk)
+		}
+	}
+	return r
+}
+
 func handleNumerical(sr Schema, t1 StringSchema, t2 StringSchema) {
 	t1min, _ := strconv.Atoi(*t1.SeenMinimum())
 	t1max, _ := strconv.Atoi(*t1.SeenMaximum())
@@ -218,6 +373,7 @@ func postprocess(key string, s Schema) {
 	handleIdentifier(key, s)
 	handleStringEnum(key, s)
 	handleZeroOne(key, s)
+	handleValuesLastSeen(key, s)
 }
 
 func handleIdentifier(key string, s Schema) {
@@ -239,7 +395,7 @@ func handleIdentifier(key string, s Schema) {
 	if *minlen != *maxlen {
 		return
 	}
-	if *minlen > 8 {
+	if *minlen > 8 && s.Format() != F_JSON {
 		s[PX_IDENTIFIER] = true
 	}
 }
@@ -252,8 +408,7 @@ func handleStringEnum(_ string, sch Schema) {
 	}
 	// If this is a sensitive string or an identifier, we know it cannot be an enum.
 	if sch.Format() == F_UUID4 || ssch.Sensitive() {
-		delete(sch, P_ENUM)
-		delete(sch, PX_SEEN_STRINGS)
+		deleteEnum(sch)
 		return
 	}
 	// We have enums in two fields: 'enum', and 'x-seenStrings'
@@ -262,16 +417,42 @@ func handleStringEnum(_ string, sch Schema) {
 	// - replace enum and remove seenStrings if we think we have good enums
 	// - delete enum and seenStrings if we know we don't have enums
 	// - delete enums and write seenStrings if we aren't sure.
+	// - delete enums and seenStrings, and write x-seenStringsSketch,
+	//   once we have seen too many strings for an enum and to keep them all.
 	allEnums := internal.UniqueSortedStrings(append(ssch.Enum(), ssch.SeenStrings()...))
+	sketch := ssch.SeenStringsSketch()
+	if len(allEnums) == 0 && sketch == nil {
+		// Seen strings are removed once we know there is no enum.
+		deleteEnum(sch)
+		return
+	}
 	allLikely := true
 	for _, s := range allEnums {
 		if !validEnumRegex.MatchString(s) {
-			delete(sch, P_ENUM)
-			delete(sch, PX_SEEN_STRINGS)
+			deleteEnum(sch)
 			return
 		}
 		allLikely = allLikely && likelyEnumRegex.MatchString(s)
 	}
+	if sketch != nil || len(allEnums) > MaxSeenStrings {
+		// We can't have an enum anymore, but keep estimating how many distinct strings there are,
+		// without the schema (and the cost of merging it) growing with every new string.
+		if sketch == nil {
+			sketch = hll.New()
+		} else {
+			sketch = sketch.Clone()
+		}
+		for _, s := range allEnums {
+			sketch.Add(s)
+		}
+		delete(sch, P_ENUM)
+		delete(sch, PX_SEEN_STRINGS)
+		delete(sch, PX_VALUE_COUNTS)
+		sch[PX_SEEN_STRINGS_SKETCH] = sketch.String()
+		sch[PX_DISTINCT_STRINGS] = sketch.Estimate()
+		return
+	}
+	keepValueCounts(sch, allEnums)
 	samples := sch.Samples()
 	if samples <= 10 {
 		// Maybe this is an enum, we'll know more later when we've sampled more.
@@ -292,6 +473,19 @@ func handleStringEnum(_ string, sch Schema) {
 	return
 }
 
+func deleteEnum(sch Schema) {
+	delete(sch, P_ENUM)
+	delete(sch, PX_SEEN_STRINGS)
+	delete(sch, PX_SEEN_STRINGS_SKETCH)
+	delete(sch, PX_DISTINCT_STRINGS)
+	delete(sch, PX_VALUE_COUNTS)
+}
+
+// MaxSeenStrings is the most strings kept in 'x-seenStrings' while deciding if a string is an enum.
+// Beyond this, the strings are only counted, in 'x-seenStringsSketch' and 'x-distinctStrings'.
+// It is well above the most values an enum can have.
+const MaxSeenStrings = 64
+
 // valid enums start with a letter and contain only upper OR lowercase,
 // plus numbers and underscores
 var validEnumRegex = regexp.MustCompile("^[a-zA-Z]([a-z0-9_]|[A-Z0-9_])+$")
@@ -355,22 +549,40 @@ func mergeSliceProperty(ctx context.Context, f Field, s1, s2 Schema) ([]Schema,
 	} else {
 		flat = append(flat, s2)
 	}
-	accum := make(map[string]Schema, len(flat))
+	accum := make(map[jsontype.JsonType]Schema, len(flat))
 	for _, s := range flat {
-		key := fmt.Sprintf("%s-%s", s.Type(), s.Format())
+		key := sliceKey(s)
 		if entry, ok := accum[key]; ok {
 			accum[key] = Merge(ctx, MergeInput{S1: entry, S2: s}).Schema
 		} else {
 			accum[key] = s
 		}
 	}
-	uniq := make([]Schema, 0, len(accum))
-	for _, sch := range accum {
-		uniq = append(uniq, sch)
+	keys := make([]jsontype.JsonType, 0, len(accum))
+	for k := range accum {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	uniq := make([]Schema, len(keys))
+	for i, k := range keys {
+		uniq[i] = accum[k]
 	}
 	return uniq, len(uniq) == len(flat)
 }
 
+// sliceKey returns the key of the schema in a merged slice property.
+// Schemas with the same key are merged, like they would be outside the slice,
+// so integers go with numbers, and strings of different formats are merged.
+func sliceKey(s Schema) jsontype.JsonType {
+	if t := s.Type(); t != jsontype.T_INTEGER {
+		return t
+	}
+	return jsontype.T_NUMBER
+}
+
+// formatCoercions are the formats of merged numbers. Each pair goes to the narrowest format that holds both,
+// so merging in any order gives the same format. Millisecond timestamps don't fit in 32 bits,
+// so they only go with int64 and double.
 var formatCoercions = (func() map[JsonFormat]map[JsonFormat]JsonFormat {
 	m := make(map[JsonFormat]map[JsonFormat]JsonFormat, 20)
 	c := func(left JsonFormat, right JsonFormat, to JsonFormat) {
@@ -394,7 +606,7 @@ var formatCoercions = (func() map[JsonFormat]map[JsonFormat]JsonFormat {
 	c(F_FLOAT, F_INT32, F_FLOAT)
 	c(F_FLOAT, F_INT64, F_DOUBLE)
 	c(F_FLOAT, F_TIMESTAMP, F_FLOAT)
-	c(F_FLOAT, F_TIMESTAMP_MS, F_FLOAT)
+	c(F_FLOAT, F_TIMESTAMP_MS, F_DOUBLE)
 	c(F_FLOAT, F_ZERO_ONE, F_FLOAT)
 
 	c(F_INT32, F_DOUBLE, F_DOUBLE)
@@ -402,7 +614,7 @@ var formatCoercions = (func() map[JsonFormat]map[JsonFormat]JsonFormat {
 	c(F_INT32, F_INT32, F_INT32)
 	c(F_INT32, F_INT64, F_INT64)
 	c(F_INT32, F_TIMESTAMP, F_INT32)
-	c(F_INT32, F_TIMESTAMP_MS, F_INT32)
+	c(F_INT32, F_TIMESTAMP_MS, F_INT64)
 	c(F_INT32, F_ZERO_ONE, F_INT32)
 
 	c(F_INT64, F_DOUBLE, F_DOUBLE)
@@ -418,16 +630,16 @@ var formatCoercions = (func() map[JsonFormat]map[JsonFormat]JsonFormat {
 	c(F_TIMESTAMP, F_INT32, F_INT32)
 	c(F_TIMESTAMP, F_INT64, F_INT64)
 	c(F_TIMESTAMP, F_TIMESTAMP, F_TIMESTAMP)
-	c(F_TIMESTAMP, F_TIMESTAMP_MS, F_DOUBLE)
-	c(F_TIMESTAMP, F_ZERO_ONE, F_DOUBLE)
+	c(F_TIMESTAMP, F_TIMESTAMP_MS, F_INT64)
+	c(F_TIMESTAMP, F_ZERO_ONE, F_INT32)
 
 	c(F_TIMESTAMP_MS, F_DOUBLE, F_DOUBLE)
-	c(F_TIMESTAMP_MS, F_FLOAT, F_FLOAT)
-	c(F_TIMESTAMP_MS, F_INT32, F_INT32)
+	c(F_TIMESTAMP_MS, F_FLOAT, F_DOUBLE)
+	c(F_TIMESTAMP_MS, F_INT32, F_INT64)
 	c(F_TIMESTAMP_MS, F_INT64, F_INT64)
-	c(F_TIMESTAMP_MS, F_TIMESTAMP, F_DOUBLE)
+	c(F_TIMESTAMP_MS, F_TIMESTAMP, F_INT64)
 	c(F_TIMESTAMP_MS, F_TIMESTAMP_MS, F_TIMESTAMP_MS)
-	c(F_TIMESTAMP_MS, F_ZERO_ONE, F_DOUBLE)
+	c(F_TIMESTAMP_MS, F_ZERO_ONE, F_INT64)
 
 	return m
 })()
@@ -467,55 +679,212 @@ type MergeManyInput struct {
 	// How many examples to record. Examples are only recorded when there is a significant schema change,
 	// like a type changes (new object properties are not recorded).
 	// If nil, do not modify examples. If <= 0, delete examples. If > 0, keep only that many examples
-	// (total examples are randomly sampled to achieve ExampleLimit examples).
+	// (total examples are randomly sampled to achieve ExampleLimit examples, see moxrand.WithSeed).
 	ExampleLimit *int
+	// How many goroutines derive payload schemas. If <= 1, payloads are merged sequentially.
+	// Otherwise, payload schemas are derived in parallel, and merged in the order the payloads were read,
+	// so the result, including examples and TypeChanged, is the same as merging sequentially.
+	Workers int
+	// If set, record when and where each schema node was first and last seen
+	// (see schema.Schema.FirstSeen and schema.Schema.LastSeen).
+	Provenance *ProvenanceInput
 }
 
 type MergeManyOutput struct {
 	Schema Schema
+	// True if any payload changed a type in the schema.
+	// These are the payloads that are recorded as examples.
+	TypeChanged bool
 }
 
 // MergeMany merges payloads into a Schema.
 // It can optionally record examples.
 // If you only need to process one payload, use MergeOne.
 func MergeMany(ctx context.Context, in MergeManyInput) (MergeManyOutput, error) {
-	result := in.Schema
-	var newExamples []interface{}
-	for in.PayloadIterator.Next() {
-		msg, err := in.PayloadIterator.Read(ctx)
-		if err != nil {
-			return MergeManyOutput{Schema: result}, errors.Wrap(err, "payload loader iterator")
-		}
-		newSchema := Derive("", msg)
-		mout := Merge(ctx, MergeInput{Key: "", S1: result, S2: newSchema})
-		result = mout.Schema
-		if mout.TypeChanged {
-			newExamples = append(newExamples, msg)
-		}
+	var sh shard
+	var err error
+	if in.Provenance != nil {
+		in.PayloadIterator = &provenanceIterator{Iterator: in.PayloadIterator, in: *in.Provenance}
+	}
+	if in.Workers > 1 {
+		sh, err = mergeParallel(ctx, in.Schema, in.PayloadIterator, in.Workers)
+	} else {
+		sh, err = mergeShard(ctx, in.Schema, in.PayloadIterator)
+	}
+	if err != nil {
+		return MergeManyOutput{Schema: sh.schema}, err
 	}
+	result, newExamples, typeChanged := sh.schema, sh.examples, sh.typeChanged
 	if in.ExampleLimit == nil {
 	} else if *in.ExampleLimit > 0 {
-		result[P_EXAMPLES] = fp.SampleOut(append(Examples(result), newExamples...), *in.ExampleLimit)
+		result[P_EXAMPLES] = fp.SampleOut(moxrand.FromContext(ctx), append(Examples(result), newExamples...), *in.ExampleLimit)
 	} else {
 		delete(result, P_EXAMPLES)
 	}
-	return MergeManyOutput{Schema: result}, nil
+	return MergeManyOutput{Schema: result, TypeChanged: typeChanged}, nil
+}
+
+// shard is the result of merging some payloads.
+type shard struct {
+	schema Schema
+	// Payloads that changed a type.
+	examples    []interface{}
+	typeChanged bool
+}
+
+func mergeShard(ctx context.Context, sch Schema, it moxio.Iterator) (shard, error) {
+	r := shard{schema: sch}
+	for it.Next() {
+		msg, err := it.Read(ctx)
+		if err != nil {
+			return r, errors.Wrap(err, "payload loader iterator")
+		}
+		r.add(ctx, derivePayload(msg))
+	}
+	return r, nil
+}
+
+// derivedPayload is a payload and the schema derived from it.
+type derivedPayload struct {
+	// Position of the payload in the payload iterator.
+	pos     int
+	payload interface{}
+	schema  Schema
+}
+
+func derivePayload(msg interface{}) derivedPayload {
+	msg, provenance := unwrapProvenance(msg)
+	newSchema := Derive("", msg)
+	if provenance != nil {
+		newSchema.Stamp(*provenance)
+	}
+	return derivedPayload{payload: msg, schema: newSchema}
+}
+
+// add merges a derived payload into the shard, recording the payload as an example if it changed a type.
+func (r *shard) add(ctx context.Context, d derivedPayload) {
+	mout := Merge(ctx, MergeInput{Key: "", S1: r.schema, S2: d.schema})
+	r.schema = mout.Schema
+	if mout.TypeChanged {
+		r.typeChanged = true
+		r.examples = append(r.examples, d.payload)
+	}
+}
+
+// mergeParallel derives payload schemas on the workers, and merges them on one goroutine
+// in the order the payloads were read, so the result (including examples) is the same as mergeShard.
+func mergeParallel(ctx context.Context, sch Schema, it moxio.Iterator, workers int) (shard, error) {
+	derived := make(chan derivedPayload, workers)
+	merged := make(chan shard)
+	go func() {
+		r := shard{schema: sch}
+		pending := make(map[int]derivedPayload)
+		next := 0
+		for d := range derived {
+			pending[d.pos] = d
+			for d, ok := pending[next]; ok; d, ok = pending[next] {
+				delete(pending, next)
+				next++
+				r.add(ctx, d)
+			}
+		}
+		merged <- r
+	}()
+	err := moxio.Shard(ctx, &positionIterator{Iterator: it}, workers, func(ctx context.Context, _ int, it moxio.Iterator) error {
+		for it.Next() {
+			item, err := it.Read(ctx)
+			if err != nil {
+				return err
+			}
+			p := item.(positioned)
+			d := derivePayload(p.item)
+			d.pos = p.pos
+			derived <- d
+		}
+		return nil
+	})
+	close(derived)
+	r := <-merged
+	return r, err
+}
+
+// positionIterator wraps each item with its position in the iterator,
+// so items can be put back in order after being processed out of order.
+type positionIterator struct {
+	moxio.Iterator
+	pos int
+}
+
+type positioned struct {
+	pos  int
+	item interface{}
+}
+
+func (it *positionIterator) Read(ctx context.Context) (interface{}, error) {
+	item, err := it.Iterator.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p := positioned{pos: it.pos, item: item}
+	it.pos++
+	return p, nil
 }
 
 type MergeOneInput struct {
 	Schema       Schema
 	Payload      interface{}
 	ExampleLimit *int
+	Provenance   *ProvenanceInput
+	// If set, stamp the payload with this provenance instead of Provenance,
+	// like when the payload's position comes from another iterator (see ProvenanceInput.Of).
+	Seen *Provenance
 }
 
 type MergeOneOutput MergeManyOutput
 
 func MergeOne(ctx context.Context, in MergeOneInput) (MergeOneOutput, error) {
+	var payload interface{} = in.Payload
+	if in.Seen != nil {
+		payload = provenanced{payload: in.Payload, provenance: *in.Seen}
+		in.Provenance = nil
+	}
 	out, err := MergeMany(ctx, MergeManyInput{
 		Schema:          in.Schema,
 		ExampleLimit:    in.ExampleLimit,
-		PayloadIterator: moxio.NewMemoryIterator([]interface{}{in.Payload}),
+		PayloadIterator: moxio.NewMemoryIterator([]interface{}{payload}),
+		Provenance:      in.Provenance,
 	})
 	return MergeOneOutput(out), err
 
 }
+
+type MergeSchemasInput struct {
+	// Schemas learned independently, like by MergeMany on different shards of payloads.
+	Schemas []Schema
+	// Like MergeManyInput.ExampleLimit, except that if nil, the examples of all schemas are kept.
+	ExampleLimit *int
+}
+
+// MergeSchemas folds learned schemas into one, as if a single MergeMany had seen all of their payloads.
+// Samples are added together, and seen strings, ranges, and URI locations are combined.
+// Empty schemas are ignored. The input schemas are not modified.
+func MergeSchemas(ctx context.Context, in MergeSchemasInput) Schema {
+	result := Schema{}
+	var examples []interface{}
+	for _, sch := range in.Schemas {
+		if len(sch) == 0 {
+			continue
+		}
+		examples = append(examples, Examples(sch)...)
+		result = Merge(ctx, MergeInput{Key: "", S1: result, S2: sch}).Schema
+	}
+	delete(result, P_EXAMPLES)
+	if in.ExampleLimit == nil {
+		if len(examples) > 0 {
+			result[P_EXAMPLES] = examples
+		}
+	} else if *in.ExampleLimit > 0 && len(examples) > 0 {
+		result[P_EXAMPLES] = fp.SampleOut(moxrand.FromContext(ctx), examples, *in.ExampleLimit)
+	}
+	return result
+}
@@ -2,13 +2,19 @@ package schemamerge_test
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"github.com/lithictech/moxpopuli/fixturegen"
+	"github.com/lithictech/moxpopuli/internal/moxtest"
 	"github.com/lithictech/moxpopuli/jsonformat"
 	"github.com/lithictech/moxpopuli/jsontype"
+	"github.com/lithictech/moxpopuli/moxio"
+	"github.com/lithictech/moxpopuli/moxrand"
 	"github.com/lithictech/moxpopuli/schema"
 	"github.com/lithictech/moxpopuli/schemamerge"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"math/rand"
 	"testing"
 	"time"
 )
@@ -51,4 +57,545 @@ var _ = Describe("schemamerge", func() {
 			HaveKeyWithValue("date-time-notz", HaveKeyWithValue(schema.P_FORMAT, jsonformat.F_DATETIME_NOTZ)),
 		))
 	})
+
+	It("merges payloads in parallel the same as sequentially", func() {
+		payloads := make([]interface{}, 200)
+		for i := range payloads {
+			payloads[i] = fixturegen.Generate(fixturegen.GenerateInput{TZ: time.UTC})
+		}
+		limit := 5
+		merge := func(workers int) (schemamerge.MergeManyOutput, string) {
+			out, err := schemamerge.MergeMany(moxrand.WithSeed(ctx, 3), schemamerge.MergeManyInput{
+				PayloadIterator: moxio.NewMemoryIterator(payloads),
+				ExampleLimit:    &limit,
+				Workers:         workers,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			b, err := json.Marshal(out.Schema)
+			Expect(err).ToNot(HaveOccurred())
+			return out, string(b)
+		}
+		seqOut, seq := merge(1)
+		parOut, par := merge(4)
+		Expect(par).To(MatchJSON(seq))
+		Expect(parOut.TypeChanged).To(Equal(seqOut.TypeChanged))
+		Expect(schema.Examples(parOut.Schema)).ToNot(BeEmpty())
+		Expect(len(schema.Examples(parOut.Schema))).To(BeNumerically("<=", limit))
+	})
+
+	It("records the same examples in parallel as sequentially when merging into a starting schema", func() {
+		payloads := make([]interface{}, 0, 201)
+		for i := 0; i < 200; i++ {
+			payloads = append(payloads, map[string]interface{}{"a": i})
+		}
+		payloads = append(payloads, map[string]interface{}{"a": "s"})
+		limit := 100
+		merge := func(workers int) schemamerge.MergeManyOutput {
+			out, err := schemamerge.MergeMany(ctx, schemamerge.MergeManyInput{
+				Schema:          moxtest.LearnSchema(ctx, map[string]interface{}{"a": 1}),
+				PayloadIterator: moxio.NewMemoryIterator(payloads),
+				ExampleLimit:    &limit,
+				Workers:         workers,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			return out
+		}
+		seq := merge(1)
+		Expect(seq.TypeChanged).To(BeTrue())
+		Expect(schema.Examples(seq.Schema)).To(Equal([]interface{}{map[string]interface{}{"a": "s"}}))
+		for i := 0; i < 5; i++ {
+			par := merge(4)
+			Expect(par.TypeChanged).To(BeTrue())
+			Expect(par.Schema).To(Equal(seq.Schema))
+		}
+	})
+
+	It("adds the samples of previously merged schemas", func() {
+		s1 := moxtest.LearnSchema(ctx, map[string]interface{}{"x": 1}, map[string]interface{}{"x": 2})
+		s2 := moxtest.LearnSchema(ctx, map[string]interface{}{"x": 10}, map[string]interface{}{"x": nil}, map[string]interface{}{"x": 3})
+		m := schemamerge.Merge(ctx, schemamerge.MergeInput{Key: "", S1: s1, S2: s2})
+		Expect(m.Schema.Samples()).To(Equal(5))
+		Expect(m.Schema.MustObject().Properties()["x"]).To(And(
+			HaveKeyWithValue(schema.PX_SAMPLES, 5),
+			HaveKeyWithValue(schema.PX_NULLABLE, true),
+			HaveKeyWithValue(schema.PX_NULLS, 1),
+			HaveKeyWithValue(schema.PX_SEEN_MINIMUM, 1),
+			HaveKeyWithValue(schema.PX_SEEN_MAXIMUM, 10),
+		))
+	})
+
+	It("learns the schema of JSON documents encoded in strings", func() {
+		out, err := schemamerge.MergeMany(ctx, schemamerge.MergeManyInput{PayloadIterator: moxio.NewMemoryIterator([]interface{}{
+			map[string]interface{}{"metadata": `{"order_id": 5}`},
+			map[string]interface{}{"metadata": `{"order_id": 7, "note": "rush"}`},
+		})})
+		Expect(err).ToNot(HaveOccurred())
+		metadata, _ := out.Schema.MustObject().Properties()["metadata"].ToString()
+		Expect(metadata).To(And(
+			HaveKeyWithValue(schema.P_FORMAT, jsonformat.F_JSON),
+			HaveKeyWithValue(schema.P_CONTENT_MEDIA_TYPE, "application/json"),
+			Not(HaveKey(schema.PX_SENSITIVE)),
+			Not(HaveKey(schema.PX_SEEN_STRINGS)),
+		))
+		props := metadata.ContentSchema().MustObject().Properties()
+		Expect(props["order_id"]).To(And(
+			HaveKeyWithValue(schema.P_TYPE, jsontype.T_INTEGER),
+			HaveKeyWithValue(schema.PX_SAMPLES, 2),
+			HaveKeyWithValue(schema.PX_SEEN_MAXIMUM, 7),
+		))
+		Expect(props["note"]).To(HaveKeyWithValue(schema.P_TYPE, jsontype.T_STRING))
+
+		m := schemamerge.Merge(ctx, schemamerge.MergeInput{S1: out.Schema, S2: schema.Derive("", map[string]interface{}{"metadata": "plain"})})
+		Expect(m.Schema.MustObject().Properties()["metadata"]).ToNot(Or(
+			HaveKey(schema.P_CONTENT_SCHEMA),
+			HaveKey(schema.P_CONTENT_MEDIA_TYPE),
+			HaveKey(schema.P_FORMAT),
+		))
+	})
+
+	Describe("merge order", func() {
+		withoutExamples := func(sch schema.Schema) string {
+			sch = sch.DeepClone()
+			delete(sch, schema.P_EXAMPLES)
+			b, err := json.Marshal(sch)
+			Expect(err).ToNot(HaveOccurred())
+			return string(b)
+		}
+		mergeOut := func(ctx context.Context, payloads []interface{}, workers int) schemamerge.MergeManyOutput {
+			limit := 3
+			out, err := schemamerge.MergeMany(ctx, schemamerge.MergeManyInput{
+				PayloadIterator: moxio.NewMemoryIterator(payloads),
+				ExampleLimit:    &limit,
+				Workers:         workers,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			return out
+		}
+		merge := func(ctx context.Context, payloads []interface{}, workers int) schema.Schema {
+			return mergeOut(ctx, payloads, workers).Schema
+		}
+		payloads := func() []interface{} {
+			var result []interface{}
+			for i := 0; i < 60; i++ {
+				result = append(result, fixturegen.Generate(fixturegen.GenerateInput{TZ: time.UTC}))
+			}
+			for i := 0; i < 30; i++ {
+				p := map[string]interface{}{
+					"enum":      []interface{}{"active", "inactive", "PENDING"}[i%3],
+					"notenum":   []interface{}{"active", "inactive", "not an enum"}[i%3],
+					"zeroone":   i % 2,
+					"number":    []interface{}{i, float64(i) + 0.5, 1 << 40}[i%3],
+					"mixed":     []interface{}{i, "str", map[string]interface{}{"x": i}, true, nil}[i%5],
+					"nullable":  []interface{}{nil, "abc", "abcdef"}[i%3],
+					"array":     []interface{}{[]interface{}{}, []interface{}{i}, []interface{}{"x", i}}[i%3],
+					"nestednil": map[string]interface{}{"x": []interface{}{nil, i, float64(i) / 3}[i%3]},
+				}
+				if i%5 == 0 {
+					p["sometimes"] = "present"
+				}
+				result = append(result, p)
+			}
+			return result
+		}()
+
+		It("is the same for payloads in any order", func() {
+			expected := withoutExamples(merge(ctx, payloads, 1))
+			for i := int64(0); i < 10; i++ {
+				shuffled := make([]interface{}, len(payloads))
+				copy(shuffled, payloads)
+				r := rand.New(rand.NewSource(i))
+				r.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+				seq := mergeOut(moxrand.WithSeed(ctx, i), shuffled, 1)
+				par := mergeOut(moxrand.WithSeed(ctx, i), shuffled, 4)
+				// Examples depend on which payloads changed a type, so only the rest of the schema is order-independent.
+				Expect(withoutExamples(seq.Schema)).To(MatchJSON(expected), "seed %d", i)
+				Expect(par.Schema).To(Equal(seq.Schema), "seed %d", i)
+				Expect(par.TypeChanged).To(Equal(seq.TypeChanged), "seed %d", i)
+			}
+		})
+
+		It("is the same when merging learned schemas in any order", func() {
+			expected := withoutExamples(merge(ctx, payloads, 1))
+			schemas := []schema.Schema{
+				merge(ctx, payloads[:20], 1),
+				merge(ctx, payloads[20:50], 1),
+				merge(ctx, payloads[50:], 1),
+			}
+			for _, order := range [][]int{{0, 1, 2}, {2, 1, 0}, {1, 2, 0}} {
+				merged := schemamerge.MergeSchemas(ctx, schemamerge.MergeSchemasInput{
+					Schemas: []schema.Schema{schemas[order[0]], schemas[order[1]], schemas[order[2]]},
+				})
+				Expect(withoutExamples(merged)).To(MatchJSON(expected), "order %v", order)
+			}
+		})
+
+		It("only counts seen strings once there are too many to keep", func() {
+			var tags []interface{}
+			for i := 0; i < 300; i++ {
+				tags = append(tags, map[string]interface{}{"tag": fmt.Sprintf("tag%d", i%150)})
+			}
+			learned := merge(ctx, tags, 1)
+			tag := learned.MustObject().Properties()["tag"]
+			Expect(tag).ToNot(HaveKey(schema.PX_SEEN_STRINGS))
+			Expect(tag).ToNot(HaveKey(schema.P_ENUM))
+			Expect(tag).To(HaveKeyWithValue(schema.PX_SEEN_STRINGS_SKETCH, HaveLen(344)))
+			Expect(tag).To(HaveKeyWithValue(schema.PX_DISTINCT_STRINGS, BeNumerically("~", 150, 20)))
+
+			expected := withoutExamples(learned)
+			r := rand.New(rand.NewSource(1))
+			r.Shuffle(len(tags), func(i, j int) { tags[i], tags[j] = tags[j], tags[i] })
+			Expect(withoutExamples(merge(ctx, tags, 4))).To(MatchJSON(expected))
+			merged := schemamerge.MergeSchemas(ctx, schemamerge.MergeSchemasInput{
+				Schemas: []schema.Schema{merge(ctx, tags[:40], 1), merge(ctx, tags[40:], 1)},
+			})
+			Expect(withoutExamples(merged)).To(MatchJSON(expected))
+		})
+
+		It("samples the same examples with the same seed", func() {
+			s1 := merge(moxrand.WithSeed(ctx, 5), payloads, 1)
+			s2 := merge(moxrand.WithSeed(ctx, 5), payloads, 1)
+			Expect(schema.Examples(s1)).To(HaveLen(3))
+			Expect(schema.Examples(s1)).To(Equal(schema.Examples(s2)))
+		})
+
+		It("coerces numeric formats the same in any order", func() {
+			formats := []jsonformat.JsonFormat{
+				jsonformat.F_NOFORMAT, jsonformat.F_ZERO_ONE, jsonformat.F_INT32, jsonformat.F_INT64,
+				jsonformat.F_TIMESTAMP, jsonformat.F_TIMESTAMP_MS, jsonformat.F_FLOAT, jsonformat.F_DOUBLE,
+			}
+			for _, a := range formats {
+				for _, b := range formats {
+					Expect(schemamerge.MergeFormat(a, b)).To(Equal(schemamerge.MergeFormat(b, a)), "%s %s", a, b)
+					for _, c := range formats {
+						left := schemamerge.MergeFormat(schemamerge.MergeFormat(a, b), c)
+						right := schemamerge.MergeFormat(a, schemamerge.MergeFormat(b, c))
+						Expect(left).To(Equal(right), "%s %s %s", a, b, c)
+					}
+				}
+			}
+		})
+
+		It("keeps millisecond timestamps merged with other integers as int64", func() {
+			payloads := []interface{}{
+				map[string]interface{}{"at": 1_700_000_000_000},
+				map[string]interface{}{"at": 1_700_000_000},
+				map[string]interface{}{"at": 5},
+			}
+			for _, p := range [][]interface{}{payloads, {payloads[2], payloads[1], payloads[0]}} {
+				sch := merge(ctx, p, 1)
+				Expect(sch.MustObject().Properties()["at"]).To(SatisfyAll(
+					HaveKeyWithValue(schema.P_TYPE, jsontype.T_INTEGER),
+					HaveKeyWithValue(schema.P_FORMAT, jsonformat.F_INT64),
+				))
+			}
+		})
+	})
+
+	Describe("provenance", func() {
+		merge := func(payloads []interface{}, workers int, in schemamerge.ProvenanceInput) schema.Schema {
+			out, err := schemamerge.MergeMany(ctx, schemamerge.MergeManyInput{
+				PayloadIterator: moxio.NewMemoryIterator(payloads),
+				Workers:         workers,
+				Provenance:      &in,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			return out.Schema
+		}
+
+		It("records when and where each node was first and last seen", func() {
+			sch := merge([]interface{}{
+				map[string]interface{}{"id": "evt_2", "created": "2022-01-02T00:00:00Z", "x": 1},
+				map[string]interface{}{"id": "evt_1", "created": 1640995200, "x": "a"},
+				map[string]interface{}{"id": "evt_3", "created": "2022-01-03T00:00:00Z", "new": nil},
+			}, 1, schemamerge.ProvenanceInput{TimeField: "created", SourceField: "id"})
+			Expect(sch).To(And(
+				HaveKeyWithValue(schema.PX_FIRST_SEEN, "2022-01-01T00:00:00Z"),
+				HaveKeyWithValue(schema.PX_FIRST_SOURCE, "evt_1"),
+				HaveKeyWithValue(schema.PX_LAST_SEEN, "2022-01-03T00:00:00Z"),
+				HaveKeyWithValue(schema.PX_LAST_SOURCE, "evt_3"),
+			))
+			props := sch[schema.P_PROPERTIES].(map[string]schema.Schema)
+			Expect(props["x"]).To(And(
+				HaveKeyWithValue(schema.PX_FIRST_SOURCE, "evt_1"),
+				HaveKeyWithValue(schema.PX_LAST_SOURCE, "evt_2"),
+			))
+			Expect(props["x"][schema.P_ONE_OF]).To(ConsistOf(
+				HaveKeyWithValue(schema.PX_FIRST_SOURCE, "evt_2"),
+				HaveKeyWithValue(schema.PX_FIRST_SOURCE, "evt_1"),
+			))
+			Expect(props["new"]).To(And(
+				HaveKeyWithValue(schema.PX_FIRST_SEEN, "2022-01-03T00:00:00Z"),
+				HaveKeyWithValue(schema.PX_LAST_SOURCE, "evt_3"),
+			))
+		})
+
+		It("uses the merge time and payload position if there are no fields", func() {
+			now := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+			payloads := []interface{}{
+				map[string]interface{}{"a": 1},
+				map[string]interface{}{"a": 2, "b": true},
+				map[string]interface{}{"a": 3},
+			}
+			in := schemamerge.ProvenanceInput{Source: "payloads.jsonl", Now: func() time.Time { return now }}
+			sch := merge(payloads, 1, in)
+			Expect(sch).To(And(
+				HaveKeyWithValue(schema.PX_FIRST_SEEN, "2022-05-01T12:00:00Z"),
+				HaveKeyWithValue(schema.PX_FIRST_SOURCE, "payloads.jsonl:1"),
+				HaveKeyWithValue(schema.PX_LAST_SOURCE, "payloads.jsonl:3"),
+			))
+			Expect(sch[schema.P_PROPERTIES]).To(HaveKeyWithValue("b", And(
+				HaveKeyWithValue(schema.PX_FIRST_SOURCE, "payloads.jsonl:2"),
+				HaveKeyWithValue(schema.PX_LAST_SOURCE, "payloads.jsonl:2"),
+			)))
+			Expect(merge(payloads, 3, in)).To(Equal(sch))
+		})
+
+		It("keeps the earliest and latest provenance when merging learned schemas", func() {
+			s1 := schema.Schema{schema.P_TYPE: jsontype.T_BOOLEAN, schema.PX_SAMPLES: 1}
+			s1.Stamp(schema.Provenance{At: time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC), Source: "b"})
+			s2 := schema.Schema{schema.P_TYPE: jsontype.T_BOOLEAN, schema.PX_SAMPLES: 1}
+			s2.Stamp(schema.Provenance{At: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), Source: "a"})
+			for _, order := range [][]schema.Schema{{s1, s2}, {s2, s1}, {schema.Schema{schema.P_TYPE: jsontype.T_BOOLEAN}, s1, s2}} {
+				merged := schemamerge.MergeSchemas(ctx, schemamerge.MergeSchemasInput{Schemas: order})
+				Expect(merged).To(And(
+					HaveKeyWithValue(schema.PX_FIRST_SEEN, "2022-01-01T00:00:00Z"),
+					HaveKeyWithValue(schema.PX_FIRST_SOURCE, "a"),
+					HaveKeyWithValue(schema.PX_LAST_SEEN, "2022-01-02T00:00:00Z"),
+					HaveKeyWithValue(schema.PX_LAST_SOURCE, "b"),
+				))
+			}
+		})
+
+		It("rolls old days into months and years, the same in any order", func() {
+			start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+			var payloads []interface{}
+			for d := 0; d < 1000; d += 5 {
+				payloads = append(payloads, map[string]interface{}{"at": start.AddDate(0, 0, d).Format(time.RFC3339), "n": d})
+			}
+			in := schemamerge.ProvenanceInput{TimeField: "at"}
+			sch := merge(payloads, 1, in)
+			n := sch[schema.P_PROPERTIES].(map[string]schema.Schema)["n"]
+			windows := n.SeenWindows()
+			lens := map[int]int{}
+			samples := 0
+			for k, w := range windows {
+				lens[len(k)]++
+				samples += w.Samples()
+			}
+			Expect(samples).To(Equal(len(payloads)))
+			Expect(lens).To(Equal(map[int]int{
+				len(schema.WindowLayout):      schema.MaxDayWindows,
+				len(schema.MonthWindowLayout): schema.MaxMonthWindows,
+				len(schema.YearWindowLayout):  1,
+			}))
+			Expect(windows).To(HaveKey("2020"))
+			Expect(windows["2020"]).To(HaveKeyWithValue(schema.PX_SEEN_MINIMUM, 0))
+
+			b, err := json.Marshal(sch)
+			Expect(err).ToNot(HaveOccurred())
+			for _, workers := range []int{1, 4} {
+				shuffled := make([]interface{}, len(payloads))
+				copy(shuffled, payloads)
+				rand.New(rand.NewSource(int64(workers))).Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+				b2, err := json.Marshal(merge(shuffled, workers, in))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(b2).To(MatchJSON(b))
+			}
+		})
+	})
+
+	Describe("Prune", func() {
+		day := func(d int) time.Time { return time.Date(2022, 1, d, 12, 0, 0, 0, time.UTC) }
+		learnSeen := func(payloads ...interface{}) schema.Schema {
+			out, err := schemamerge.MergeMany(ctx, schemamerge.MergeManyInput{
+				PayloadIterator: moxio.NewMemoryIterator(payloads),
+				Provenance:      &schemamerge.ProvenanceInput{TimeField: "at", SourceField: "id"},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			return out.Schema
+		}
+		payload := func(d int, fields map[string]interface{}) map[string]interface{} {
+			fields["at"] = day(d).Format(time.RFC3339)
+			return fields
+		}
+
+		It("forgets properties, oneOf schemas, and enum values not seen since the cutoff", func() {
+			sch := learnSeen(
+				payload(1, map[string]interface{}{"old": 1, "mixed": 1, "status": "gone", "n": 1000}),
+				payload(10, map[string]interface{}{"mixed": "abc", "status": "active", "n": 5}),
+				payload(11, map[string]interface{}{"mixed": "def", "status": "active", "n": 7}),
+			)
+			before := sch.DeepClone()
+			out := schemamerge.Prune(schemamerge.PruneInput{Schema: sch, Since: day(5)})
+			Expect(sch).To(Equal(before))
+			Expect(out.Pruned).To(ConsistOf(
+				schemamerge.Pruned{Path: "$.old", Kind: schemamerge.PR_PROPERTY, LastSeen: day(1)},
+				schemamerge.Pruned{Path: "$.mixed", Kind: schemamerge.PR_ONE_OF, Value: "integer", LastSeen: day(1)},
+				schemamerge.Pruned{Path: "$.status", Kind: schemamerge.PR_ENUM_VALUE, Value: "gone", LastSeen: day(1)},
+			))
+			props := out.Schema[schema.P_PROPERTIES].(map[string]schema.Schema)
+			Expect(props).ToNot(HaveKey("old"))
+			Expect(props["mixed"]).To(HaveKeyWithValue(schema.P_TYPE, jsontype.T_STRING))
+			Expect(props["status"]).To(And(
+				HaveKeyWithValue(schema.PX_SEEN_STRINGS, []string{"active"}),
+				HaveKeyWithValue(schema.PX_VALUE_COUNTS, map[string]int{"active": 2}),
+			))
+			Expect(props["n"]).To(And(
+				HaveKeyWithValue(schema.PX_SEEN_MINIMUM, 5),
+				HaveKeyWithValue(schema.PX_SEEN_MAXIMUM, 7),
+				HaveKeyWithValue(schema.PX_SEEN_WINDOWS, HaveLen(2)),
+			))
+			Expect(props["at"]).To(HaveKeyWithValue(schema.PX_SEEN_MINIMUM, "2022-01-10T12:00:00Z"))
+		})
+
+		It("forgets days, months, and years of windows that end before the cutoff", func() {
+			var payloads []interface{}
+			for d := 0; d < 1000; d += 5 {
+				payloads = append(payloads, map[string]interface{}{"at": day(1).AddDate(0, 0, d).Format(time.RFC3339), "n": d})
+			}
+			sch := learnSeen(payloads...)
+			since := day(1).AddDate(0, 0, 980)
+			out := schemamerge.Prune(schemamerge.PruneInput{Schema: sch, Since: since})
+			n := out.Schema[schema.P_PROPERTIES].(map[string]schema.Schema)["n"]
+			Expect(n.SeenWindows()).To(HaveLen(4))
+			for k := range n.SeenWindows() {
+				Expect(k).To(HaveLen(len(schema.WindowLayout)))
+			}
+			Expect(n).To(And(
+				HaveKeyWithValue(schema.PX_SEEN_MINIMUM, 980),
+				HaveKeyWithValue(schema.PX_SAMPLES, 4),
+			))
+		})
+
+		It("prunes schemas loaded from JSON, and keeps what has no provenance", func() {
+			sch := learnSeen(
+				payload(1, map[string]interface{}{"old": 1}),
+				payload(10, map[string]interface{}{"new": 1}),
+			)
+			b, err := json.Marshal(sch)
+			Expect(err).ToNot(HaveOccurred())
+			loaded, err := schema.Parse(string(b))
+			Expect(err).ToNot(HaveOccurred())
+			loaded[schema.P_PROPERTIES].(map[string]interface{})["unknown"] = map[string]interface{}{"type": "integer"}
+			out := schemamerge.Prune(schemamerge.PruneInput{Schema: loaded, Since: day(5)})
+			Expect(out.Pruned).To(HaveLen(1))
+			Expect(out.Schema[schema.P_PROPERTIES]).To(And(
+				HaveKey("new"),
+				HaveKey("unknown"),
+				Not(HaveKey("old")),
+			))
+		})
+	})
+
+	Describe("Check", func() {
+		violation := func(path string, kind schemamerge.ViolationKind) OmegaMatcher {
+			return And(HaveField("Path", path), HaveField("Kind", kind))
+		}
+
+		It("passes the payloads the schema was learned from", func() {
+			var payloads []interface{}
+			for i := 0; i < 30; i++ {
+				payloads = append(payloads, fixturegen.Generate(fixturegen.GenerateInput{TZ: time.UTC}))
+			}
+			sch := moxtest.LearnSchema(ctx, payloads...)
+			before := sch.DeepClone()
+			out, err := schemamerge.Check(ctx, schemamerge.CheckInput{Schema: sch, PayloadIterator: moxio.NewMemoryIterator(payloads)})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(out.Invalid).To(BeEmpty())
+			Expect(out.Checked).To(Equal(30))
+			Expect(sch).To(Equal(before))
+		})
+
+		It("reports each violation with its path", func() {
+			sch := moxtest.LearnSchema(ctx,
+				map[string]interface{}{"id": 5, "status": "active", "n": 1.5, "tags": []interface{}{"x"}, "user": map[string]interface{}{"name": "alice"}, "opt": nil},
+				map[string]interface{}{"id": 10, "status": "inactive", "n": 2.5, "tags": []interface{}{"y", "z"}, "user": map[string]interface{}{"name": "sally"}, "opt": "x"},
+				map[string]interface{}{"id": 7, "status": "active", "n": 2, "tags": []interface{}{"x"}, "user": map[string]interface{}{"name": "maria"}, "opt": "x"},
+			)
+			sch.MustObject().Properties()["status"][schema.P_ENUM] = []string{"active", "inactive"}
+			Expect(schemamerge.CheckOne(sch, map[string]interface{}{"id": 6, "n": 2, "opt": nil})).To(BeEmpty())
+			Expect(schemamerge.CheckOne(sch, map[string]interface{}{
+				"id":     "6",
+				"status": "pending",
+				"n":      9.5,
+				"tags":   []interface{}{"x", 1},
+				"user":   map[string]interface{}{"name": nil, "b.c": 1},
+				"other":  true,
+			})).To(ConsistOf(
+				violation("$.id", schemamerge.V_TYPE_MISMATCH),
+				violation("$.status", schemamerge.V_ENUM_MISS),
+				violation("$.n", schemamerge.V_OUT_OF_RANGE),
+				violation("$.tags[1]", schemamerge.V_TYPE_MISMATCH),
+				violation(`$.user["b.c"]`, schemamerge.V_UNKNOWN_PROPERTY),
+				violation("$.user.name", schemamerge.V_UNEXPECTED_NULL),
+				violation("$.other", schemamerge.V_UNKNOWN_PROPERTY),
+			))
+			Expect(schemamerge.CheckOne(sch, map[string]interface{}{"id": -(1 << 40)})).To(ConsistOf(
+				violation("$.id", schemamerge.V_FORMAT_MISMATCH),
+				violation("$.id", schemamerge.V_OUT_OF_RANGE),
+			))
+		})
+
+		It("checks values against oneOf schemas", func() {
+			sch := moxtest.LearnSchema(ctx, map[string]interface{}{"x": 1}, map[string]interface{}{"x": "abc"})
+			Expect(schemamerge.CheckOne(sch, map[string]interface{}{"x": "abc"})).To(BeEmpty())
+			Expect(schemamerge.CheckOne(sch, map[string]interface{}{"x": 1})).To(BeEmpty())
+			Expect(schemamerge.CheckOne(sch, map[string]interface{}{"x": true})).To(ConsistOf(
+				violation("$.x", schemamerge.V_TYPE_MISMATCH),
+			))
+			Expect(schemamerge.CheckOne(sch, map[string]interface{}{"x": 2})).To(ConsistOf(
+				violation("$.x", schemamerge.V_OUT_OF_RANGE),
+			))
+		})
+
+		It("checks JSON documents encoded in strings against their schema", func() {
+			sch := moxtest.LearnSchema(ctx, map[string]interface{}{"metadata": `{"order_id": 5}`}, map[string]interface{}{"metadata": `{"order_id": 7}`})
+			Expect(schemamerge.CheckOne(sch, map[string]interface{}{"metadata": `{"order_id": 6}`})).To(BeEmpty())
+			Expect(schemamerge.CheckOne(sch, map[string]interface{}{"metadata": `{"order_id": "6", "x": 1}`})).To(ConsistOf(
+				violation("$.metadata.order_id", schemamerge.V_TYPE_MISMATCH),
+				violation("$.metadata.x", schemamerge.V_UNKNOWN_PROPERTY),
+			))
+			Expect(schemamerge.CheckOne(sch, map[string]interface{}{"metadata": "plain"})).To(ConsistOf(
+				violation("$.metadata", schemamerge.V_FORMAT_MISMATCH),
+			))
+		})
+
+		It("summarizes violations across payloads", func() {
+			sch := moxtest.LearnSchema(ctx, map[string]interface{}{"x": 1})
+			out, err := schemamerge.Check(ctx, schemamerge.CheckInput{Schema: sch, PayloadIterator: moxio.NewMemoryIterator([]interface{}{
+				map[string]interface{}{"x": 1},
+				map[string]interface{}{"x": nil},
+				map[string]interface{}{"x": nil, "y": 1},
+			})})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(out.Checked).To(Equal(3))
+			Expect(out.Invalid).To(HaveLen(2))
+			Expect(out.Invalid[0].Index).To(Equal(1))
+			Expect(out.Summary).To(Equal(map[schemamerge.ViolationKind]int{
+				schemamerge.V_UNEXPECTED_NULL:  2,
+				schemamerge.V_UNKNOWN_PROPERTY: 1,
+			}))
+		})
+	})
 })
+
+func BenchmarkMergeMany(b *testing.B) {
+	ctx := context.Background()
+	payloads := make([]interface{}, 500)
+	for i := range payloads {
+		payloads[i] = fixturegen.Generate(fixturegen.GenerateInput{TZ: time.UTC})
+	}
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := schemamerge.MergeMany(ctx, schemamerge.MergeManyInput{
+					PayloadIterator: moxio.NewMemoryIterator(payloads),
+					Workers:         workers,
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
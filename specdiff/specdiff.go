@@ -0,0 +1,316 @@
+// Package specdiff compares two AsyncAPI specs generated by Mox Populi,
+// like from nightly specgen runs, and classifies how each change affects consumers.
+// Schemas in the specs are compared with schemadiff.
+package specdiff
+
+import (
+	"fmt"
+	"github.com/lithictech/moxpopuli/asyncapispec"
+	"github.com/lithictech/moxpopuli/cloudevents"
+	moxinternal "github.com/lithictech/moxpopuli/internal"
+	"github.com/lithictech/moxpopuli/schema"
+	"github.com/lithictech/moxpopuli/schemadiff"
+	"github.com/pkg/errors"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+const (
+	K_SERVER_ADDED      schemadiff.Kind = "server_added"
+	K_SERVER_REMOVED    schemadiff.Kind = "server_removed"
+	K_SERVER_CHANGED    schemadiff.Kind = "server_changed"
+	K_CHANNEL_ADDED     schemadiff.Kind = "channel_added"
+	K_CHANNEL_REMOVED   schemadiff.Kind = "channel_removed"
+	K_OPERATION_ADDED   schemadiff.Kind = "operation_added"
+	K_OPERATION_REMOVED schemadiff.Kind = "operation_removed"
+	K_MESSAGE_ADDED     schemadiff.Kind = "message_added"
+	K_MESSAGE_REMOVED   schemadiff.Kind = "message_removed"
+	K_MESSAGE_CHANGED   schemadiff.Kind = "message_changed"
+	K_PARAMETER_ADDED   schemadiff.Kind = "parameter_added"
+	K_PARAMETER_REMOVED schemadiff.Kind = "parameter_removed"
+	K_BINDING_ADDED     schemadiff.Kind = "binding_added"
+	K_BINDING_REMOVED   schemadiff.Kind = "binding_removed"
+	K_BINDING_CHANGED   schemadiff.Kind = "binding_changed"
+)
+
+type Change struct {
+	// Where the change is in the spec, like 'channels["/orders"].subscribe.message["OrderCreated"].payload'.
+	Location string `json:"location"`
+	// For changes to a schema, Path is the JSON path of the changed value.
+	schemadiff.Change
+	// For changes to a message, an example in the new message that is not in the old one, if any.
+	// Since examples are only recorded when they change the message (see README -> Message Examples),
+	// this is usually the event that caused the change. It is not serialized, to keep diffs small.
+	Example interface{} `json:"-"`
+}
+
+func (c Change) String() string {
+	if c.Path == "" {
+		return fmt.Sprintf("%s %s: %s", c.Severity, c.Location, c.Message)
+	}
+	return fmt.Sprintf("%s %s %s: %s", c.Severity, c.Location, c.Path, c.Message)
+}
+
+type Changes = schemadiff.ChangeList[Change]
+
+// Diff returns the changes from old to new, which must be in the AsyncAPI 2.x structure Mox Populi works with
+// (see asyncapispec.Downgrade). The specs are not modified.
+//
+// Servers, channels, operations, parameters, and bindings are matched by key, and messages by name.
+// Added servers, channels, operations, and messages are non-breaking, and removed ones are breaking.
+// Changes to binding fields (like the HTTP method) and message content types are breaking.
+// Payload, header, query, parameter, and CloudEvents envelope schemas are compared with schemadiff.Diff.
+func Diff(old, new asyncapispec.Specification) (Changes, error) {
+	old, err := moxinternal.ToPlainMap(old)
+	if err != nil {
+		return nil, errors.Wrap(err, "converting old spec to plain map")
+	}
+	new, err = moxinternal.ToPlainMap(new)
+	if err != nil {
+		return nil, errors.Wrap(err, "converting new spec to plain map")
+	}
+	d := &differ{}
+	d.diffServers(mapField(old, "servers"), mapField(new, "servers"))
+	d.diffChannels(mapField(old, "channels"), mapField(new, "channels"))
+	components := "components.messageTraits"
+	oldTraits := mapField(mapField(old, "components"), "messageTraits")
+	newTraits := mapField(mapField(new, "components"), "messageTraits")
+	d.eachKey(oldTraits, newTraits, func(name string, o, n map[string]interface{}) {
+		// Binary CloudEvents keep their attributes in headers, and structured ones in the envelope.
+		d.diffSchemaField(components+keyed(name), "headers", o, n)
+		d.diffSchemaField(components+keyed(name), cloudevents.TraitEnvelopeField, o, n)
+	})
+	return d.changes, nil
+}
+
+type differ struct {
+	changes Changes
+}
+
+func (d *differ) add(loc string, kind schemadiff.Kind, sev schemadiff.Severity, old, new interface{}, msg string, args ...interface{}) {
+	d.changes = append(d.changes, Change{
+		Location: loc,
+		Change: schemadiff.Change{
+			Kind:     kind,
+			Severity: sev,
+			Old:      old,
+			New:      new,
+			Message:  fmt.Sprintf(msg, args...),
+		},
+	})
+}
+
+// eachKey calls diff with the values of each key in both old and new, in sorted order.
+// Keys in only one of them are skipped, so callers report them first with addedRemoved.
+func (d *differ) eachKey(old, new map[string]interface{}, diff func(key string, o, n map[string]interface{})) {
+	for _, k := range sortedKeys(old, new) {
+		o, oldOk := old[k].(map[string]interface{})
+		n, newOk := new[k].(map[string]interface{})
+		if oldOk && newOk {
+			diff(k, o, n)
+		}
+	}
+}
+
+// addedRemoved reports the keys only in new as added (non-breaking),
+// and the keys only in old as removed (breaking).
+func (d *differ) addedRemoved(loc string, old, new map[string]interface{}, added, removed schemadiff.Kind, what string) {
+	for _, k := range sortedKeys(old, new) {
+		_, oldOk := old[k]
+		_, newOk := new[k]
+		if !oldOk {
+			d.add(loc+keyed(k), added, schemadiff.S_NON_BREAKING, nil, nil, "%s added", what)
+		} else if !newOk {
+			d.add(loc+keyed(k), removed, schemadiff.S_BREAKING, nil, nil, "%s removed", what)
+		}
+	}
+}
+
+func (d *differ) diffServers(old, new map[string]interface{}) {
+	d.addedRemoved("servers", old, new, K_SERVER_ADDED, K_SERVER_REMOVED, "server")
+	d.eachKey(old, new, func(name string, o, n map[string]interface{}) {
+		loc := "servers" + keyed(name)
+		for _, field := range []string{"url", "protocol", "protocolVersion"} {
+			if ov, nv := o[field], n[field]; !reflect.DeepEqual(ov, nv) {
+				d.add(loc, K_SERVER_CHANGED, schemadiff.S_BREAKING, ov, nv, "%s changed from %v to %v", field, orNone(ov), orNone(nv))
+			}
+		}
+		d.diffBindings(loc+".bindings", mapField(o, "bindings"), mapField(n, "bindings"))
+	})
+}
+
+func (d *differ) diffChannels(old, new map[string]interface{}) {
+	d.addedRemoved("channels", old, new, K_CHANNEL_ADDED, K_CHANNEL_REMOVED, "channel")
+	d.eachKey(old, new, func(name string, o, n map[string]interface{}) {
+		loc := "channels" + keyed(name)
+		oldParams, newParams := mapField(o, "parameters"), mapField(n, "parameters")
+		d.addedRemoved(loc+".parameters", oldParams, newParams, K_PARAMETER_ADDED, K_PARAMETER_REMOVED, "parameter")
+		d.eachKey(oldParams, newParams, func(param string, op, np map[string]interface{}) {
+			d.diffSchemaField(loc+".parameters"+keyed(param), "schema", op, np)
+		})
+		d.diffBindings(loc+".bindings", mapField(o, "bindings"), mapField(n, "bindings"))
+		for _, opKey := range []string{"subscribe", "publish"} {
+			oldOp, oldOk := o[opKey].(map[string]interface{})
+			newOp, newOk := n[opKey].(map[string]interface{})
+			opLoc := loc + "." + opKey
+			if !oldOk && newOk {
+				d.add(opLoc, K_OPERATION_ADDED, schemadiff.S_NON_BREAKING, nil, nil, "operation added")
+			} else if oldOk && !newOk {
+				d.add(opLoc, K_OPERATION_REMOVED, schemadiff.S_BREAKING, nil, nil, "operation removed")
+			} else if oldOk && newOk {
+				d.diffOperation(opLoc, oldOp, newOp)
+			}
+		}
+	})
+}
+
+func (d *differ) diffOperation(loc string, old, new map[string]interface{}) {
+	d.diffBindings(loc+".bindings", mapField(old, "bindings"), mapField(new, "bindings"))
+	oldMessages, newMessages := messagesByName(old), messagesByName(new)
+	start := len(d.changes)
+	d.addedRemoved(loc+".message", oldMessages, newMessages, K_MESSAGE_ADDED, K_MESSAGE_REMOVED, "message")
+	for i := start; i < len(d.changes); i++ {
+		for name, n := range newMessages {
+			if d.changes[i].Kind == K_MESSAGE_ADDED && d.changes[i].Location == loc+".message"+keyed(name) {
+				d.changes[i].Example = newExample(nil, n.(map[string]interface{}))
+			}
+		}
+	}
+	d.eachKey(oldMessages, newMessages, func(name string, o, n map[string]interface{}) {
+		start := len(d.changes)
+		defer func() {
+			for i := start; i < len(d.changes); i++ {
+				d.changes[i].Example = newExample(o, n)
+			}
+		}()
+		msgLoc := loc + ".message"
+		if name != "" {
+			msgLoc += keyed(name)
+		}
+		if ov, nv := o["contentType"], n["contentType"]; !reflect.DeepEqual(ov, nv) {
+			d.add(msgLoc, K_MESSAGE_CHANGED, schemadiff.S_BREAKING, ov, nv, "contentType changed from %v to %v", orNone(ov), orNone(nv))
+		}
+		d.diffSchemaField(msgLoc, "headers", o, n)
+		d.diffSchemaField(msgLoc, "payload", o, n)
+		d.diffBindings(msgLoc+".bindings", mapField(o, "bindings"), mapField(n, "bindings"))
+	})
+}
+
+// newExample returns the first example in the new message that is not in the old one, or nil.
+func newExample(old, new map[string]interface{}) interface{} {
+	oldExamples, _ := old["examples"].([]interface{})
+	newExamples, _ := new["examples"].([]interface{})
+	for _, n := range newExamples {
+		found := false
+		for _, o := range oldExamples {
+			found = found || reflect.DeepEqual(o, n)
+		}
+		if !found {
+			return n
+		}
+	}
+	return nil
+}
+
+// messagesByName returns the operation's messages by name.
+// A single message without a name has an empty name.
+func messagesByName(op map[string]interface{}) map[string]interface{} {
+	r := make(map[string]interface{})
+	if _, ok := op["message"].(map[string]interface{}); !ok {
+		return r
+	}
+	for _, msg := range asyncapispec.Operation(op).Messages() {
+		r[msg.Name()] = map[string]interface{}(msg)
+	}
+	return r
+}
+
+// diffBindings compares each protocol's binding.
+// Binding fields that hold schemas (like HTTP 'query' and 'headers') are compared with schemadiff,
+// and other fields (like 'method') are breaking if they change, except for 'bindingVersion'.
+func (d *differ) diffBindings(loc string, old, new map[string]interface{}) {
+	d.addedRemoved(loc, old, new, K_BINDING_ADDED, K_BINDING_REMOVED, "binding")
+	d.eachKey(old, new, func(protocol string, o, n map[string]interface{}) {
+		bindingLoc := loc + "." + protocol
+		for _, field := range sortedKeys(o, n) {
+			ov, nv := o[field], n[field]
+			_, oldSchema := ov.(map[string]interface{})
+			_, newSchema := nv.(map[string]interface{})
+			if (oldSchema && (newSchema || nv == nil)) || (newSchema && ov == nil) {
+				// A schema on only one side is compared with an empty one.
+				d.diffSchemaField(bindingLoc, field, o, n)
+				continue
+			}
+			if reflect.DeepEqual(ov, nv) {
+				continue
+			}
+			sev := schemadiff.S_BREAKING
+			if field == "bindingVersion" {
+				sev = schemadiff.S_INFO
+			}
+			d.add(bindingLoc, K_BINDING_CHANGED, sev, ov, nv, "%s changed from %v to %v", field, orNone(ov), orNone(nv))
+		}
+	})
+}
+
+// diffSchemaField compares the schemas in the field of old and new with schemadiff.Diff.
+// A missing schema is compared as an empty one of the same type as the other,
+// so only its properties are reported as added or removed.
+func (d *differ) diffSchemaField(loc, field string, old, new map[string]interface{}) {
+	loc = loc + "." + field
+	o, n := schemaField(old, field), schemaField(new, field)
+	if len(o) == 0 && len(n) > 0 {
+		o = emptyLike(n)
+	} else if len(n) == 0 && len(o) > 0 {
+		n = emptyLike(o)
+	}
+	for _, ch := range schemadiff.Diff(o, n) {
+		d.changes = append(d.changes, Change{Location: loc, Change: ch})
+	}
+}
+
+func schemaField(o map[string]interface{}, field string) schema.Schema {
+	if v, ok := o[field]; ok && v != nil {
+		return schema.Coerce(v)
+	}
+	return schema.Schema{}
+}
+
+func emptyLike(sch schema.Schema) schema.Schema {
+	if t, ok := sch[schema.P_TYPE]; ok {
+		return schema.Schema{schema.P_TYPE: t}
+	}
+	return schema.Schema{}
+}
+
+func mapField(o map[string]interface{}, field string) map[string]interface{} {
+	m, _ := o[field].(map[string]interface{})
+	return m
+}
+
+func sortedKeys(maps ...map[string]interface{}) []string {
+	seen := make(map[string]struct{})
+	for _, m := range maps {
+		for k := range m {
+			seen[k] = struct{}{}
+		}
+	}
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func keyed(key string) string {
+	return "[" + strconv.Quote(key) + "]"
+}
+
+func orNone(v interface{}) interface{} {
+	if v == nil {
+		return "none"
+	}
+	return v
+}
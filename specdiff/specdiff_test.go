@@ -0,0 +1,150 @@
+package specdiff_test
+
+import (
+	"context"
+	"github.com/lithictech/moxpopuli/asyncapispec"
+	"github.com/lithictech/moxpopuli/cloudevents"
+	"github.com/lithictech/moxpopuli/internal/moxtest"
+	"github.com/lithictech/moxpopuli/schemadiff"
+	"github.com/lithictech/moxpopuli/specdiff"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestSpecdiff(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "specdiff Suite")
+}
+
+var _ = Describe("specdiff", func() {
+	ctx := context.Background()
+	event := func(method, path string, headers, body map[string]interface{}) map[string]interface{} {
+		h := map[string]interface{}{"Host": "localhost:18001"}
+		for k, v := range headers {
+			h[k] = v
+		}
+		return map[string]interface{}{"path": path, "method": method, "headers": h, "body": body}
+	}
+	change := func(loc, path string, kind schemadiff.Kind, sev schemadiff.Severity) OmegaMatcher {
+		return And(
+			HaveField("Location", loc),
+			HaveField("Path", path),
+			HaveField("Kind", kind),
+			HaveField("Severity", sev),
+		)
+	}
+
+	It("has no changes for the same spec", func() {
+		spec := moxtest.LearnHttpSpec(ctx, nil, event("POST", "/orders", nil, map[string]interface{}{"id": 1}))
+		changes, err := specdiff.Diff(spec, spec)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes).To(BeEmpty())
+	})
+
+	It("reports channel, binding, header, and payload changes", func() {
+		old := moxtest.LearnHttpSpec(ctx, nil,
+			event("POST", "/orders", nil, map[string]interface{}{"id": 1, "total": "5.00"}),
+			event("POST", "/refunds", nil, map[string]interface{}{"id": 1}),
+		)
+		new := moxtest.LearnHttpSpec(ctx, nil,
+			event("PUT", "/orders", map[string]interface{}{"X-Tenant": "acme"}, map[string]interface{}{"id": "ord_1", "total": "5.00"}),
+			event("POST", "/customers", nil, map[string]interface{}{"id": 1}),
+		)
+		changes, err := specdiff.Diff(old, new)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes).To(ConsistOf(
+			change(`channels["/customers"]`, "", specdiff.K_CHANNEL_ADDED, schemadiff.S_NON_BREAKING),
+			change(`channels["/refunds"]`, "", specdiff.K_CHANNEL_REMOVED, schemadiff.S_BREAKING),
+			And(
+				change(`channels["/orders"].subscribe.bindings.http`, "", specdiff.K_BINDING_CHANGED, schemadiff.S_BREAKING),
+				HaveField("Message", "method changed from POST to PUT"),
+			),
+			change(`channels["/orders"].subscribe.message.headers`, `$["X-Tenant"]`, schemadiff.K_PROPERTY_ADDED, schemadiff.S_NON_BREAKING),
+			change(`channels["/orders"].subscribe.message.payload`, "$.id", schemadiff.K_TYPE_CHANGED, schemadiff.S_BREAKING),
+		))
+		Expect(changes.Breaking()).To(BeTrue())
+	})
+
+	It("reports added and removed messages by name", func() {
+		order := func(t string) map[string]interface{} {
+			return event("POST", "/events", map[string]interface{}{"X-GitHub-Event": t}, map[string]interface{}{"id": 1})
+		}
+		old := moxtest.LearnHttpSpec(ctx, nil, order("push"), order("issues"))
+		new := moxtest.LearnHttpSpec(ctx, nil, order("push"), order("star"))
+		changes, err := specdiff.Diff(old, new)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes).To(ConsistOf(
+			change(`channels["/events"].subscribe.message["issues"]`, "", specdiff.K_MESSAGE_REMOVED, schemadiff.S_BREAKING),
+			change(`channels["/events"].subscribe.message["star"]`, "", specdiff.K_MESSAGE_ADDED, schemadiff.S_NON_BREAKING),
+		))
+	})
+
+	It("compares binding schemas that are only on one side with an empty schema", func() {
+		old := moxtest.LearnHttpSpec(ctx, nil, event("POST", "/orders", nil, map[string]interface{}{"id": 1}))
+		new := moxtest.LearnHttpSpec(ctx, nil, event("POST", "/orders?page=1", nil, map[string]interface{}{"id": 1}))
+		changes, err := specdiff.Diff(old, new)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes).To(ConsistOf(
+			change(`channels["/orders"].subscribe.bindings.http.query`, "$.page", schemadiff.K_PROPERTY_ADDED, schemadiff.S_NON_BREAKING),
+		))
+		changes, err = specdiff.Diff(new, old)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes).To(ConsistOf(
+			change(`channels["/orders"].subscribe.bindings.http.query`, "$.page", schemadiff.K_PROPERTY_REMOVED, schemadiff.S_BREAKING),
+		))
+	})
+
+	It("reports changes to CloudEvents envelope attributes", func() {
+		cloudEvent := func(attrs map[string]interface{}) map[string]interface{} {
+			body := map[string]interface{}{
+				"specversion": "1.0", "type": "order.created", "source": "/orders", "id": "A234-1234",
+				"data": map[string]interface{}{"order_id": 5},
+			}
+			for k, v := range attrs {
+				body[k] = v
+			}
+			return event("POST", "/events", map[string]interface{}{"Content-Type": "application/cloudevents+json"}, body)
+		}
+		old := moxtest.LearnHttpSpec(ctx, nil, cloudEvent(nil))
+		new := moxtest.LearnHttpSpec(ctx, nil, cloudEvent(map[string]interface{}{"subject": "5"}))
+		changes, err := specdiff.Diff(old, new)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes).To(ConsistOf(
+			And(
+				HaveField("Location", And(HavePrefix("components.messageTraits"), HaveSuffix("."+cloudevents.TraitEnvelopeField))),
+				HaveField("Path", "$.subject"),
+				HaveField("Kind", schemadiff.K_PROPERTY_ADDED),
+			),
+		))
+	})
+
+	It("reports server changes", func() {
+		old := asyncapispec.Specification{"servers": map[string]interface{}{
+			"prod":    map[string]interface{}{"url": "api.example.com", "protocol": "http"},
+			"staging": map[string]interface{}{"url": "staging.example.com", "protocol": "http"},
+		}}
+		new := asyncapispec.Specification{"servers": map[string]interface{}{
+			"prod": map[string]interface{}{"url": "api.example.com", "protocol": "https"},
+		}}
+		changes, err := specdiff.Diff(old, new)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes).To(ConsistOf(
+			change(`servers["prod"]`, "", specdiff.K_SERVER_CHANGED, schemadiff.S_BREAKING),
+			change(`servers["staging"]`, "", specdiff.K_SERVER_REMOVED, schemadiff.S_BREAKING),
+		))
+	})
+
+	It("includes the new message example that caused a change", func() {
+		limit := 5
+		first := event("POST", "/orders", nil, map[string]interface{}{"id": 1})
+		old := moxtest.LearnHttpSpec(ctx, &limit, first)
+		new := moxtest.LearnHttpSpec(ctx, &limit, first, event("POST", "/orders", nil, map[string]interface{}{"id": "ord_1"}))
+		changes, err := specdiff.Diff(old, new)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes).To(ConsistOf(And(
+			change(`channels["/orders"].subscribe.message.payload`, "$.id", schemadiff.K_ONE_OF_ADDED, schemadiff.S_BREAKING),
+			HaveField("Example", HaveKeyWithValue("payload", HaveKeyWithValue("id", "ord_1"))),
+		)))
+	})
+})
@@ -1,18 +1,24 @@
 package v1
 
 import (
+	"context"
 	"github.com/labstack/echo"
 	"github.com/lithictech/go-aperitif/api"
 	"github.com/lithictech/go-aperitif/api/apiparams"
+	"github.com/lithictech/moxpopuli/anomaly"
+	"github.com/lithictech/moxpopuli/asyncapispec"
 	"github.com/lithictech/moxpopuli/asyncapispecmerge"
 	"github.com/lithictech/moxpopuli/datagen"
 	"github.com/lithictech/moxpopuli/moxio"
+	"github.com/lithictech/moxpopuli/moxrand"
+	"github.com/lithictech/moxpopuli/openapispec"
 	"github.com/lithictech/moxpopuli/schema"
 	"github.com/lithictech/moxpopuli/schemamerge"
 	"github.com/pkg/errors"
 	"github.com/rgalanakis/sashay"
 	"net/http"
 	"strings"
+	"time"
 )
 
 func MountSwaggerui(e *echo.Echo) {
@@ -41,17 +47,23 @@ func NewSashay() *sashay.Sashay {
 	sa.DefineDataType(map[string]string{}, sashay.SimpleDataTyper("object", ""))
 	sa.Add(quickstartSchemagenOp)
 	sa.Add(schemagenOp)
+	sa.Add(schemamergeOp)
 	sa.Add(specgenOp)
 	sa.Add(datagenOp)
+	sa.Add(anomaliesOp)
+	sa.Add(schemapruneOp)
 	return sa
 }
 
 func Register(e *echo.Echo) {
 	h := handlers{}
 	e.Add(schemagenOp.Method, schemagenOp.Path, h.schemagen)
+	e.Add(schemamergeOp.Method, schemamergeOp.Path, h.schemamerge)
 	e.Add(quickstartSchemagenOp.Method, quickstartSchemagenOp.Path, h.quickstartSchemagen)
 	e.Add(specgenOp.Method, specgenOp.Path, h.specgen)
 	e.Add(datagenOp.Method, datagenOp.Path, h.datagen)
+	e.Add(anomaliesOp.Method, anomaliesOp.Path, h.anomalies)
+	e.Add(schemapruneOp.Method, schemapruneOp.Path, h.schemaprune)
 }
 
 type handlers struct{}
@@ -94,6 +106,10 @@ type SchemagenParams struct {
 	Schema        schema.Schema `json:"schema" description:"The existing schema, if any. You can save the 'schema' from the response and then submit it in later requests."`
 	Payloads      []interface{} `json:"payloads" description:"Array of JSON events. Mox Populi iteratively merges these into the schema."`
 	ExamplesLimit *int          `json:"examples_limit" validate:"min=0,max=10" description:"How many examples to include in the resulting schema. See README for details about example sampling."`
+	Seed          *int64        `json:"seed" description:"Seed for sampling examples, so the same request returns the same examples. If not given, examples are sampled differently each request."`
+	Provenance    bool          `json:"provenance" description:"If true, record when each schema node was first and last seen, so it can be pruned with /schemaprune."`
+	TimeField     string        `json:"provenance_time_field" description:"Path of each payload's event time, like 'created_at'. If not given, use the time of the request."`
+	SourceField   string        `json:"provenance_source_field" description:"Path of each payload's identifier, like 'id'. If not given, use the payload's position in the request."`
 }
 type SchemagenResponse struct {
 	Schema schema.Schema `json:"schema" description:"The JSONSchema derived from the input schema (if any) and each payload."`
@@ -105,11 +121,14 @@ func (h handlers) schemagen(c echo.Context) error {
 	if err := apiparams.BindAndValidate(apiParamsAdapter{}, &params, c); err != nil {
 		return err
 	}
+	ctx = withSeed(ctx, params.Seed)
 	payloadIterator := moxio.NewMemoryIterator(params.Payloads)
+	provenance := provenanceInput(params.Provenance, params.TimeField, params.SourceField, "payloads")
 	mergeResult, err := schemamerge.MergeMany(ctx, schemamerge.MergeManyInput{
 		Schema:          params.Schema,
 		PayloadIterator: payloadIterator,
 		ExampleLimit:    params.ExamplesLimit,
+		Provenance:      provenance,
 	})
 	if err != nil {
 		return err
@@ -118,6 +137,52 @@ func (h handlers) schemagen(c echo.Context) error {
 	return c.JSONPretty(200, resp, "  ")
 }
 
+func withSeed(ctx context.Context, seed *int64) context.Context {
+	if seed == nil {
+		return ctx
+	}
+	return moxrand.WithSeed(ctx, *seed)
+}
+
+var schemamergeOp = sashay.NewOperation(
+	"POST",
+	"/v1/schemamerge",
+	"Merge JSONSchemas learned from different payloads (like from /schemagen on different shards) into one schema.",
+	SchemamergeParams{},
+	SchemagenResponse{},
+	api.Error{},
+)
+
+type SchemamergeParams struct {
+	Schemas       []schema.Schema `json:"schemas" description:"Schemas returned from /schemagen. They are merged as if all of their payloads were in one request."`
+	ExamplesLimit *int            `json:"examples_limit" validate:"min=0,max=10" description:"How many examples to keep. If not given, keep the examples of all schemas."`
+	Seed          *int64          `json:"seed" description:"See /schemagen for an explanation of this parameter."`
+}
+
+func (h handlers) schemamerge(c echo.Context) error {
+	ctx := api.StdContext(c)
+	var params SchemamergeParams
+	if err := apiparams.BindAndValidate(apiParamsAdapter{}, &params, c); err != nil {
+		return err
+	}
+	ctx = withSeed(ctx, params.Seed)
+	sch := schemamerge.MergeSchemas(ctx, schemamerge.MergeSchemasInput{
+		Schemas:      params.Schemas,
+		ExampleLimit: params.ExamplesLimit,
+	})
+	resp := SchemagenResponse{Schema: sch}
+	return c.JSONPretty(200, resp, "  ")
+}
+
+// provenanceInput returns the provenance to record, or nil if none was requested.
+// Sources are the position of each item in the request's source array, like 'payloads:3'.
+func provenanceInput(enabled bool, timeField, sourceField, source string) *schemamerge.ProvenanceInput {
+	if !enabled && timeField == "" && sourceField == "" {
+		return nil
+	}
+	return &schemamerge.ProvenanceInput{TimeField: timeField, SourceField: sourceField, Source: source}
+}
+
 var specgenOp = sashay.NewOperation(
 	"POST",
 	"/v1/specgen",
@@ -128,10 +193,20 @@ var specgenOp = sashay.NewOperation(
 )
 
 type SpecgenParams struct {
-	ExamplesLimit *int                               `json:"examples_limit" validate:"min=0|max=10" description:"See /schemagen for an explanation of this parameter."`
-	Protocol      string                             `json:"protocol" enum:"http" description:"The protocol/binding to use to use when generating the spec. The value here determines which event array is used."`
-	Specification map[string]interface{}             `json:"specification" description:"The existing AsyncAPI spec, if any. Generally you at least must supply the 'info' section. Everything else can usually be determined through the events."`
-	HttpEvents    []asyncapispecmerge.MergeHttpEvent `json:"http_events" description:"Events to use for the 'http' protocol."`
+	ExamplesLimit     *int                               `json:"examples_limit" validate:"min=0|max=10" description:"See /schemagen for an explanation of this parameter."`
+	Seed              *int64                             `json:"seed" description:"See /schemagen for an explanation of this parameter."`
+	Protocol          string                             `json:"protocol" enum:"http,mqtt,ws" description:"The protocol/binding to use to use when generating the spec. The value here determines which event array is used."`
+	AsyncapiVersion   string                             `json:"asyncapi_version" description:"AsyncAPI version of the returned spec, like '2.6.0' or '3.0.0'. If not given, use the version of the given specification (or 2.x if there is none)."`
+	Format            string                             `json:"format" enum:"asyncapi,openapi" description:"Format of the returned spec. 'openapi' returns an OpenAPI 3.1 document describing each HTTP channel as a webhook. Keep the AsyncAPI spec to learn more events, since OpenAPI documents cannot be used as the 'specification'."`
+	OpenapiExtensions bool                               `json:"openapi_extensions" description:"If true, keep the 'x-' fields Mox Populi uses internally in OpenAPI documents."`
+	Discriminator     string                             `json:"discriminator" description:"Header name (like 'X-GitHub-Event') or payload field path (like '$.type') identifying the event type, to split messages on a channel by type. Well-known webhook event headers are detected if not given."`
+	Provenance        bool                               `json:"provenance" description:"See /schemagen for an explanation of this parameter. Applies to message payload and header schemas."`
+	TimeField         string                             `json:"provenance_time_field" description:"Path of each message payload's event time (in the body, or the data of a CloudEvent). See /schemagen."`
+	SourceField       string                             `json:"provenance_source_field" description:"Path of each message payload's identifier. If not given, use the event's position in the request."`
+	Specification     map[string]interface{}             `json:"specification" description:"The existing AsyncAPI spec, if any. Generally you at least must supply the 'info' section. Everything else can usually be determined through the events."`
+	HttpEvents        []asyncapispecmerge.MergeHttpEvent `json:"http_events" description:"Events to use for the 'http' protocol."`
+	MqttEvents        []asyncapispecmerge.MergeMqttEvent `json:"mqtt_events" description:"Events to use for the 'mqtt' protocol."`
+	WsEvents          []asyncapispecmerge.MergeWsEvent   `json:"ws_events" description:"Events to use for the 'ws' protocol."`
 }
 
 type SpecgenResponse struct {
@@ -144,28 +219,71 @@ func (h handlers) specgen(c echo.Context) error {
 	if err := apiparams.BindAndValidate(apiParamsAdapter{}, &params, c); err != nil {
 		return err
 	}
+	ctx = withSeed(ctx, params.Seed)
+	discriminator, err := asyncapispecmerge.ParseDiscriminator(params.Discriminator)
+	if err != nil {
+		return api.NewError(400, "invalid_discriminator", err)
+	}
 	var events []interface{}
 	var merge asyncapispecmerge.Merge
-	if params.Protocol == "http" {
+	switch params.Protocol {
+	case "http":
 		merge = asyncapispecmerge.MergeHttp
 		events = make([]interface{}, len(params.HttpEvents))
 		for i, e := range params.HttpEvents {
 			events[i] = e
 		}
-	} else {
+	case "mqtt":
+		merge = asyncapispecmerge.MergeMqtt
+		events = make([]interface{}, len(params.MqttEvents))
+		for i, e := range params.MqttEvents {
+			events[i] = e
+		}
+	case "ws":
+		merge = asyncapispecmerge.MergeWs
+		events = make([]interface{}, len(params.WsEvents))
+		for i, e := range params.WsEvents {
+			events[i] = e
+		}
+	default:
 		return errors.New("unsupported binding, should have been validated")
 	}
-	spec := params.Specification
+	outputMajor, err := asyncapispec.ParseMajorVersion(params.AsyncapiVersion)
+	if err != nil {
+		return api.NewError(400, "invalid_asyncapi_version", err)
+	}
+	spec := asyncapispec.Specification(params.Specification)
 	if spec == nil {
-		spec = make(map[string]interface{}, 2)
+		spec = make(asyncapispec.Specification, 2)
+	}
+	loadedMajor := spec.MajorVersion()
+	if loadedMajor == 3 {
+		if spec, err = asyncapispec.Downgrade(spec); err != nil {
+			return api.NewError(400, "invalid_specification", err)
+		}
+	}
+	if outputMajor == 0 {
+		outputMajor = loadedMajor
 	}
 	if err := merge(ctx, asyncapispecmerge.MergeInput{
 		Spec:          spec,
 		EventIterator: moxio.NewMemoryIterator(events),
 		ExampleLimit:  params.ExamplesLimit,
+		Discriminator: discriminator,
+		Provenance:    provenanceInput(params.Provenance, params.TimeField, params.SourceField, "events"),
 	}); err != nil {
 		return errors.Wrap(err, "merging")
 	}
+	if params.Format == "openapi" {
+		doc, err := openapispec.FromAsyncApi(openapispec.FromAsyncApiInput{Spec: spec, KeepExtensions: params.OpenapiExtensions})
+		if err != nil {
+			return err
+		}
+		return c.JSONPretty(200, SpecgenResponse{Specification: doc}, "  ")
+	}
+	if outputMajor == 3 {
+		spec = asyncapispec.Upgrade(spec)
+	}
 	resp := SpecgenResponse{Specification: spec}
 	return c.JSONPretty(200, resp, "  ")
 }
@@ -182,6 +300,7 @@ var datagenOp = sashay.NewOperation(
 type DatagenParams struct {
 	Schema schema.Schema `json:"schema"`
 	Count  int           `json:"count" default:"5"`
+	Nulls  string        `json:"nulls" description:"How often to generate nullable values as null: 'observed' (default), 'max', or 'none'."`
 }
 
 type DatagenResponse struct {
@@ -194,14 +313,83 @@ func (h handlers) datagen(c echo.Context) error {
 	if err := apiparams.BindAndValidate(apiParamsAdapter{}, &params, c); err != nil {
 		return err
 	}
+	nulls, err := datagen.ParseNullMode(params.Nulls)
+	if err != nil {
+		return api.NewError(400, "invalid_nulls", err)
+	}
 	items := make([]interface{}, params.Count)
 	for i := 0; i < params.Count; i++ {
-		items[i] = datagen.Generate(ctx, datagen.GenerateInput{Schema: params.Schema})
+		items[i] = datagen.Generate(ctx, datagen.GenerateInput{Schema: params.Schema, Nulls: nulls})
 	}
 	resp := DatagenResponse{Items: items}
 	return c.JSONPretty(200, resp, "  ")
 }
 
+var anomaliesOp = sashay.NewOperation(
+	"POST",
+	"/v1/anomalies",
+	"Score how unusual payloads are compared to a JSONSchema learned from earlier payloads, and why.",
+	AnomaliesParams{},
+	anomaly.ScoreOutput{},
+	api.Error{},
+)
+
+type AnomaliesParams struct {
+	Schema    schema.Schema `json:"schema" description:"The schema returned from /schemagen. It is not modified."`
+	Payloads  []interface{} `json:"payloads" description:"Array of JSON events to score."`
+	Threshold float64       `json:"threshold" description:"Only return payloads scoring above this. See README for how scores work."`
+}
+
+func (h handlers) anomalies(c echo.Context) error {
+	ctx := api.StdContext(c)
+	var params AnomaliesParams
+	if err := apiparams.BindAndValidate(apiParamsAdapter{}, &params, c); err != nil {
+		return err
+	}
+	out, err := anomaly.Score(ctx, anomaly.ScoreInput{
+		Schema:          params.Schema,
+		PayloadIterator: moxio.NewMemoryIterator(params.Payloads),
+		Threshold:       params.Threshold,
+	})
+	if err != nil {
+		return err
+	}
+	if out.Payloads == nil {
+		out.Payloads = []anomaly.PayloadScore{}
+	}
+	return c.JSONPretty(200, out, "  ")
+}
+
+var schemapruneOp = sashay.NewOperation(
+	"POST",
+	"/v1/schemaprune",
+	"Forget the parts of a JSONSchema that have not been seen recently, like properties a provider stopped sending.",
+	SchemapruneParams{},
+	schemamerge.PruneOutput{},
+	api.Error{},
+)
+
+type SchemapruneParams struct {
+	Schema schema.Schema `json:"schema" description:"The schema returned from /schemagen with provenance. It is not modified."`
+	Window string        `json:"window" description:"Forget what was not seen within this long, like '720h'."`
+}
+
+func (h handlers) schemaprune(c echo.Context) error {
+	var params SchemapruneParams
+	if err := apiparams.BindAndValidate(apiParamsAdapter{}, &params, c); err != nil {
+		return err
+	}
+	window, err := time.ParseDuration(params.Window)
+	if err != nil {
+		return api.NewError(400, "invalid_window", err)
+	}
+	out := schemamerge.Prune(schemamerge.PruneInput{Schema: params.Schema, Since: time.Now().Add(-window)})
+	if out.Pruned == nil {
+		out.Pruned = []schemamerge.Pruned{}
+	}
+	return c.JSONPretty(200, out, "  ")
+}
+
 type apiParamsAdapter struct{}
 
 func (apiParamsAdapter) Request(handlerArgs []interface{}) *http.Request {
@@ -1,6 +1,7 @@
 package v1_test
 
 import (
+	"encoding/json"
 	"github.com/labstack/echo"
 	"github.com/lithictech/go-aperitif/api"
 	. "github.com/lithictech/go-aperitif/api/echoapitest"
@@ -11,6 +12,7 @@ import (
 	. "github.com/rgalanakis/golangal"
 	"github.com/rgalanakis/sashay"
 	"testing"
+	"time"
 )
 
 func TestV1(t *testing.T) {
@@ -43,7 +45,8 @@ var _ = Describe("v1", func() {
 				"format": "int32",
 				"type": "integer",
 				"x-seenMaximum": 1,
-				"x-seenMinimum": 1
+				"x-seenMinimum": 1,
+				"x-stats": {"count": 1, "integer": true, "sum": 1, "m2": 0, "mean": 1, "variance": 0, "positive": {"0": 1}}
 			}
 		},
 		"type": "object",
@@ -70,7 +73,8 @@ var _ = Describe("v1", func() {
 				"format": "int32",
 				"type": "integer",
 				"x-seenMaximum": 1,
-				"x-seenMinimum": 1
+				"x-seenMinimum": 1,
+				"x-stats": {"count": 1, "integer": true, "sum": 1, "m2": 0, "mean": 1, "variance": 0, "positive": {"0": 1}}
 			}
 		},
 		"type": "object",
@@ -90,7 +94,8 @@ var _ = Describe("v1", func() {
 			"format": "int32",
 			"type": "integer",
 			"x-seenMaximum": 1,
-			"x-seenMinimum": 1
+			"x-seenMinimum": 1,
+			"x-stats": {"count": 1, "integer": true, "sum": 1, "m2": 0, "mean": 1, "variance": 0, "positive": {"0": 1}}
 		}
 	},
 	"type": "object",
@@ -98,6 +103,47 @@ var _ = Describe("v1", func() {
 }`))
 		})
 	})
+	Describe("POST /v1/schemamerge", func() {
+		schemagen := func(payloads ...anymap) interface{} {
+			req := NewRequest("POST", "/v1/schemagen", MustMarshal(anymap{
+				"payloads":       payloads,
+				"examples_limit": 5,
+			}), JsonReq())
+			rr := Serve(e, req)
+			Expect(rr).To(HaveResponseCode(200))
+			return MustUnmarshalFrom(rr.Body).(map[string]interface{})["schema"]
+		}
+		It("merges learned schemas as if their payloads were learned together", func() {
+			shard1 := schemagen(anymap{"kind": "alpha", "url": "https://a.example.com/x"}, anymap{"kind": "beta", "url": "https://a.example.com/y"})
+			shard2 := schemagen(anymap{"kind": "gamma", "url": "https://b.example.com/z"}, anymap{"kind": 5})
+			req := NewRequest("POST", "/v1/schemamerge", MustMarshal(anymap{
+				"schemas": []interface{}{shard1, shard2},
+			}), JsonReq())
+			rr := Serve(e, req)
+			Expect(rr).To(HaveResponseCode(200))
+			merged := MustUnmarshalFrom(rr.Body).(map[string]interface{})["schema"].(map[string]interface{})
+			Expect(merged).To(HaveKeyWithValue("x-samples", 4.0))
+			Expect(merged).To(HaveKeyWithValue("examples", HaveLen(3)))
+			Expect(merged["properties"]).To(HaveKeyWithValue("url", SatisfyAll(
+				HaveKeyWithValue("x-samples", 3.0),
+				HaveKeyWithValue("x-uriLocations", ConsistOf("https://a.example.com", "https://b.example.com")),
+			)))
+			Expect(merged["properties"]).To(HaveKeyWithValue("kind", HaveKeyWithValue("oneOf", ContainElement(
+				HaveKeyWithValue("x-seenStrings", ConsistOf("alpha", "beta", "gamma")),
+			))))
+		})
+		It("limits examples if given", func() {
+			shard1 := schemagen(anymap{"x": 1}, anymap{"x": "one"})
+			shard2 := schemagen(anymap{"x": 1}, anymap{"x": true})
+			req := NewRequest("POST", "/v1/schemamerge", MustMarshal(anymap{
+				"schemas":        []interface{}{shard1, shard2},
+				"examples_limit": 1,
+			}), JsonReq())
+			rr := Serve(e, req)
+			Expect(rr).To(HaveResponseCode(200))
+			Expect(MustUnmarshalFrom(rr.Body)).To(HaveKeyWithValue("schema", HaveKeyWithValue("examples", HaveLen(1))))
+		})
+	})
 	Describe("POST /v1/specgen", func() {
 		It("generates specs with no spec input", func() {
 			req := NewRequest("POST", "/v1/specgen", MustMarshal(anymap{
@@ -133,6 +179,139 @@ var _ = Describe("v1", func() {
 			Expect(rr.Body.String()).To(ContainSubstring(`"location": "$message.header#/X-Trace-Id"`))
 			Expect(rr.Body.String()).To(ContainSubstring(`"title": "here is my title"`))
 		})
+		It("generates specs from mqtt events", func() {
+			req := NewRequest("POST", "/v1/specgen", MustMarshal(anymap{
+				"protocol": "mqtt",
+				"mqtt_events": []anymap{
+					{
+						"topic":   "devices/abc123/telemetry",
+						"payload": anymap{"temp": 20},
+						"qos":     1,
+					},
+				},
+			}), JsonReq())
+			rr := Serve(e, req)
+			Expect(rr).To(HaveResponseCode(200))
+			Expect(rr.Body.String()).To(ContainSubstring(`"devices/{deviceId}/telemetry"`))
+			Expect(rr.Body.String()).To(ContainSubstring(`"qos": 1`))
+		})
+		It("generates and updates AsyncAPI 3 specs", func() {
+			event := anymap{
+				"method":  "POST",
+				"path":    "/webhooks/{id}",
+				"headers": anymap{"Host": "api.example.com", "X-GitHub-Event": "push"},
+				"body":    anymap{"ref": "main"},
+			}
+			req := NewRequest("POST", "/v1/specgen", MustMarshal(anymap{
+				"protocol":         "http",
+				"http_events":      []anymap{event},
+				"asyncapi_version": "3.0.0",
+			}), JsonReq())
+			rr := Serve(e, req)
+			Expect(rr).To(HaveResponseCode(200))
+			spec := MustUnmarshalFrom(rr.Body).(map[string]interface{})["specification"]
+			Expect(spec).To(HaveKeyWithValue("asyncapi", "3.0.0"))
+			Expect(spec).To(HaveKeyWithValue("servers", HaveKeyWithValue("api.example.com", SatisfyAll(
+				HaveKeyWithValue("host", "api.example.com"),
+				HaveKeyWithValue("protocol", "http"),
+			))))
+			Expect(spec).To(HaveKeyWithValue("channels", HaveKeyWithValue("webhooks_id", SatisfyAll(
+				HaveKeyWithValue("address", "/webhooks/{id}"),
+				HaveKeyWithValue("messages", HaveKeyWithValue("push", HaveKeyWithValue("name", "push"))),
+			))))
+			Expect(spec).To(HaveKeyWithValue("operations", HaveKeyWithValue("webhooks_id_receive", SatisfyAll(
+				HaveKeyWithValue("action", "receive"),
+				HaveKeyWithValue("channel", map[string]interface{}{"$ref": "#/channels/webhooks_id"}),
+				HaveKeyWithValue("messages", ConsistOf(map[string]interface{}{"$ref": "#/channels/webhooks_id/messages/push"})),
+				HaveKeyWithValue("bindings", HaveKey("http")),
+			))))
+
+			event["headers"] = anymap{"Host": "api.example.com", "X-GitHub-Event": "issues"}
+			req = NewRequest("POST", "/v1/specgen", MustMarshal(anymap{
+				"protocol":      "http",
+				"http_events":   []anymap{event},
+				"specification": spec,
+			}), JsonReq())
+			rr = Serve(e, req)
+			Expect(rr).To(HaveResponseCode(200))
+			spec = MustUnmarshalFrom(rr.Body).(map[string]interface{})["specification"]
+			Expect(spec).To(HaveKeyWithValue("asyncapi", "3.0.0"))
+			Expect(spec).To(HaveKeyWithValue("channels", HaveKeyWithValue("webhooks_id",
+				HaveKeyWithValue("messages", SatisfyAll(HaveKey("push"), HaveKey("issues"))),
+			)))
+			Expect(spec).To(HaveKeyWithValue("operations", HaveKeyWithValue("webhooks_id_receive",
+				HaveKeyWithValue("messages", HaveLen(2)),
+			)))
+		})
+		It("generates OpenAPI webhook documents", func() {
+			req := NewRequest("POST", "/v1/specgen", MustMarshal(anymap{
+				"protocol": "http",
+				"format":   "openapi",
+				"http_events": []anymap{{
+					"method":  "POST",
+					"path":    "/webhooks",
+					"headers": anymap{"Host": "api.example.com", "Authorization": "Bearer abc123", "X-GitHub-Event": "push"},
+					"body":    anymap{"ref": "main"},
+				}},
+			}), JsonReq())
+			rr := Serve(e, req)
+			Expect(rr).To(HaveResponseCode(200))
+			doc := MustUnmarshalFrom(rr.Body).(map[string]interface{})["specification"]
+			Expect(doc).To(HaveKeyWithValue("openapi", "3.1.0"))
+			Expect(doc).To(HaveKeyWithValue("components", HaveKeyWithValue("securitySchemes", HaveKeyWithValue("bearerAuth", map[string]interface{}{
+				"type":   "http",
+				"scheme": "bearer",
+			}))))
+			Expect(doc).To(HaveKeyWithValue("webhooks", HaveKeyWithValue("/webhooks", HaveKeyWithValue("post", SatisfyAll(
+				HaveKeyWithValue("operationId", "post_webhooks"),
+				HaveKeyWithValue("security", ConsistOf(map[string]interface{}{"bearerAuth": []interface{}{}})),
+				HaveKeyWithValue("parameters", ContainElement(SatisfyAll(
+					HaveKeyWithValue("name", "X-GitHub-Event"),
+					HaveKeyWithValue("schema", map[string]interface{}{"type": "string", "enum": []interface{}{"push"}}),
+				))),
+				HaveKeyWithValue("requestBody", HaveKeyWithValue("content", HaveKeyWithValue("application/json", HaveKeyWithValue("schema", SatisfyAll(
+					HaveKeyWithValue("title", "push"),
+					HaveKeyWithValue("properties", HaveKeyWithValue("ref", map[string]interface{}{"type": "string"})),
+				))))),
+			)))))
+		})
+		It("describes structured CloudEvents envelopes in OpenAPI documents", func() {
+			event := func(typ string, data anymap) anymap {
+				return anymap{
+					"method":  "POST",
+					"path":    "/events",
+					"headers": anymap{"Host": "api.example.com", "Content-Type": "application/cloudevents+json"},
+					"body": anymap{
+						"specversion": "1.0", "type": typ, "source": "/orders", "id": "A234-1234", "subject": "5",
+						"data": data,
+					},
+				}
+			}
+			req := NewRequest("POST", "/v1/specgen", MustMarshal(anymap{
+				"protocol":    "http",
+				"format":      "openapi",
+				"http_events": []anymap{event("order.created", anymap{"order_id": 5}), event("order.shipped", anymap{"carrier": "ups"})},
+			}), JsonReq())
+			rr := Serve(e, req)
+			Expect(rr).To(HaveResponseCode(200))
+			doc := MustUnmarshalFrom(rr.Body).(map[string]interface{})["specification"]
+			Expect(doc).To(HaveKeyWithValue("webhooks", HaveKeyWithValue("/events", HaveKeyWithValue("post",
+				HaveKeyWithValue("requestBody", HaveKeyWithValue("content", HaveKeyWithValue("application/cloudevents+json",
+					HaveKeyWithValue("schema", HaveKeyWithValue("oneOf", ContainElement(SatisfyAll(
+						HaveKeyWithValue("title", "order.created"),
+						HaveKeyWithValue("required", ConsistOf("id", "source", "specversion", "type")),
+						HaveKeyWithValue("properties", SatisfyAll(
+							HaveKeyWithValue("type", map[string]interface{}{"type": "string", "const": "order.created"}),
+							HaveKeyWithValue("specversion", HaveKeyWithValue("type", "string")),
+							HaveKeyWithValue("source", HaveKeyWithValue("type", "string")),
+							HaveKeyWithValue("id", HaveKeyWithValue("type", "string")),
+							HaveKeyWithValue("subject", HaveKeyWithValue("type", "string")),
+							HaveKeyWithValue("data", HaveKeyWithValue("properties", HaveKey("order_id"))),
+						)),
+					))),
+					))),
+				)))))
+		})
 	})
 	Describe("POST /v1/datagen", func() {
 		It("generates fixtured data", func() {
@@ -185,5 +364,77 @@ var _ = Describe("v1", func() {
         ]
       }`))
 		})
+		It("generates nullable values as null with the max null mode", func() {
+			req := NewRequest("POST", "/v1/datagen", MustMarshal(anymap{
+				"schema": anymap{
+					"type":       "object",
+					"properties": anymap{"x": anymap{"type": "integer", "x-nullable": true, "x-seenMinimum": 1, "x-seenMaximum": 1}},
+				},
+				"count": 1,
+				"nulls": "max",
+			}), JsonReq())
+			rr := Serve(e, req)
+			Expect(rr).To(HaveResponseCode(200))
+			Expect(rr.Body.String()).To(MatchJSON(`{"items": [{"x": null}]}`))
+
+			req = NewRequest("POST", "/v1/datagen", MustMarshal(anymap{"nulls": "sometimes"}), JsonReq())
+			Expect(Serve(e, req)).To(HaveResponseCode(400))
+		})
+	})
+	Describe("POST /v1/anomalies", func() {
+		It("scores payloads that do not fit the schema", func() {
+			req := NewRequest("POST", "/v1/anomalies", MustMarshal(anymap{
+				"schema": anymap{
+					"type":       "object",
+					"x-samples":  9,
+					"properties": anymap{"x": anymap{"type": "integer", "x-samples": 9}},
+				},
+				"payloads": []anymap{{"x": 1}, {"x": nil}},
+			}), JsonReq())
+			rr := Serve(e, req)
+			Expect(rr).To(HaveResponseCode(200))
+			Expect(rr.Body.String()).To(MatchJSON(`{
+	"payloads": [
+		{
+			"index": 1,
+			"score": 1.5,
+			"reasons": [
+				{"path": "$.x", "kind": "unexpected_null", "score": 1.5, "message": "unexpected null"}
+			]
+		}
+	],
+	"scored": 2,
+	"max_score": 1.5
+}`))
+		})
+	})
+	Describe("POST /v1/schemaprune", func() {
+		It("forgets what was not seen within the window", func() {
+			recent := time.Now().UTC().Format(time.RFC3339)
+			req := NewRequest("POST", "/v1/schemagen", MustMarshal(anymap{
+				"payloads":              []anymap{{"old": 1, "at": "2020-01-01T00:00:00Z"}, {"new": 1, "at": recent}},
+				"provenance_time_field": "at",
+			}), JsonReq())
+			rr := Serve(e, req)
+			Expect(rr).To(HaveResponseCode(200))
+			var learned anymap
+			Expect(json.Unmarshal(rr.Body.Bytes(), &learned)).To(Succeed())
+
+			req = NewRequest("POST", "/v1/schemaprune", MustMarshal(anymap{
+				"schema": learned["schema"],
+				"window": "720h",
+			}), JsonReq())
+			rr = Serve(e, req)
+			Expect(rr).To(HaveResponseCode(200))
+			var pruned anymap
+			Expect(json.Unmarshal(rr.Body.Bytes(), &pruned)).To(Succeed())
+			Expect(pruned["pruned"]).To(ConsistOf(HaveKeyWithValue("path", "$.old")))
+			Expect(pruned["schema"]).To(HaveKeyWithValue("properties", And(HaveKey("new"), Not(HaveKey("old")))))
+		})
+		It("errors for an invalid window", func() {
+			req := NewRequest("POST", "/v1/schemaprune", MustMarshal(anymap{"schema": anymap{}, "window": "a month"}), JsonReq())
+			rr := Serve(e, req)
+			Expect(rr).To(HaveResponseCode(400))
+		})
 	})
 })